@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/* =========================
+   OVERDUE & AGING
+========================= */
+
+const overdueCheckInterval = time.Hour
+
+// Счёт просрочен, если сумма частей со сроком до сегодняшнего дня больше
+// оплаченного, а сам счёт оплачен не полностью. Счёт без графика — одна
+// часть со сроком due_date. Кредит-нота вычитается только из оплаченного:
+// начисление по графику она не уменьшает, потому что applyCredit снимает
+// её с последних частей, срок которых ещё не наступил. Сумма наступивших
+// частей от неё не меняется, а возвращённые деньги больше не считаются
+// оплатой.
+// $1, $2 — статусы открытого счёта.
+const invoiceOverdueCondition = `(i.status IN ($1, $2) AND
+	i.amount > COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) AND
+	CASE WHEN EXISTS (SELECT 1 FROM invoice_installments ii WHERE ii.invoice_id = i.id)
+		THEN COALESCE((SELECT SUM(ii.amount) FROM invoice_installments ii
+			WHERE ii.invoice_id = i.id AND ii.due_date < CURRENT_DATE), 0)
+		ELSE CASE WHEN NULLIF(i.due_date::text, '')::date < CURRENT_DATE THEN i.amount ELSE 0 END
+	END > COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) - ` + invoiceCreditSQL + `)`
+
+// Обновляет признак просрочки у всех счетов. Вызывается периодической задачей.
+func markOverdueInvoices() (int64, error) {
+	result, err := db.Exec(`
+		UPDATE invoices i SET overdue = `+invoiceOverdueCondition+`
+		WHERE i.overdue IS DISTINCT FROM `+invoiceOverdueCondition,
+		invoiceStatusPending, invoiceStatusPartial)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Периодическая задача invoices.mark_overdue.
+func markOverdueJob(ctx context.Context, j *Job) error {
+	n, err := markOverdueInvoices()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("⏰ %d ta invoyisning muddat holati yangilandi", n)
+	}
+	return nil
+}
+
+type AgingBuckets struct {
+	Current    Money `json:"current"`
+	Days0to30  Money `json:"0_30"`
+	Days31to60 Money `json:"31_60"`
+	Days61to90 Money `json:"61_90"`
+	Days90Plus Money `json:"90_plus"`
+	Overdue    Money `json:"overdue"`
+	Total      Money `json:"total"`
+}
+
+func (b *AgingBuckets) add(amount Money, daysLate int) {
+	switch {
+	case daysLate <= 0:
+		b.Current += amount
+	case daysLate <= 30:
+		b.Days0to30 += amount
+	case daysLate <= 60:
+		b.Days31to60 += amount
+	case daysLate <= 90:
+		b.Days61to90 += amount
+	default:
+		b.Days90Plus += amount
+	}
+	if daysLate > 0 {
+		b.Overdue += amount
+	}
+	b.Total += amount
+}
+
+type AgingRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	AgingBuckets
+}
+
+type Debtor struct {
+	StudentJSHSHIR string   `json:"student_jshshir"`
+	StudentName    string   `json:"student_name"`
+	StudentPhone   string   `json:"student_phone"`
+	GroupName      string   `json:"group_name,omitempty"`
+	Outstanding    Money    `json:"outstanding"`
+	Overdue        Money    `json:"overdue"`
+	MaxDaysLate    int      `json:"max_days_late"`
+	Invoices       []string `json:"invoices"`
+}
+
+type AgingReport struct {
+	AsOf     string       `json:"as_of"`
+	Totals   AgingBuckets `json:"totals"`
+	ByCourse []AgingRow   `json:"by_course"`
+	ByGroup  []AgingRow   `json:"by_group"`
+	Debtors  []Debtor     `json:"debtors"`
+}
+
+type agingInvoice struct {
+	id                int
+	number            string
+	jshshir, name     string
+	phone, dueDate    string
+	amount, paid      Money
+	credit            Money
+	groupID, courseID int
+	groupName, course string
+	installments      []Installment
+}
+
+// GET /api/reports/aging?as_of=YYYY-MM-DD
+// Непогашенный остаток каждой части графика попадает в корзину по числу
+// дней просрочки на дату as_of; платежи после этой даты не учитываются.
+// Курс и группа берутся по последней группе студента.
+func agingReport(w http.ResponseWriter, r *http.Request) {
+	asOf := r.URL.Query().Get("as_of")
+	if asOf == "" {
+		asOf = time.Now().Format("2006-01-02")
+	}
+	asOfDate, err := time.Parse("2006-01-02", asOf)
+	if err != nil {
+		http.Error(w, "Noto'g'ri sana (YYYY-MM-DD)", 400)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT i.id, COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')),
+			i.student_jshshir, COALESCE(s.full_name, i.student_name, ''), COALESCE(s.phone, ''),
+			COALESCE(i.due_date::text, ''), i.amount,
+			COALESCE((SELECT SUM(p.amount) FROM payments p
+				WHERE p.invoice_id = i.id AND p.paid_at <= $1::date), 0),
+			COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn
+				WHERE cn.invoice_id = i.id AND cn.issued_at <= $1::date), 0),
+			COALESCE(g.id, 0), COALESCE(g.name, ''), COALESCE(c.id, 0), COALESCE(c.name, '')
+		FROM invoices i
+		LEFT JOIN students s ON s.jshshir = i.student_jshshir
+		LEFT JOIN LATERAL (
+			SELECT sg.id, sg.name, sg.course_id
+			FROM group_students gs
+			JOIN study_groups sg ON sg.id = gs.group_id
+			WHERE gs.student_jshshir = i.student_jshshir
+			ORDER BY sg.start_date DESC NULLS LAST, sg.id DESC
+			LIMIT 1
+		) g ON TRUE
+		LEFT JOIN courses c ON c.id = g.course_id
+		WHERE i.status <> $2 AND i.created_at::date <= $1::date
+		ORDER BY i.id`, asOf, invoiceStatusCancelled)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	var invoices []*agingInvoice
+	byID := map[int]*agingInvoice{}
+	var ids []int64
+	for rows.Next() {
+		inv := &agingInvoice{}
+		if err := rows.Scan(&inv.id, &inv.number, &inv.jshshir, &inv.name, &inv.phone,
+			&inv.dueDate, &inv.amount, &inv.paid, &inv.credit,
+			&inv.groupID, &inv.groupName, &inv.courseID, &inv.course); err != nil {
+			log.Printf("Error scanning aging invoice: %v", err)
+			continue
+		}
+		if inv.paid >= inv.amount {
+			continue
+		}
+		invoices = append(invoices, inv)
+		byID[inv.id] = inv
+		ids = append(ids, int64(inv.id))
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	irows, err := db.Query(`
+		SELECT invoice_id, seq, amount, to_char(due_date, 'YYYY-MM-DD')
+		FROM invoice_installments WHERE invoice_id = ANY($1)
+		ORDER BY invoice_id, seq`, pq.Int64Array(ids))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer irows.Close()
+	for irows.Next() {
+		var inst Installment
+		if err := irows.Scan(&inst.InvoiceID, &inst.Seq, &inst.Amount, &inst.DueDate); err != nil {
+			log.Printf("Error scanning installment: %v", err)
+			continue
+		}
+		inv := byID[inst.InvoiceID]
+		inv.installments = append(inv.installments, inst)
+	}
+
+	report := AgingReport{AsOf: asOf, ByCourse: []AgingRow{}, ByGroup: []AgingRow{}, Debtors: []Debtor{}}
+	courses := map[int]*AgingRow{}
+	groups := map[int]*AgingRow{}
+	debtors := map[string]*Debtor{}
+	var courseOrder, groupOrder []int
+	var debtorOrder []string
+
+	for _, inv := range invoices {
+		insts := inv.installments
+		if len(insts) == 0 {
+			insts = []Installment{{Seq: 1, Amount: inv.amount, DueDate: inv.dueDate}}
+		}
+		applyCredit(insts, inv.credit)
+		allocateInstallments(insts, inv.paid-inv.credit, false, asOf)
+
+		course, ok := courses[inv.courseID]
+		if !ok {
+			course = &AgingRow{ID: inv.courseID, Name: inv.course}
+			courses[inv.courseID] = course
+			courseOrder = append(courseOrder, inv.courseID)
+		}
+		group, ok := groups[inv.groupID]
+		if !ok {
+			group = &AgingRow{ID: inv.groupID, Name: inv.groupName}
+			groups[inv.groupID] = group
+			groupOrder = append(groupOrder, inv.groupID)
+		}
+		debtor, ok := debtors[inv.jshshir]
+		if !ok {
+			debtor = &Debtor{
+				StudentJSHSHIR: inv.jshshir,
+				StudentName:    inv.name,
+				StudentPhone:   inv.phone,
+				GroupName:      inv.groupName,
+			}
+			debtors[inv.jshshir] = debtor
+			debtorOrder = append(debtorOrder, inv.jshshir)
+		}
+
+		late := false
+		for _, inst := range insts {
+			rest := inst.Amount - inst.PaidAmount
+			if rest <= 0 {
+				continue
+			}
+			days := 0
+			if due, err := time.Parse("2006-01-02", inst.DueDate); err == nil {
+				days = int(asOfDate.Sub(due).Hours() / 24)
+			}
+			report.Totals.add(rest, days)
+			course.add(rest, days)
+			group.add(rest, days)
+
+			debtor.Outstanding += rest
+			if days > 0 {
+				late = true
+				debtor.Overdue += rest
+				if days > debtor.MaxDaysLate {
+					debtor.MaxDaysLate = days
+				}
+			}
+		}
+		if late {
+			debtor.Invoices = append(debtor.Invoices, inv.number)
+		}
+	}
+
+	for _, id := range courseOrder {
+		report.ByCourse = append(report.ByCourse, *courses[id])
+	}
+	for _, id := range groupOrder {
+		report.ByGroup = append(report.ByGroup, *groups[id])
+	}
+	// В список должников попадают только студенты с просрочкой
+	for _, jshshir := range debtorOrder {
+		if d := debtors[jshshir]; d.Overdue > 0 {
+			report.Debtors = append(report.Debtors, *d)
+		}
+	}
+	sort.Slice(report.Debtors, func(i, j int) bool {
+		return report.Debtors[i].Overdue > report.Debtors[j].Overdue
+	})
+
+	respondJSON(w, report)
+}
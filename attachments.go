@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   ATTACHMENTS
+========================= */
+
+// Файлы студентов (фото, паспорт, медсправка) и документов (сканы).
+// Содержимое лежит в FileStorage, в таблице attachments — описание
+// и ключи хранилища. Тип файла определяется по содержимому, а не по
+// заголовку клиента; для изображений сохраняется миниатюра.
+//
+//	ATTACHMENT_MAX_MB — наибольший размер файла (по умолчанию 10).
+
+const (
+	attachmentOwnerStudent  = "student"
+	attachmentOwnerDocument = "document"
+)
+
+const (
+	attachmentPhoto    = "photo"
+	attachmentPassport = "passport"
+	attachmentMedical  = "medical"
+	attachmentScan     = "scan"
+	attachmentOther    = "other"
+)
+
+// Виды вложений по владельцу.
+var attachmentKinds = map[string][]string{
+	attachmentOwnerStudent:  {attachmentPhoto, attachmentPassport, attachmentMedical, attachmentOther},
+	attachmentOwnerDocument: {attachmentPhoto, attachmentScan, attachmentOther},
+}
+
+// Допустимые типы и расширение, под которым файл сохраняется.
+var attachmentMIMETypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"application/pdf": ".pdf",
+}
+
+const (
+	thumbnailSize = 240
+	// Фото на свидетельстве уменьшается до этого размера по большей стороне
+	certificatePhotoSize = 600
+	// Защита от «бомб»: картинка 10000×10000 в маленьком PNG
+	maxImagePixels = 40_000_000
+)
+
+var fileStorage FileStorage
+
+var errAttachmentNotFound = errors.New("Fayl topilmadi")
+
+func initStorage() error {
+	s, err := newFileStorage()
+	if err != nil {
+		return err
+	}
+	fileStorage = s
+	log.Printf("Fayl ombori: %s", s.Name())
+	return nil
+}
+
+func isAttachmentKind(ownerType, kind string) bool {
+	for _, k := range attachmentKinds[ownerType] {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentMaxBytes() int64 {
+	if n, err := strconv.Atoi(envDefault("ATTACHMENT_MAX_MB", "10")); err == nil && n > 0 {
+		return int64(n) << 20
+	}
+	return 10 << 20
+}
+
+type Attachment struct {
+	ID          int    `json:"id"`
+	OwnerType   string `json:"owner_type"`
+	OwnerRef    string `json:"owner_ref"`
+	Kind        string `json:"kind"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	HasThumb    bool   `json:"has_thumbnail"`
+	UploadedBy  string `json:"uploaded_by,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	storageKey  string
+	thumbKey    string
+}
+
+/* ---------- изображения ---------- */
+
+func decodeImage(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("Rasm juda katta: %d×%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Уменьшает картинку так, чтобы большая сторона была не больше max:
+// каждый пиксель результата — среднее по своему прямоугольнику исходника.
+func scaleDown(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	nw, nh := max, h*max/w
+	if h > w {
+		nw, nh = w*max/h, max
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+func makeThumbnail(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, flatten(scaleDown(img, thumbnailSize)), &jpeg.Options{Quality: 80})
+	return buf.Bytes(), err
+}
+
+// Прозрачные области PNG/GIF в JPEG становятся белыми, а не чёрными.
+func flatten(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			i := dst.PixOffset(x, y)
+			white := 0xffff - a
+			dst.Pix[i+0] = uint8((r + white) >> 8)
+			dst.Pix[i+1] = uint8((g + white) >> 8)
+			dst.Pix[i+2] = uint8((bl + white) >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+/* ---------- загрузка ---------- */
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func attachmentOwnerExists(ownerType, ref string) (bool, error) {
+	var exists bool
+	var err error
+	switch ownerType {
+	case attachmentOwnerStudent:
+		err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, ref).Scan(&exists)
+	case attachmentOwnerDocument:
+		err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM documents WHERE id::text=$1)`, ref).Scan(&exists)
+	}
+	return exists, err
+}
+
+func cleanFilename(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" || name == "" {
+		return "fayl"
+	}
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return name
+}
+
+// Проверяет файл и сохраняет его вместе с миниатюрой. При ошибке записи
+// в базу уже сохранённые объекты удаляются.
+func storeAttachment(ctx context.Context, a *Attachment, data []byte) (int, error) {
+	if !isAttachmentKind(a.OwnerType, a.Kind) {
+		return 400, fmt.Errorf("Fayl turi noto'g'ri (%s)", strings.Join(attachmentKinds[a.OwnerType], ", "))
+	}
+	if len(data) == 0 {
+		return 400, errors.New("Fayl bo'sh")
+	}
+	if max := attachmentMaxBytes(); int64(len(data)) > max {
+		return 413, fmt.Errorf("Fayl hajmi %d MB dan oshmasligi kerak", max>>20)
+	}
+	ct := http.DetectContentType(data)
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = ct[:i]
+	}
+	ext, ok := attachmentMIMETypes[ct]
+	if !ok {
+		return 415, fmt.Errorf("Bu turdagi fayl qabul qilinmaydi (%s): faqat JPEG, PNG, GIF, PDF", ct)
+	}
+	isImage := strings.HasPrefix(ct, "image/")
+	if a.Kind == attachmentPhoto && !isImage {
+		return 415, errors.New("Surat JPEG, PNG yoki GIF bo'lishi kerak")
+	}
+
+	var thumb []byte
+	if isImage {
+		img, err := decodeImage(data)
+		if err != nil {
+			return 415, fmt.Errorf("Rasmni o'qib bo'lmadi: %v", err)
+		}
+		a.Width, a.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		if thumb, err = makeThumbnail(img); err != nil {
+			return 500, err
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	a.ContentType = ct
+	a.Size = len(data)
+	a.SHA256 = hex.EncodeToString(sum[:])
+	base := fmt.Sprintf("%ss/%s/%s-%s", a.OwnerType, unsafeKeyChars.ReplaceAllString(a.OwnerRef, "_"),
+		time.Now().Format("20060102"), randomToken(8))
+	a.storageKey = base + ext
+
+	if err := fileStorage.Put(ctx, a.storageKey, ct, data); err != nil {
+		return 502, fmt.Errorf("Faylni saqlab bo'lmadi: %v", err)
+	}
+	if thumb != nil {
+		a.thumbKey = base + ".thumb.jpg"
+		if err := fileStorage.Put(ctx, a.thumbKey, "image/jpeg", thumb); err != nil {
+			fileStorage.Delete(ctx, a.storageKey)
+			return 502, fmt.Errorf("Miniatyurani saqlab bo'lmadi: %v", err)
+		}
+		a.HasThumb = true
+	}
+
+	err := db.QueryRow(`
+		INSERT INTO attachments
+			(owner_type, owner_ref, kind, filename, content_type, size, sha256,
+			 width, height, storage_key, thumb_key, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+		a.OwnerType, a.OwnerRef, a.Kind, a.Filename, a.ContentType, a.Size, a.SHA256,
+		nullIfZero(a.Width), nullIfZero(a.Height), a.storageKey, nullIfEmpty(a.thumbKey),
+		nullIfEmpty(a.UploadedBy),
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		fileStorage.Delete(ctx, a.storageKey)
+		if a.thumbKey != "" {
+			fileStorage.Delete(ctx, a.thumbKey)
+		}
+		return 500, err
+	}
+	return 201, nil
+}
+
+// POST multipart/form-data: file, kind, uploaded_by.
+func attachmentUploadHandler(ownerType, param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref := mux.Vars(r)[param]
+		exists, err := attachmentOwnerExists(ownerType, ref)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		} else if !exists {
+			if ownerType == attachmentOwnerStudent {
+				http.Error(w, "Talaba topilmadi", 404)
+			} else {
+				http.Error(w, "Guvohnoma topilmadi", 404)
+			}
+			return
+		}
+
+		max := attachmentMaxBytes()
+		r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			http.Error(w, fmt.Sprintf("Fayl yuklanmadi (hajmi %d MB dan oshmasligi kerak)", max>>20), 413)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file maydoni topilmadi", 400)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, max+1))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		a := Attachment{
+			OwnerType:  ownerType,
+			OwnerRef:   ref,
+			Kind:       strings.ToLower(strings.TrimSpace(r.FormValue("kind"))),
+			Filename:   cleanFilename(header.Filename),
+			UploadedBy: strings.TrimSpace(r.FormValue("uploaded_by")),
+		}
+		if a.Kind == "" {
+			a.Kind = attachmentOther
+		}
+		code, err := storeAttachment(r.Context(), &a, data)
+		if err != nil {
+			log.Printf("Fayl yuklash xatosi (%s %s): %v", ownerType, ref, err)
+			http.Error(w, err.Error(), code)
+			return
+		}
+		log.Printf("📎 Fayl #%d yuklandi: %s %s, %s (%d bayt)", a.ID, ownerType, ref, a.Kind, a.Size)
+		w.WriteHeader(http.StatusCreated)
+		respondJSON(w, a)
+	}
+}
+
+/* ---------- чтение и удаление ---------- */
+
+const attachmentColumns = `
+	id, owner_type, owner_ref, kind, filename, content_type, size, sha256,
+	COALESCE(width, 0), COALESCE(height, 0), thumb_key IS NOT NULL, COALESCE(uploaded_by, ''),
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'), storage_key, COALESCE(thumb_key, '')`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }, a *Attachment) error {
+	return row.Scan(&a.ID, &a.OwnerType, &a.OwnerRef, &a.Kind, &a.Filename, &a.ContentType,
+		&a.Size, &a.SHA256, &a.Width, &a.Height, &a.HasThumb, &a.UploadedBy, &a.CreatedAt,
+		&a.storageKey, &a.thumbKey)
+}
+
+func loadAttachment(id int) (Attachment, error) {
+	var a Attachment
+	err := scanAttachment(db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id=$1`, id), &a)
+	if err == sql.ErrNoRows {
+		return a, errAttachmentNotFound
+	}
+	return a, err
+}
+
+// GET /api/students/{jshshir}/attachments?kind=
+func attachmentsListHandler(ownerType, param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`SELECT `+attachmentColumns+`
+			FROM attachments
+			WHERE owner_type=$1 AND owner_ref=$2 AND ($3 = '' OR kind = $3)
+			ORDER BY id DESC`, ownerType, mux.Vars(r)[param], r.URL.Query().Get("kind"))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer rows.Close()
+
+		list := []Attachment{}
+		for rows.Next() {
+			var a Attachment
+			if err := scanAttachment(rows, &a); err != nil {
+				log.Printf("Error scanning attachment: %v", err)
+				continue
+			}
+			list = append(list, a)
+		}
+		respondJSON(w, list)
+	}
+}
+
+func attachmentFromPath(w http.ResponseWriter, r *http.Request) (Attachment, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return Attachment{}, false
+	}
+	a, err := loadAttachment(id)
+	if err == errAttachmentNotFound {
+		http.Error(w, err.Error(), 404)
+		return a, false
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return a, false
+	}
+	return a, true
+}
+
+func attachmentGet(w http.ResponseWriter, r *http.Request) {
+	if a, ok := attachmentFromPath(w, r); ok {
+		respondJSON(w, a)
+	}
+}
+
+func serveStoredFile(w http.ResponseWriter, r *http.Request, key, contentType, filename string) {
+	data, err := fileStorage.Get(r.Context(), key)
+	if err == errFileNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		log.Printf("Faylni o'qish xatosi %s: %v", key, err)
+		http.Error(w, "Faylni o'qib bo'lmadi", 502)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	w.Write(data)
+}
+
+// GET /api/attachments/{id}/download
+func attachmentDownload(w http.ResponseWriter, r *http.Request) {
+	if a, ok := attachmentFromPath(w, r); ok {
+		serveStoredFile(w, r, a.storageKey, a.ContentType, a.Filename)
+	}
+}
+
+// GET /api/attachments/{id}/thumbnail — только для изображений.
+func attachmentThumbnail(w http.ResponseWriter, r *http.Request) {
+	a, ok := attachmentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if a.thumbKey == "" {
+		http.Error(w, "Bu fayl uchun miniatyura yo'q", 404)
+		return
+	}
+	serveStoredFile(w, r, a.thumbKey, "image/jpeg", strings.TrimSuffix(a.Filename, filepath.Ext(a.Filename))+"-thumb.jpg")
+}
+
+// DELETE /api/attachments/{id}
+func attachmentDelete(w http.ResponseWriter, r *http.Request) {
+	a, ok := attachmentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM attachments WHERE id=$1`, a.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	keys := []string{a.storageKey}
+	if a.thumbKey != "" {
+		keys = append(keys, a.thumbKey)
+	}
+	removeStoredFiles(r.Context(), keys)
+	log.Printf("Fayl #%d o'chirildi (%s %s, %s)", a.ID, a.OwnerType, a.OwnerRef, a.Kind)
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+/* ---------- удаление вместе с владельцем ---------- */
+
+// У attachments нет внешнего ключа на владельца, поэтому при удалении
+// студента или документа записи удаляются в той же транзакции. Возвращает
+// ключи объектов, которые нужно убрать из хранилища после коммита.
+func deleteOwnerAttachments(tx *sql.Tx, ownerType, ownerRef string) ([]string, error) {
+	rows, err := tx.Query(`
+		DELETE FROM attachments WHERE owner_type=$1 AND owner_ref=$2
+		RETURNING storage_key, COALESCE(thumb_key, '')`, ownerType, ownerRef)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key, thumb string
+		if err := rows.Scan(&key, &thumb); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if thumb != "" {
+			keys = append(keys, thumb)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// Ошибки хранилища только логируются: записи уже удалены.
+func removeStoredFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := fileStorage.Delete(ctx, key); err != nil {
+			log.Printf("Faylni ombordan o'chirish xatosi %s: %v", key, err)
+		}
+	}
+}
+
+/* ---------- фото на свидетельстве ---------- */
+
+// Фото для свидетельства: загруженное к самому документу, иначе
+// последнее фото студента. id=0 — фото нет.
+func certificatePhoto(docID int, jshshir string) (id int, key string, err error) {
+	err = db.QueryRow(`
+		SELECT id, storage_key FROM attachments
+		WHERE kind=$1 AND ((owner_type=$2 AND owner_ref=$3) OR (owner_type=$4 AND owner_ref=$5))
+		ORDER BY owner_type=$2 DESC, id DESC
+		LIMIT 1`,
+		attachmentPhoto, attachmentOwnerDocument, strconv.Itoa(docID),
+		attachmentOwnerStudent, jshshir,
+	).Scan(&id, &key)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return id, key, err
+}
+
+// Фото для PDF свидетельства, уменьшенное; nil — фото нет.
+func loadCertificatePhoto(doc DocumentOutput) (image.Image, error) {
+	id, key, err := certificatePhoto(doc.ID, doc.StudentJSHSHIR)
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	data, err := fileStorage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return scaleDown(img, certificatePhotoSize), nil
+}
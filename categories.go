@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   MACHINE CATEGORIES
+========================= */
+
+type MachineCategory struct {
+	Code           string `json:"code"`
+	DescriptionUz  string `json:"description_uz"`
+	DescriptionRu  string `json:"description_ru"`
+	TrainingHours  int    `json:"training_hours"`
+	ValidityMonths *int   `json:"validity_months"` // nil — бессрочно
+	Active         bool   `json:"active"`
+
+	// Требования к допуску: минимальный возраст (nil — без ограничения)
+	// и действующая медицинская справка
+	MinAge          *int `json:"min_age"`
+	MedicalRequired bool `json:"medical_required"`
+}
+
+var categoryCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,3}$`)
+
+// Список категорий документа. Из JSON принимается как массив ["A","B"],
+// так и старая строка "A, B"; коды приводятся к верхнему регистру,
+// повторы отбрасываются.
+type CategoryList []string
+
+func (c *CategoryList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("categories: expected string or array")
+		}
+		list = strings.Split(s, ",")
+	}
+	*c = normalizeCategoryCodes(list)
+	return nil
+}
+
+func (c CategoryList) String() string {
+	return strings.Join(c, ",")
+}
+
+func normalizeCategoryCodes(list []string) CategoryList {
+	seen := map[string]bool{}
+	out := CategoryList{}
+	for _, code := range list {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		out = append(out, code)
+	}
+	return out
+}
+
+// Проверяет категории документа по справочнику: каждая должна быть
+// в каталоге и активна, а часов курса — не меньше, чем требует
+// самая «тяжёлая» из выбранных категорий.
+func validateCategories(codes CategoryList, courseHours int) error {
+	if len(codes) == 0 {
+		return errors.New("Kamida bitta toifa ko'rsatilishi kerak")
+	}
+
+	required := 0
+	for _, code := range codes {
+		var hours int
+		var active bool
+		err := db.QueryRow(`SELECT training_hours, active FROM machine_categories WHERE code=$1`,
+			code).Scan(&hours, &active)
+		if err == sql.ErrNoRows || (err == nil && !active) {
+			return fmt.Errorf("Noma'lum toifa: %s", code)
+		} else if err != nil {
+			return err
+		}
+		if hours > required {
+			required = hours
+		}
+	}
+
+	if courseHours < required {
+		return fmt.Errorf("Tanlangan toifalar uchun kamida %d soat o'qish kerak", required)
+	}
+	return nil
+}
+
+// Проверяет, что все коды есть в справочнике и активны; часы не учитываются.
+func checkCategoryCodes(codes CategoryList) error {
+	for _, code := range codes {
+		var active bool
+		err := db.QueryRow(`SELECT active FROM machine_categories WHERE code=$1`, code).Scan(&active)
+		if err == sql.ErrNoRows || (err == nil && !active) {
+			return fmt.Errorf("Noma'lum toifa: %s", code)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func categoriesList(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT code, description_uz, description_ru, training_hours, validity_months, active,
+		min_age, medical_required FROM machine_categories`
+	if r.URL.Query().Get("all") == "" {
+		query += ` WHERE active`
+	}
+	query += ` ORDER BY code`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []MachineCategory{}
+	for rows.Next() {
+		var c MachineCategory
+		if err := rows.Scan(&c.Code, &c.DescriptionUz, &c.DescriptionRu, &c.TrainingHours, &c.ValidityMonths, &c.Active,
+			&c.MinAge, &c.MedicalRequired); err != nil {
+			log.Printf("Error scanning category: %v", err)
+			continue
+		}
+		list = append(list, c)
+	}
+
+	respondJSON(w, list)
+}
+
+func categoryGet(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(mux.Vars(r)["code"])
+
+	var c MachineCategory
+	err := db.QueryRow(`
+		SELECT code, description_uz, description_ru, training_hours, validity_months, active,
+		       min_age, medical_required
+		FROM machine_categories WHERE code=$1`, code,
+	).Scan(&c.Code, &c.DescriptionUz, &c.DescriptionRu, &c.TrainingHours, &c.ValidityMonths, &c.Active,
+		&c.MinAge, &c.MedicalRequired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Toifa topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	respondJSON(w, c)
+}
+
+func categoryCreate(w http.ResponseWriter, r *http.Request) {
+	input := MachineCategory{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+	if !categoryCodePattern.MatchString(input.Code) {
+		http.Error(w, "Noto'g'ri toifa kodi", 400)
+		return
+	}
+	if input.TrainingHours < 0 {
+		http.Error(w, "O'qish soatlari manfiy bo'lishi mumkin emas", 400)
+		return
+	}
+	if input.ValidityMonths != nil && *input.ValidityMonths <= 0 {
+		http.Error(w, "Amal qilish muddati musbat bo'lishi kerak", 400)
+		return
+	}
+	if input.MinAge != nil && (*input.MinAge <= 0 || *input.MinAge > 100) {
+		http.Error(w, "Minimal yosh noto'g'ri", 400)
+		return
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO machine_categories (code, description_uz, description_ru, training_hours, validity_months, active,
+		                                min_age, medical_required)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		input.Code, input.DescriptionUz, input.DescriptionRu, input.TrainingHours,
+		input.ValidityMonths, input.Active, input.MinAge, input.MedicalRequired,
+	)
+	if err != nil {
+		log.Printf("Toifa yaratish xatosi: %v", err)
+		http.Error(w, "Toifa yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, input)
+}
+
+func categoryUpdate(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(mux.Vars(r)["code"])
+
+	input := MachineCategory{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if input.TrainingHours < 0 {
+		http.Error(w, "O'qish soatlari manfiy bo'lishi mumkin emas", 400)
+		return
+	}
+	if input.ValidityMonths != nil && *input.ValidityMonths <= 0 {
+		http.Error(w, "Amal qilish muddati musbat bo'lishi kerak", 400)
+		return
+	}
+	if input.MinAge != nil && (*input.MinAge <= 0 || *input.MinAge > 100) {
+		http.Error(w, "Minimal yosh noto'g'ri", 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE machine_categories
+		SET description_uz=$1, description_ru=$2, training_hours=$3, validity_months=$4, active=$5,
+		    min_age=$6, medical_required=$7
+		WHERE code=$8`,
+		input.DescriptionUz, input.DescriptionRu, input.TrainingHours,
+		input.ValidityMonths, input.Active, input.MinAge, input.MedicalRequired, code,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Toifa topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+// Где может встречаться код категории и что ответить, если он там есть.
+var categoryUsages = []struct {
+	query   string
+	message string
+}{
+	{`SELECT EXISTS(SELECT 1 FROM documents WHERE $1 = ANY(category_codes))`,
+		"Toifa guvohnomalarda ishlatilgan, uni faqat nofaol qilish mumkin"},
+	{`SELECT EXISTS(SELECT 1 FROM services WHERE category_code = $1)`,
+		"Toifa prayskurant xizmatlarida ishlatilgan, uni faqat nofaol qilish mumkin"},
+	{`SELECT EXISTS(SELECT 1 FROM study_groups WHERE $1 = ANY(category_codes))`,
+		"Toifa o'quv guruhlarida ishlatilgan, uni faqat nofaol qilish mumkin"},
+}
+
+// Категорию, которая уже встречается в документах, прейскуранте или
+// группах, удалить нельзя — её можно только деактивировать.
+func categoryDelete(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(mux.Vars(r)["code"])
+
+	for _, u := range categoryUsages {
+		var used bool
+		if err := db.QueryRow(u.query, code).Scan(&used); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if used {
+			http.Error(w, u.message, 409)
+			return
+		}
+	}
+
+	result, err := db.Exec(`DELETE FROM machine_categories WHERE code=$1`, code)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Toifa topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* =========================
+   CLICK SHOP API
+========================= */
+
+// Коды ошибок протокола Click.
+const (
+	clickOK             = 0
+	clickErrSign        = -1
+	clickErrAmount      = -2
+	clickErrAction      = -3
+	clickErrAlreadyPaid = -4
+	clickErrOrder       = -5
+	clickErrTxNotFound  = -6
+	clickErrUpdate      = -7
+	clickErrRequest     = -8
+	clickErrCancelled   = -9
+)
+
+// Причина отмены, которую передаёт Click в поле error.
+const clickReasonProvider = 1
+
+type clickRequest struct {
+	ClickTransID      string
+	ServiceID         string
+	MerchantTransID   string
+	MerchantPrepareID string
+	Amount            string
+	Action            string
+	Error             int
+	SignTime          string
+	SignString        string
+}
+
+func clickSecret() string {
+	return strings.TrimSpace(os.Getenv("CLICK_SECRET_KEY"))
+}
+
+// clickSign: md5(click_trans_id + service_id + secret + merchant_trans_id +
+// [merchant_prepare_id] + amount + action + sign_time).
+func clickSign(req clickRequest, secret string) string {
+	s := req.ClickTransID + req.ServiceID + secret + req.MerchantTransID
+	if req.Action == "1" {
+		s += req.MerchantPrepareID
+	}
+	s += req.Amount + req.Action + req.SignTime
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseClickRequest(r *http.Request) (clickRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return clickRequest{}, err
+	}
+	req := clickRequest{
+		ClickTransID:      r.FormValue("click_trans_id"),
+		ServiceID:         r.FormValue("service_id"),
+		MerchantTransID:   r.FormValue("merchant_trans_id"),
+		MerchantPrepareID: r.FormValue("merchant_prepare_id"),
+		Amount:            r.FormValue("amount"),
+		Action:            r.FormValue("action"),
+		SignTime:          r.FormValue("sign_time"),
+		SignString:        r.FormValue("sign_string"),
+	}
+	req.Error, _ = strconv.Atoi(r.FormValue("error"))
+	return req, nil
+}
+
+func clickReply(w http.ResponseWriter, req clickRequest, code int, note string, extra map[string]interface{}) {
+	resp := map[string]interface{}{
+		"click_trans_id":    req.ClickTransID,
+		"merchant_trans_id": req.MerchantTransID,
+		"error":             code,
+		"error_note":        note,
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	if code != clickOK {
+		log.Printf("Click %s: %d %s", req.ClickTransID, code, note)
+	}
+	respondJSON(w, resp)
+}
+
+// Общая проверка запроса: подпись, сервис, действие и сумма.
+func clickValidate(req clickRequest, action string) (Money, int, string) {
+	secret := clickSecret()
+	if secret == "" {
+		return 0, clickErrRequest, "Click sozlanmagan"
+	}
+	if want := os.Getenv("CLICK_SERVICE_ID"); want != "" && req.ServiceID != want {
+		return 0, clickErrRequest, "service_id noto'g'ri"
+	}
+	if req.ClickTransID == "" || req.MerchantTransID == "" {
+		return 0, clickErrRequest, "So'rov to'liq emas"
+	}
+	if subtle.ConstantTimeCompare([]byte(clickSign(req, secret)), []byte(strings.ToLower(req.SignString))) != 1 {
+		return 0, clickErrSign, "Imzo noto'g'ri"
+	}
+	if req.Action != action {
+		return 0, clickErrAction, "Action noto'g'ri"
+	}
+	amount, err := parseMoney(req.Amount)
+	if err != nil || amount <= 0 {
+		return 0, clickErrAmount, "Summa noto'g'ri"
+	}
+	return amount, clickOK, ""
+}
+
+func clickInvoiceError(err error) (int, string) {
+	switch err {
+	case errInvoiceNotFound:
+		return clickErrOrder, "Invoyis topilmadi"
+	case errMerchantInvoicePaid:
+		return clickErrAlreadyPaid, err.Error()
+	case errMerchantAmount:
+		return clickErrAmount, err.Error()
+	}
+	log.Printf("Click: tekshiruv xatosi: %v", err)
+	return clickErrUpdate, "Tizim xatosi"
+}
+
+// POST /api/merchant/click/prepare (action=0)
+func clickPrepare(w http.ResponseWriter, r *http.Request) {
+	req, err := parseClickRequest(r)
+	if err != nil {
+		clickReply(w, req, clickErrRequest, "So'rov xato", nil)
+		return
+	}
+	amount, code, note := clickValidate(req, "0")
+	if code != clickOK {
+		clickReply(w, req, code, note, nil)
+		return
+	}
+
+	invoiceID, err := resolveMerchantInvoice(req.MerchantTransID)
+	if err != nil {
+		code, note := clickInvoiceError(err)
+		clickReply(w, req, code, note, nil)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTx(tx, "click", req.ClickTransID)
+	switch {
+	case err == nil:
+		// Повторный prepare возвращает ту же транзакцию
+		if t.State == merchantStatePerformed {
+			clickReply(w, req, clickErrAlreadyPaid, "Allaqachon to'langan", nil)
+			return
+		}
+		if t.State < 0 {
+			clickReply(w, req, clickErrCancelled, "Tranzaksiya bekor qilingan", nil)
+			return
+		}
+	case err == errMerchantTxNotFound:
+		if err := checkMerchantInvoice(tx, invoiceID, amount); err != nil {
+			code, note := clickInvoiceError(err)
+			clickReply(w, req, code, note, nil)
+			return
+		}
+		t = MerchantTransaction{
+			Provider:   "click",
+			ExternalID: req.ClickTransID,
+			InvoiceID:  invoiceID,
+			Amount:     amount,
+		}
+		if err := insertMerchantTx(tx, &t); err != nil {
+			log.Printf("Click tranzaksiya yaratish xatosi: %v", err)
+			clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+			return
+		}
+	default:
+		clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+		return
+	}
+
+	clickReply(w, req, clickOK, "Success", map[string]interface{}{"merchant_prepare_id": t.ID})
+}
+
+// POST /api/merchant/click/complete (action=1). Отрицательный error от Click
+// означает, что оплата не прошла, — транзакция отменяется.
+func clickComplete(w http.ResponseWriter, r *http.Request) {
+	req, err := parseClickRequest(r)
+	if err != nil {
+		clickReply(w, req, clickErrRequest, "So'rov xato", nil)
+		return
+	}
+	amount, code, note := clickValidate(req, "1")
+	if code != clickOK {
+		clickReply(w, req, code, note, nil)
+		return
+	}
+	prepareID, err := strconv.Atoi(req.MerchantPrepareID)
+	if err != nil {
+		clickReply(w, req, clickErrTxNotFound, "Tranzaksiya topilmadi", nil)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+		return
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTxByID(tx, "click", prepareID)
+	if err == errMerchantTxNotFound || (err == nil && t.ExternalID != req.ClickTransID) {
+		clickReply(w, req, clickErrTxNotFound, "Tranzaksiya topilmadi", nil)
+		return
+	} else if err != nil {
+		clickReply(w, req, clickErrUpdate, "Tizim xatosi", nil)
+		return
+	}
+
+	extra := map[string]interface{}{"merchant_confirm_id": t.ID}
+	switch {
+	case t.State == merchantStatePerformed:
+		clickReply(w, req, clickErrAlreadyPaid, "Allaqachon to'langan", extra)
+		return
+	case t.State < 0:
+		clickReply(w, req, clickErrCancelled, "Tranzaksiya bekor qilingan", extra)
+		return
+	case amount != t.Amount:
+		clickReply(w, req, clickErrAmount, "Summa noto'g'ri", extra)
+		return
+	}
+
+	if req.Error < 0 {
+		if err := cancelMerchantTx(tx, &t, clickReasonProvider); err != nil || tx.Commit() != nil {
+			clickReply(w, req, clickErrUpdate, "Tizim xatosi", extra)
+			return
+		}
+		clickReply(w, req, clickErrCancelled, "Tranzaksiya bekor qilindi", extra)
+		return
+	}
+
+	if err := performMerchantTx(tx, &t); err != nil {
+		log.Printf("Click tranzaksiyani o'tkazish xatosi: %v", err)
+		clickReply(w, req, clickErrUpdate, err.Error(), extra)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		clickReply(w, req, clickErrUpdate, "Tizim xatosi", extra)
+		return
+	}
+
+	log.Printf("Click to'lovi o'tkazildi: invoyis %d, summa %s", t.InvoiceID, t.Amount)
+	clickReply(w, req, clickOK, "Success", extra)
+}
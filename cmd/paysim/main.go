@@ -0,0 +1,244 @@
+// paysim — локальный симулятор Payme и Click для разработки.
+//
+// Он поднимает HTTP-сервер и по команде проигрывает полный цикл
+// оплаты счёта, отправляя в backend подписанные запросы так же,
+// как это делают платёжные системы:
+//
+//	go run ./cmd/paysim -target http://localhost:8080
+//	curl -d '{"invoice":"12","amount":150000}' localhost:9090/payme/pay
+//	curl -d '{"invoice":"12","amount":150000}' localhost:9090/click/pay
+//	curl -d '{"id":"<payme id>","reason":5}' localhost:9090/payme/cancel
+//
+// Ключи берутся из тех же переменных окружения, что и у backend:
+// PAYME_KEY, CLICK_SECRET_KEY, CLICK_SERVICE_ID.
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	listen      = flag.String("listen", ":9090", "адрес симулятора")
+	target      = flag.String("target", "http://localhost:8080", "адрес backend")
+	paymeKey    = flag.String("payme-key", os.Getenv("PAYME_KEY"), "ключ кассы Payme")
+	clickSecret = flag.String("click-secret", os.Getenv("CLICK_SECRET_KEY"), "секретный ключ Click")
+	clickServ   = flag.String("click-service-id", envOr("CLICK_SERVICE_ID", "1"), "service_id Click")
+)
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+type payRequest struct {
+	Invoice string  `json:"invoice"` // id или номер счёта
+	Amount  float64 `json:"amount"`  // в сумах
+	Fail    bool    `json:"fail"`    // Click: оплата не прошла
+	ID      string  `json:"id"`      // Payme: id транзакции для отмены
+	Reason  int     `json:"reason"`
+}
+
+// Шаг сценария: что отправили и что ответил backend.
+type step struct {
+	Name     string      `json:"name"`
+	Request  interface{} `json:"request"`
+	Response interface{} `json:"response"`
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func millis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func paymeCall(method string, params map[string]interface{}) (map[string]interface{}, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      millis(),
+		"method":  method,
+		"params":  params,
+	})
+	req, err := http.NewRequest("POST", *target+"/api/merchant/payme", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("Paycom:"+*paymeKey)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func paymePay(in payRequest) ([]step, error) {
+	id := randomHex(12)
+	account := map[string]interface{}{"invoice_id": in.Invoice}
+	amount := int64(math.Round(in.Amount * 100))
+
+	var steps []step
+	calls := []struct {
+		method string
+		params map[string]interface{}
+	}{
+		{"CheckPerformTransaction", map[string]interface{}{"amount": amount, "account": account}},
+		{"CreateTransaction", map[string]interface{}{"id": id, "time": millis(), "amount": amount, "account": account}},
+		{"PerformTransaction", map[string]interface{}{"id": id}},
+		{"CheckTransaction", map[string]interface{}{"id": id}},
+	}
+	for _, c := range calls {
+		resp, err := paymeCall(c.method, c.params)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step{Name: c.method, Request: c.params, Response: resp})
+		if _, failed := resp["error"]; failed {
+			break
+		}
+	}
+	return steps, nil
+}
+
+func paymeCancel(in payRequest) ([]step, error) {
+	params := map[string]interface{}{"id": in.ID, "reason": in.Reason}
+	resp, err := paymeCall("CancelTransaction", params)
+	if err != nil {
+		return nil, err
+	}
+	return []step{{Name: "CancelTransaction", Request: params, Response: resp}}, nil
+}
+
+func clickSign(f url.Values) string {
+	s := f.Get("click_trans_id") + f.Get("service_id") + *clickSecret + f.Get("merchant_trans_id")
+	if f.Get("action") == "1" {
+		s += f.Get("merchant_prepare_id")
+	}
+	s += f.Get("amount") + f.Get("action") + f.Get("sign_time")
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func clickCall(path string, f url.Values) (map[string]interface{}, error) {
+	f.Set("sign_string", clickSign(f))
+	resp, err := http.PostForm(*target+path, f)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return out, nil
+}
+
+func clickPay(in payRequest) ([]step, error) {
+	transID := strconv.FormatInt(millis(), 10)
+	f := url.Values{
+		"click_trans_id":    {transID},
+		"service_id":        {*clickServ},
+		"click_paydoc_id":   {transID},
+		"merchant_trans_id": {in.Invoice},
+		"amount":            {strconv.FormatFloat(in.Amount, 'f', 2, 64)},
+		"action":            {"0"},
+		"error":             {"0"},
+		"error_note":        {"Success"},
+		"sign_time":         {time.Now().Format("2006-01-02 15:04:05")},
+	}
+
+	prepare, err := clickCall("/api/merchant/click/prepare", f)
+	if err != nil {
+		return nil, err
+	}
+	steps := []step{{Name: "prepare", Request: f, Response: prepare}}
+	if code, _ := prepare["error"].(float64); code != 0 {
+		return steps, nil
+	}
+
+	f = url.Values{
+		"click_trans_id":      {transID},
+		"service_id":          {*clickServ},
+		"click_paydoc_id":     {transID},
+		"merchant_trans_id":   {in.Invoice},
+		"merchant_prepare_id": {fmt.Sprint(prepare["merchant_prepare_id"])},
+		"amount":              {strconv.FormatFloat(in.Amount, 'f', 2, 64)},
+		"action":              {"1"},
+		"error":               {"0"},
+		"error_note":          {"Success"},
+		"sign_time":           {time.Now().Format("2006-01-02 15:04:05")},
+	}
+	if in.Fail {
+		f.Set("error", "-5017")
+		f.Set("error_note", "Insufficient funds")
+	}
+	complete, err := clickCall("/api/merchant/click/complete", f)
+	if err != nil {
+		return steps, err
+	}
+	return append(steps, step{Name: "complete", Request: f, Response: complete}), nil
+}
+
+func handle(run func(payRequest) ([]step, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "POST only", 405)
+			return
+		}
+		var in payRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid JSON", 400)
+			return
+		}
+		steps, err := run(in)
+		w.Header().Set("Content-Type", "application/json")
+		out := map[string]interface{}{"steps": steps}
+		if err != nil {
+			out["error"] = err.Error()
+			w.WriteHeader(502)
+		}
+		json.NewEncoder(w).Encode(out)
+		log.Printf("%s %s → %d шагов, ошибка: %v", r.URL.Path, in.Invoice, len(steps), err)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	http.HandleFunc("/payme/pay", handle(paymePay))
+	http.HandleFunc("/payme/cancel", handle(paymeCancel))
+	http.HandleFunc("/click/pay", handle(clickPay))
+
+	log.Printf("paysim: %s → %s", *listen, *target)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
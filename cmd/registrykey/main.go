@@ -0,0 +1,65 @@
+// registrykey — ключ подписи выгрузок реестра и проверка подписи.
+//
+// Создать ключ (seed записать в REGISTRY_SIGNING_KEY, открытый ключ
+// передать инспекции):
+//
+//	go run ./cmd/registrykey
+//
+// Проверить скачанный файл по подписи из паспорта выгрузки
+// (GET /api/registry/exports/{id}) и открытому ключу:
+//
+//	go run ./cmd/registrykey -verify REG-2026-00001.xml -sig <base64> -pub <base64>
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+var (
+	verify = flag.String("verify", "", "файл выгрузки для проверки")
+	sig    = flag.String("sig", "", "подпись (base64)")
+	pub    = flag.String("pub", "", "открытый ключ (base64)")
+)
+
+func main() {
+	flag.Parse()
+
+	if *verify == "" {
+		pubKey, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("REGISTRY_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
+		fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(pubKey))
+		return
+	}
+
+	data, err := os.ReadFile(*verify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key, err := base64.StdEncoding.DecodeString(*pub)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		log.Fatal("-pub: нужен открытый ключ Ed25519 в base64")
+	}
+	signature, err := base64.StdEncoding.DecodeString(*sig)
+	if err != nil {
+		log.Fatal("-sig: подпись не в base64")
+	}
+
+	sum := sha256.Sum256(data)
+	fmt.Printf("sha256: %s\n", hex.EncodeToString(sum[:]))
+	if !ed25519.Verify(key, data, signature) {
+		fmt.Println("подпись НЕВЕРНА")
+		os.Exit(1)
+	}
+	fmt.Println("подпись верна")
+}
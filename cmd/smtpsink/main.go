@@ -0,0 +1,208 @@
+// smtpsink — локальный SMTP-сервер для проверки почтовых рассылок.
+//
+// Принимает любые письма без авторизации и TLS и складывает их
+// в каталог -dir файлами .eml; список принятых писем отдаётся по HTTP:
+//
+//	go run ./cmd/smtpsink -smtp :2525 -http :8025
+//	SMTP_HOST=localhost SMTP_PORT=2525 SMTP_TLS=none go run .
+//	curl localhost:8025/messages
+//	curl localhost:8025/messages/<file>.eml
+//
+// Флаг -fail N заставляет сервер отклонять первые N писем с кодом 451 —
+// так проверяется очередь повторной отправки.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	smtpAddr = flag.String("smtp", ":2525", "адрес SMTP")
+	httpAddr = flag.String("http", ":8025", "адрес HTTP для просмотра писем")
+	dir      = flag.String("dir", "smtpsink-mail", "куда сохранять письма")
+	failN    = flag.Int("fail", 0, "отклонить первые N писем (451)")
+)
+
+type received struct {
+	File    string   `json:"file"`
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Size    int      `json:"size"`
+	Time    string   `json:"time"`
+}
+
+var (
+	mu       sync.Mutex
+	messages []received
+	failLeft int
+)
+
+func reply(w *bufio.Writer, line string) {
+	w.WriteString(line + "\r\n")
+	w.Flush()
+}
+
+func handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply(w, "220 smtpsink ESMTP")
+
+	var from string
+	var to []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			w.WriteString("250-smtpsink\r\n250-8BITMIME\r\n")
+			reply(w, "250 SIZE 52428800")
+		case strings.HasPrefix(cmd, "HELO"):
+			reply(w, "250 smtpsink")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			from = strings.Trim(strings.TrimSpace(line[10:]), "<>")
+			if i := strings.Index(from, "> "); i >= 0 {
+				from = from[:i]
+			}
+			to = nil
+			reply(w, "250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = append(to, strings.Trim(strings.TrimSpace(line[8:]), "<>"))
+			reply(w, "250 OK")
+		case cmd == "DATA":
+			if len(to) == 0 {
+				reply(w, "503 RCPT first")
+				continue
+			}
+			reply(w, "354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(r)
+			if err != nil {
+				return
+			}
+			reply(w, store(from, to, data))
+		case cmd == "RSET":
+			from, to = "", nil
+			reply(w, "250 OK")
+		case cmd == "NOOP":
+			reply(w, "250 OK")
+		case cmd == "QUIT":
+			reply(w, "221 Bye")
+			return
+		default:
+			reply(w, "502 Command not implemented")
+		}
+	}
+}
+
+// Тело письма до строки "." с учётом dot-stuffing.
+func readData(r *bufio.Reader) ([]byte, error) {
+	var b bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return b.Bytes(), nil
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		b.WriteString(line)
+	}
+}
+
+func store(from string, to []string, data []byte) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if failLeft > 0 {
+		failLeft--
+		log.Printf("✗ %s → %s отклонено (-fail, осталось %d)", from, strings.Join(to, ", "), failLeft)
+		return "451 Temporary failure (smtpsink -fail)"
+	}
+
+	subject := ""
+	if m, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+		subject = m.Header.Get("Subject")
+		if dec, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = dec
+		}
+	}
+	os.MkdirAll(*dir, 0755)
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102-150405"), len(messages)+1)
+	if err := os.WriteFile(filepath.Join(*dir, name), data, 0644); err != nil {
+		log.Printf("Не удалось сохранить письмо: %v", err)
+		return "451 Cannot store message"
+	}
+	messages = append(messages, received{
+		File: name, From: from, To: to, Subject: subject, Size: len(data),
+		Time: time.Now().Format(time.RFC3339),
+	})
+	log.Printf("✉ %s → %s: %s (%d байт)", from, strings.Join(to, ", "), subject, len(data))
+	return "250 OK: queued as " + name
+}
+
+func listMessages(w http.ResponseWriter, r *http.Request) {
+	if name := strings.TrimPrefix(r.URL.Path, "/messages/"); name != "" && name != r.URL.Path {
+		http.ServeFile(w, r, filepath.Join(*dir, filepath.Base(name)))
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if r.Method == "DELETE" {
+		messages = nil
+		w.WriteHeader(204)
+		return
+	}
+	list := messages
+	if list == nil {
+		list = []received{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func main() {
+	flag.Parse()
+	failLeft = *failN
+
+	ln, err := net.Listen("tcp", *smtpAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		http.HandleFunc("/messages", listMessages)
+		http.HandleFunc("/messages/", listMessages)
+		log.Fatal(http.ListenAndServe(*httpAddr, nil))
+	}()
+
+	log.Printf("smtpsink: SMTP %s, HTTP %s, письма: %s", *smtpAddr, *httpAddr, *dir)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("accept: %v", err)
+			continue
+		}
+		go handle(conn)
+	}
+}
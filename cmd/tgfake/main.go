@@ -0,0 +1,313 @@
+// tgfake — локальная подмена Telegram Bot API для проверки бота.
+//
+// Сервер реализует методы, которыми пользуется backend (getMe,
+// getUpdates с long polling, sendMessage, sendDocument), и даёт
+// управляющие ручки, чтобы «писать» боту от имени студента:
+//
+//	go run ./cmd/tgfake -listen :8081
+//	TELEGRAM_BOT_TOKEN=test TELEGRAM_API_URL=http://localhost:8081 go run .
+//	curl -d '{"chat_id":1,"text":"/start"}' localhost:8081/fake/message
+//	curl -d '{"chat_id":1,"text":"12345678901234"}' localhost:8081/fake/message
+//	curl -d '{"chat_id":1,"phone":"+998901234567"}' localhost:8081/fake/message
+//	curl localhost:8081/fake/sent?chat_id=1
+//
+// Токен может быть любым. Документы, отправленные ботом, сохраняются
+// в каталог -dir.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	listen = flag.String("listen", ":8081", "адрес сервера")
+	dir    = flag.String("dir", "tgfake-files", "куда сохранять документы от бота")
+)
+
+type update struct {
+	UpdateID int64                  `json:"update_id"`
+	Message  map[string]interface{} `json:"message"`
+}
+
+// Сообщение, отправленное ботом.
+type sent struct {
+	ID          int64       `json:"message_id"`
+	Method      string      `json:"method"`
+	ChatID      int64       `json:"chat_id"`
+	Text        string      `json:"text,omitempty"`
+	Caption     string      `json:"caption,omitempty"`
+	File        string      `json:"file,omitempty"`
+	Size        int         `json:"size,omitempty"`
+	ReplyMarkup interface{} `json:"reply_markup,omitempty"`
+	Time        string      `json:"time"`
+}
+
+type server struct {
+	mu      sync.Mutex
+	updates []update
+	nextID  int64
+	sent    []sent
+	wake    chan struct{}
+}
+
+func newServer() *server {
+	return &server{nextID: 1, wake: make(chan struct{})}
+}
+
+// push добавляет входящее обновление и будит ожидающий getUpdates.
+func (s *server) push(msg map[string]interface{}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	msg["message_id"] = id
+	msg["date"] = time.Now().Unix()
+	s.updates = append(s.updates, update{UpdateID: id, Message: msg})
+	close(s.wake)
+	s.wake = make(chan struct{})
+	return id
+}
+
+// pending возвращает обновления с id >= offset; более ранние считаются
+// подтверждёнными и удаляются, как в настоящем API.
+func (s *server) pending(offset int64) ([]update, chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.updates[:0]
+	for _, u := range s.updates {
+		if u.UpdateID >= offset {
+			kept = append(kept, u)
+		}
+	}
+	s.updates = kept
+	out := make([]update, len(kept))
+	copy(out, kept)
+	return out, s.wake
+}
+
+func (s *server) record(m sent) sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.ID = int64(len(s.sent) + 1)
+	m.Time = time.Now().Format(time.RFC3339)
+	s.sent = append(s.sent, m)
+	return m
+}
+
+func reply(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func fail(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": false, "error_code": code, "description": description,
+	})
+}
+
+// Параметры методов приходят JSON'ом или формой (multipart для файлов).
+func params(r *http.Request) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	ct := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ct, "application/json"):
+		if err := json.NewDecoder(r.Body).Decode(&out); err != nil && err != io.EOF {
+			return nil, err
+		}
+	case strings.HasPrefix(ct, "multipart/form-data"):
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+		for k, v := range r.MultipartForm.Value {
+			out[k] = v[0]
+		}
+	default:
+		r.ParseForm()
+		for k, v := range r.Form {
+			out[k] = v[0]
+		}
+	}
+	return out, nil
+}
+
+func int64Param(p map[string]interface{}, key string) int64 {
+	switch v := p[key].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func stringParam(p map[string]interface{}, key string) string {
+	s, _ := p[key].(string)
+	return s
+}
+
+func (s *server) botAPI(w http.ResponseWriter, r *http.Request) {
+	// /bot<token>/<method>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/bot"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		fail(w, 404, "Not Found")
+		return
+	}
+	method := parts[1]
+	p, err := params(r)
+	if err != nil {
+		fail(w, 400, "Bad Request: "+err.Error())
+		return
+	}
+
+	switch method {
+	case "getMe":
+		reply(w, map[string]interface{}{"id": 1, "is_bot": true, "first_name": "tgfake", "username": "tgfake_bot"})
+
+	case "getUpdates":
+		offset := int64Param(p, "offset")
+		timeout := time.Duration(int64Param(p, "timeout")) * time.Second
+		deadline := time.After(timeout)
+		for {
+			list, wake := s.pending(offset)
+			if len(list) > 0 || timeout == 0 {
+				reply(w, list)
+				return
+			}
+			select {
+			case <-wake:
+			case <-deadline:
+				reply(w, []update{})
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+	case "sendMessage":
+		chatID := int64Param(p, "chat_id")
+		if chatID == 0 {
+			fail(w, 400, "Bad Request: chat_id is empty")
+			return
+		}
+		m := s.record(sent{Method: method, ChatID: chatID, Text: stringParam(p, "text"), ReplyMarkup: p["reply_markup"]})
+		log.Printf("→ %d: %s", chatID, m.Text)
+		reply(w, map[string]interface{}{"message_id": m.ID, "chat": map[string]int64{"id": chatID}, "text": m.Text})
+
+	case "sendDocument":
+		chatID := int64Param(p, "chat_id")
+		file, header, err := r.FormFile("document")
+		if chatID == 0 || err != nil {
+			fail(w, 400, "Bad Request: chat_id and document are required")
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		os.MkdirAll(*dir, 0755)
+		name := fmt.Sprintf("%d-%d-%s", chatID, time.Now().UnixNano(), filepath.Base(header.Filename))
+		if err := os.WriteFile(filepath.Join(*dir, name), data, 0644); err != nil {
+			fail(w, 500, err.Error())
+			return
+		}
+		m := s.record(sent{Method: method, ChatID: chatID, Caption: stringParam(p, "caption"), File: name, Size: len(data)})
+		log.Printf("→ %d: 📎 %s (%d байт)", chatID, name, len(data))
+		reply(w, map[string]interface{}{"message_id": m.ID, "chat": map[string]int64{"id": chatID},
+			"document": map[string]interface{}{"file_name": header.Filename, "file_size": len(data)}})
+
+	default:
+		fail(w, 404, "Not Found: method "+method+" is not supported by tgfake")
+	}
+}
+
+type fakeMessage struct {
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+	Phone  string `json:"phone"`  // отправить контакт
+	Forged bool   `json:"forged"` // контакт чужого пользователя
+	Name   string `json:"name"`
+}
+
+// POST /fake/message — входящее сообщение от пользователя.
+func (s *server) fakeMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", 405)
+		return
+	}
+	var in fakeMessage
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ChatID == 0 {
+		http.Error(w, "Invalid JSON: chat_id is required", 400)
+		return
+	}
+	if in.Name == "" {
+		in.Name = "Test"
+	}
+	from := map[string]interface{}{"id": in.ChatID, "is_bot": false, "first_name": in.Name}
+	msg := map[string]interface{}{
+		"from": from,
+		"chat": map[string]interface{}{"id": in.ChatID, "type": "private", "first_name": in.Name},
+	}
+	if in.Phone != "" {
+		userID := in.ChatID
+		if in.Forged {
+			userID = in.ChatID + 1
+		}
+		msg["contact"] = map[string]interface{}{"phone_number": in.Phone, "first_name": in.Name, "user_id": userID}
+	} else {
+		msg["text"] = in.Text
+	}
+	id := s.push(msg)
+	log.Printf("← %d: %s%s", in.ChatID, in.Text, in.Phone)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"update_id": id})
+}
+
+// GET /fake/sent?chat_id= — что бот отправил; DELETE очищает журнал.
+func (s *server) fakeSent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == "DELETE" {
+		s.sent = nil
+		w.WriteHeader(204)
+		return
+	}
+	chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+	list := []sent{}
+	for _, m := range s.sent {
+		if chatID == 0 || m.ChatID == chatID {
+			list = append(list, m)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func main() {
+	flag.Parse()
+
+	s := newServer()
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/bot") {
+			s.botAPI(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	http.HandleFunc("/fake/message", s.fakeMessage)
+	http.HandleFunc("/fake/sent", s.fakeSent)
+
+	log.Printf("tgfake: %s, файлы: %s", *listen, *dir)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/* =========================
+   COMMISSIONS & EXAM SESSIONS
+========================= */
+
+type CommissionMember struct {
+	ID           int    `json:"id"`
+	CommissionID int    `json:"commission_id"`
+	FullName     string `json:"full_name"`
+	Position     string `json:"position"`
+	Role         string `json:"role"` // chairperson | member | secretary
+}
+
+type Commission struct {
+	ID           int                `json:"id"`
+	OrderNumber  string             `json:"order_number"`
+	OrderDate    string             `json:"order_date"`
+	Chairperson  string             `json:"chairperson"`
+	DirectorName string             `json:"director_name"`
+	Active       bool               `json:"active"`
+	CreatedAt    string             `json:"created_at"`
+	Members      []CommissionMember `json:"members,omitempty"`
+}
+
+type ExamResult struct {
+	StudentJSHSHIR string `json:"student_jshshir"`
+	StudentName    string `json:"student_name,omitempty"`
+	Grade1         *int   `json:"grade1"`
+	Grade2         *int   `json:"grade2"`
+}
+
+type ExamSession struct {
+	ID           int          `json:"id"`
+	CommissionID int          `json:"commission_id"`
+	CourseID     int          `json:"course_id,omitempty"`
+	ExamDate     string       `json:"exam_date"`
+	Location     string       `json:"location"`
+	CreatedAt    string       `json:"created_at"`
+	Results      []ExamResult `json:"results,omitempty"`
+}
+
+var validMemberRoles = []string{"chairperson", "member", "secretary"}
+
+func isValidMemberRole(role string) bool {
+	for _, r := range validMemberRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+const commissionColumns = `
+	id, order_number, COALESCE(to_char(order_date, 'YYYY-MM-DD'), ''),
+	chairperson, director_name, active, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanCommission(row interface{ Scan(...interface{}) error }, c *Commission) error {
+	return row.Scan(&c.ID, &c.OrderNumber, &c.OrderDate,
+		&c.Chairperson, &c.DirectorName, &c.Active, &c.CreatedAt)
+}
+
+func loadCommission(id int) (Commission, error) {
+	var c Commission
+	err := scanCommission(db.QueryRow(`SELECT `+commissionColumns+` FROM commissions WHERE id=$1`, id), &c)
+	if err != nil {
+		return c, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, commission_id, full_name, position, role
+		FROM commission_members WHERE commission_id=$1
+		ORDER BY role <> 'chairperson', id`, id)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m CommissionMember
+		if err := rows.Scan(&m.ID, &m.CommissionID, &m.FullName, &m.Position, &m.Role); err != nil {
+			return c, err
+		}
+		c.Members = append(c.Members, m)
+	}
+	return c, rows.Err()
+}
+
+func commissionsList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT ` + commissionColumns + ` FROM commissions ORDER BY order_date DESC NULLS LAST, id DESC`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Commission{}
+	for rows.Next() {
+		var c Commission
+		if err := scanCommission(rows, &c); err != nil {
+			log.Printf("Error scanning commission: %v", err)
+			continue
+		}
+		list = append(list, c)
+	}
+
+	respondJSON(w, list)
+}
+
+func commissionGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri komissiya ID", 400)
+		return
+	}
+
+	c, err := loadCommission(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Komissiya topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	respondJSON(w, c)
+}
+
+func commissionCreate(w http.ResponseWriter, r *http.Request) {
+	var input Commission
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+
+	input.OrderNumber = strings.TrimSpace(input.OrderNumber)
+	if input.OrderNumber == "" {
+		http.Error(w, "Buyruq raqami kiritilmagan", 400)
+		return
+	}
+	for _, m := range input.Members {
+		if strings.TrimSpace(m.FullName) == "" || !isValidMemberRole(m.Role) {
+			http.Error(w, "Komissiya a'zosi noto'g'ri ko'rsatilgan", 400)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO commissions (order_number, order_date, chairperson, director_name)
+		VALUES ($1, NULLIF($2, '')::date, $3, $4)
+		RETURNING id`,
+		input.OrderNumber, input.OrderDate, input.Chairperson, input.DirectorName,
+	).Scan(&id)
+	if err != nil {
+		log.Printf("Komissiya yaratish xatosi: %v", err)
+		http.Error(w, "Komissiya yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	for _, m := range input.Members {
+		_, err = tx.Exec(`
+			INSERT INTO commission_members (commission_id, full_name, position, role)
+			VALUES ($1, $2, $3, $4)`,
+			id, strings.TrimSpace(m.FullName), m.Position, m.Role,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Komissiya yaratildi",
+		"id":      id,
+	})
+}
+
+func commissionUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri komissiya ID", 400)
+		return
+	}
+
+	input := Commission{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if strings.TrimSpace(input.OrderNumber) == "" {
+		http.Error(w, "Buyruq raqami kiritilmagan", 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE commissions
+		SET order_number=$1, order_date=NULLIF($2, '')::date,
+			chairperson=$3, director_name=$4, active=$5
+		WHERE id=$6`,
+		strings.TrimSpace(input.OrderNumber), input.OrderDate,
+		input.Chairperson, input.DirectorName, input.Active, id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Komissiya topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+func commissionDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri komissiya ID", 400)
+		return
+	}
+
+	// Комиссию, на которую ссылаются сессии или документы, не удаляем —
+	// её можно только деактивировать.
+	var used bool
+	err = db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM exam_sessions WHERE commission_id=$1)
+		    OR EXISTS(SELECT 1 FROM documents WHERE commission_id=$1)`, id,
+	).Scan(&used)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if used {
+		http.Error(w, "Komissiya ishlatilmoqda, uni faqat nofaol qilish mumkin", 409)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM commissions WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Komissiya topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+func commissionMemberAdd(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri komissiya ID", 400)
+		return
+	}
+
+	var m CommissionMember
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if m.Role == "" {
+		m.Role = "member"
+	}
+	if strings.TrimSpace(m.FullName) == "" || !isValidMemberRole(m.Role) {
+		http.Error(w, "Komissiya a'zosi noto'g'ri ko'rsatilgan", 400)
+		return
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO commission_members (commission_id, full_name, position, role)
+		SELECT id, $2, $3, $4 FROM commissions WHERE id=$1
+		RETURNING id`,
+		id, strings.TrimSpace(m.FullName), m.Position, m.Role,
+	).Scan(&m.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Komissiya topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+	m.CommissionID = id
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, m)
+}
+
+func commissionMemberDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri komissiya ID", 400)
+		return
+	}
+	memberID, err := pathID(r, "memberId")
+	if err != nil {
+		http.Error(w, "Noto'g'ri a'zo ID", 400)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM commission_members WHERE id=$1 AND commission_id=$2`, memberID, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "A'zo topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+/* ---------- exam sessions ---------- */
+
+const sessionColumns = `
+	id, commission_id, COALESCE(course_id, 0), to_char(exam_date, 'YYYY-MM-DD'), location,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanSession(row interface{ Scan(...interface{}) error }, s *ExamSession) error {
+	return row.Scan(&s.ID, &s.CommissionID, &s.CourseID, &s.ExamDate, &s.Location, &s.CreatedAt)
+}
+
+func examSessionsList(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + sessionColumns + ` FROM exam_sessions`
+	args := []interface{}{}
+	if c := r.URL.Query().Get("commission_id"); c != "" {
+		query += ` WHERE commission_id::text=$1`
+		args = append(args, c)
+	}
+	query += ` ORDER BY exam_date DESC, id DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []ExamSession{}
+	for rows.Next() {
+		var s ExamSession
+		if err := scanSession(rows, &s); err != nil {
+			log.Printf("Error scanning exam session: %v", err)
+			continue
+		}
+		list = append(list, s)
+	}
+
+	respondJSON(w, list)
+}
+
+func examSessionGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+
+	var s ExamSession
+	err = scanSession(db.QueryRow(`SELECT `+sessionColumns+` FROM exam_sessions WHERE id=$1`, id), &s)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Imtihon sessiyasi topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT er.student_jshshir, COALESCE(st.full_name, ''), er.grade1, er.grade2
+		FROM exam_results er
+		LEFT JOIN students st ON st.jshshir = er.student_jshshir
+		WHERE er.session_id=$1
+		ORDER BY st.full_name`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res ExamResult
+		var g1, g2 sql.NullInt64
+		if err := rows.Scan(&res.StudentJSHSHIR, &res.StudentName, &g1, &g2); err != nil {
+			log.Printf("Error scanning exam result: %v", err)
+			continue
+		}
+		res.Grade1 = nullIntPtr(g1)
+		res.Grade2 = nullIntPtr(g2)
+		s.Results = append(s.Results, res)
+	}
+
+	respondJSON(w, s)
+}
+
+func examSessionCreate(w http.ResponseWriter, r *http.Request) {
+	var input ExamSession
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if input.CommissionID == 0 || strings.TrimSpace(input.ExamDate) == "" {
+		http.Error(w, "Komissiya va imtihon sanasi majburiy", 400)
+		return
+	}
+
+	var active bool
+	err := db.QueryRow(`SELECT active FROM commissions WHERE id=$1`, input.CommissionID).Scan(&active)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Komissiya topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !active {
+		http.Error(w, "Komissiya nofaol", 400)
+		return
+	}
+
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO exam_sessions (commission_id, course_id, exam_date, location)
+		VALUES ($1, $2, $3::date, $4)
+		RETURNING id`,
+		input.CommissionID, nullIfZero(input.CourseID), input.ExamDate, input.Location,
+	).Scan(&id)
+	if err != nil {
+		log.Printf("Imtihon sessiyasi yaratish xatosi: %v", err)
+		http.Error(w, "Sessiya yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Imtihon sessiyasi yaratildi",
+		"id":      id,
+	})
+}
+
+func examSessionDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+
+	var used bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM documents WHERE session_id=$1)`, id).Scan(&used); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if used {
+		http.Error(w, "Sessiya bo'yicha guvohnomalar berilgan", 409)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM exam_sessions WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Imtihon sessiyasi topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+// Сохраняет оценки за сессию: существующие записи студентов перезаписываются.
+// Если сессия привязана к курсу, оценки записываются и как попытка сдачи.
+func examResultsSave(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+
+	var results []ExamResult
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	var courseID int
+	err = tx.QueryRow(`SELECT COALESCE(course_id, 0) FROM exam_sessions WHERE id=$1`, id).Scan(&courseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Imtihon sessiyasi topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var course Course
+	if courseID != 0 {
+		if course, err = findCourse(courseID, ""); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	for _, res := range results {
+		jshshir := strings.TrimSpace(res.StudentJSHSHIR)
+		var known bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, jshshir).Scan(&known); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !known {
+			http.Error(w, "Talaba topilmadi: "+jshshir, 404)
+			return
+		}
+		if (res.Grade1 != nil && !validGrade(*res.Grade1)) || (res.Grade2 != nil && !validGrade(*res.Grade2)) {
+			http.Error(w, "Baho 2 dan 5 gacha bo'lishi kerak", 400)
+			return
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO exam_results (session_id, student_jshshir, grade1, grade2)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (session_id, student_jshshir)
+			DO UPDATE SET grade1=EXCLUDED.grade1, grade2=EXCLUDED.grade2`,
+			id, jshshir, res.Grade1, res.Grade2,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		if courseID != 0 && res.Grade1 != nil && res.Grade2 != nil {
+			a := ExamAttempt{
+				StudentJSHSHIR: jshshir,
+				CourseID:       courseID,
+				SessionID:      id,
+				Grade1:         *res.Grade1,
+				Grade2:         *res.Grade2,
+			}
+			if err := recordAttempt(tx, course, &a); err != nil {
+				http.Error(w, jshshir+": "+err.Error(), 409)
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"status": "success",
+		"saved":  len(results),
+	})
+}
+
+/* ---------- documents ---------- */
+
+// Заполняет в документе данные комиссии и экзамена из записей.
+// Если указана сессия, из неё берутся комиссия, дата экзамена и оценки
+// студента; данные комиссии (номер, директор) берутся из самой комиссии.
+// Возвращает HTTP-код для ответа при ошибке.
+func resolveExamRecords(input *DocumentInput) (int, error) {
+	if input.SessionID != 0 {
+		var commissionID int
+		var examDate string
+		err := db.QueryRow(`
+			SELECT commission_id, to_char(exam_date, 'YYYY-MM-DD')
+			FROM exam_sessions WHERE id=$1`, input.SessionID,
+		).Scan(&commissionID, &examDate)
+		if err == sql.ErrNoRows {
+			return 404, errors.New("Imtihon sessiyasi topilmadi")
+		} else if err != nil {
+			return 500, err
+		}
+		input.CommissionID = commissionID
+		input.ExamDate = examDate
+
+		var g1, g2 sql.NullInt64
+		err = db.QueryRow(`
+			SELECT grade1, grade2 FROM exam_results
+			WHERE session_id=$1 AND student_jshshir=$2`,
+			input.SessionID, strings.TrimSpace(input.StudentJSHSHIR),
+		).Scan(&g1, &g2)
+		if err == sql.ErrNoRows {
+			return 409, errors.New("Talabaning bu sessiyadagi natijasi kiritilmagan")
+		} else if err != nil {
+			return 500, err
+		}
+		// Оценки берутся только из результатов сессии, не из формы
+		if !g1.Valid || !g2.Valid {
+			return 409, errors.New("Talabaning bu sessiyadagi baholari to'liq kiritilmagan")
+		}
+		input.Grade1 = int(g1.Int64)
+		input.Grade2 = int(g2.Int64)
+	}
+
+	// Комиссию не подставляем: неверная комиссия и директор на
+	// свидетельстве хуже, чем отказ.
+	if input.CommissionID == 0 && strings.TrimSpace(input.CommissionNo) == "" {
+		return 400, errors.New("Komissiya ko'rsatilmagan")
+	}
+
+	// Номер приказа без ID — ищем комиссию по нему; при повторах
+	// предпочитаем действующую и последнюю по дате.
+	if input.CommissionID == 0 {
+		no := strings.TrimSpace(input.CommissionNo)
+		err := db.QueryRow(`
+			SELECT id FROM commissions WHERE order_number=$1
+			ORDER BY active DESC, order_date DESC NULLS LAST, id DESC LIMIT 1`, no,
+		).Scan(&input.CommissionID)
+		if err == sql.ErrNoRows {
+			return 400, fmt.Errorf("Komissiya topilmadi: %s", no)
+		} else if err != nil {
+			return 500, err
+		}
+	}
+
+	if input.CommissionID != 0 {
+		var orderNumber, director string
+		err := db.QueryRow(`SELECT order_number, director_name FROM commissions WHERE id=$1`,
+			input.CommissionID).Scan(&orderNumber, &director)
+		if err == sql.ErrNoRows {
+			return 404, errors.New("Komissiya topilmadi")
+		} else if err != nil {
+			return 500, err
+		}
+		input.CommissionNo = orderNumber
+		if director != "" {
+			input.DirectorName = director
+		}
+	}
+
+	return 0, nil
+}
+
+func nullIntPtr(ni sql.NullInt64) *int {
+	if !ni.Valid {
+		return nil
+	}
+	v := int(ni.Int64)
+	return &v
+}
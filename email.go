@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/* =========================
+   EMAIL
+========================= */
+
+// Отправка PDF свидетельств и счетов по почте. Письмо записывается
+// в email_messages вместе с задачей email.send; каждая попытка попадает
+// в журнал email_attempts. Неудачные письма повторяет очередь задач
+// с нарастающей паузой, пока не кончатся попытки.
+//
+//	SMTP_HOST, SMTP_PORT (587)  — сервер; для локальной проверки — go run ./cmd/smtpsink;
+//	SMTP_USER, SMTP_PASSWORD    — авторизация (необязательно);
+//	SMTP_FROM                   — адрес отправителя;
+//	SMTP_TLS                    — starttls (по умолчанию), tls или none;
+//	EMAIL_MAX_ATTEMPTS          — число попыток (по умолчанию 5).
+
+const (
+	emailKindCertificate = "certificate"
+	emailKindInvoice     = "invoice"
+)
+
+const (
+	emailStatusPending = "pending"
+	emailStatusSent    = "sent"
+	emailStatusFailed  = "failed"
+)
+
+var errEmailNotConfigured = errors.New("SMTP sozlanmagan (SMTP_HOST ko'rsatilmagan)")
+
+type smtpConfig struct {
+	Host, Port     string
+	User, Password string
+	From           string
+	TLS            string
+}
+
+func loadSMTPConfig() smtpConfig {
+	return smtpConfig{
+		Host:     envDefault("SMTP_HOST", ""),
+		Port:     envDefault("SMTP_PORT", "587"),
+		User:     envDefault("SMTP_USER", ""),
+		Password: envDefault("SMTP_PASSWORD", ""),
+		From:     envDefault("SMTP_FROM", "noreply@localhost"),
+		TLS:      strings.ToLower(envDefault("SMTP_TLS", "starttls")),
+	}
+}
+
+func emailMaxAttempts() int {
+	if n, err := strconv.Atoi(envDefault("EMAIL_MAX_ATTEMPTS", "5")); err == nil && n > 0 {
+		return n
+	}
+	return 5
+}
+
+/* ---------- шаблоны ---------- */
+
+type emailTemplate struct {
+	Subject, Body string
+}
+
+var emailTemplates = map[string]emailTemplate{
+	emailKindCertificate: {
+		Subject: "Guvohnoma № {{.Number}} — {{.Student}}",
+		Body: `Assalomu alaykum!
+
+{{.Org}} {{.Student}} (JShShIR {{.JSHSHIR}}) nomiga berilgan {{.Number}} raqamli guvohnomani ilova qilib yuboradi.
+{{if .Categories}}Toifalar: {{.Categories}}
+{{end}}{{if .ExpiresAt}}Amal qilish muddati: {{.ExpiresAt}}
+{{end}}
+Guvohnoma haqiqiyligini o'quv markazida tekshirish mumkin.
+{{if .Note}}
+{{.Note}}
+{{end}}
+Hurmat bilan,
+{{.Org}}{{if .Phone}}
+Tel: {{.Phone}}{{end}}
+`,
+	},
+	emailKindInvoice: {
+		Subject: "Hisob-faktura № {{.Number}} — {{.Org}}",
+		Body: `Assalomu alaykum!
+
+{{.Student}} uchun {{.Number}} raqamli hisob-faktura ilova qilindi.
+Summa: {{.Amount}} so'm{{if .DueDate}}
+To'lov muddati: {{.DueDate}}{{end}}
+{{if .Note}}
+{{.Note}}
+{{end}}
+Hurmat bilan,
+{{.Org}}{{if .Phone}}
+Tel: {{.Phone}}{{end}}
+`,
+	},
+}
+
+func renderEmailTemplate(kind string, data map[string]string) (string, string, error) {
+	t, ok := emailTemplates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("Noma'lum xat turi: %s", kind)
+	}
+	render := func(name, text string) (string, error) {
+		tpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := tpl.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	subject, err := render(kind+"-subject", t.Subject)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := render(kind+"-body", t.Body)
+	return strings.TrimSpace(subject), body, err
+}
+
+/* ---------- вложения ---------- */
+
+type emailAttachment struct {
+	Filename string
+	Data     []byte
+}
+
+// PDF строится заново при каждой попытке, поэтому в очереди хранится
+// только ссылка на документ или счёт.
+func emailContent(kind string, refID int) (map[string]string, emailAttachment, error) {
+	org := loadOrganization()
+	data := map[string]string{"Org": org.Name, "Phone": org.Phone}
+
+	switch kind {
+	case emailKindCertificate:
+		doc, err := loadDocument(refID)
+		if err != nil {
+			return nil, emailAttachment{}, err
+		}
+		if doc.Revoked {
+			return nil, emailAttachment{}, errDocumentRevoked
+		}
+		data["Number"] = doc.CertificateNo
+		data["Student"] = doc.StudentName
+		data["JSHSHIR"] = doc.StudentJSHSHIR
+		data["Categories"] = doc.Categories
+		data["ExpiresAt"] = doc.ExpiresAt
+		return data, emailAttachment{certificateFilename(doc), renderCertificatePDF(doc)}, nil
+
+	case emailKindInvoice:
+		var student, dueDate string
+		var amount Money
+		err := db.QueryRow(`
+			SELECT COALESCE(s.full_name, i.student_name, ''), i.amount, COALESCE(i.due_date::text, '')
+			FROM invoices i LEFT JOIN students s ON s.jshshir = i.student_jshshir
+			WHERE i.id=$1`, refID,
+		).Scan(&student, &amount, &dueDate)
+		if err == sql.ErrNoRows {
+			return nil, emailAttachment{}, errInvoiceNotFound
+		} else if err != nil {
+			return nil, emailAttachment{}, err
+		}
+		number, pdf, err := renderInvoicePDF(refID)
+		if err != nil {
+			return nil, emailAttachment{}, err
+		}
+		data["Number"] = number
+		data["Student"] = student
+		data["Amount"] = formatMoney(amount)
+		data["DueDate"] = dueDate
+		return data, emailAttachment{number + ".pdf", pdf}, nil
+	}
+	return nil, emailAttachment{}, fmt.Errorf("Noma'lum xat turi: %s", kind)
+}
+
+/* ---------- MIME и SMTP ---------- */
+
+func wrapBase64(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b bytes.Buffer
+	for len(enc) > 76 {
+		b.WriteString(enc[:76])
+		b.WriteString("\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func buildEmail(from string, to []string, subject, body string, att emailAttachment) []byte {
+	boundary := fmt.Sprintf("trk-%d", time.Now().UnixNano())
+	host := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i >= 0 {
+		host = from[i+1:]
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", (&mail.Address{Name: loadOrganization().Name, Address: from}).String())
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%d@%s>\r\n", time.Now().UnixNano(), host)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	b.WriteString("\r\n")
+
+	if len(att.Data) > 0 {
+		name := mime.QEncoding.Encode("utf-8", att.Filename)
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: application/pdf; name=%q\r\n", name)
+		b.WriteString("Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&b, "Content-Disposition: attachment; filename=%q\r\n\r\n", name)
+		b.Write(wrapBase64(att.Data))
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+func sendSMTP(cfg smtpConfig, to []string, msg []byte) error {
+	if cfg.Host == "" {
+		return errEmailNotConfigured
+	}
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	var conn net.Conn
+	var err error
+	if cfg.TLS == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 15*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server STARTTLS ni qo'llab-quvvatlamaydi (SMTP_TLS=none ni ko'rsating)")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if cfg.User != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+/* ---------- очередь ---------- */
+
+type EmailMessage struct {
+	ID          int            `json:"id"`
+	Kind        string         `json:"kind"`
+	RefID       int            `json:"ref_id"`
+	Recipients  []string       `json:"recipients"`
+	Subject     string         `json:"subject"`
+	Body        string         `json:"body,omitempty"`
+	Status      string         `json:"status"`
+	Attempts    int            `json:"attempts"`
+	LastError   string         `json:"last_error,omitempty"`
+	RequestedBy string         `json:"requested_by,omitempty"`
+	CreatedAt   string         `json:"created_at"`
+	SentAt      string         `json:"sent_at,omitempty"`
+	Log         []EmailAttempt `json:"log,omitempty"`
+}
+
+type EmailAttempt struct {
+	Attempt     int    `json:"attempt"`
+	AttemptedAt string `json:"attempted_at"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+type EmailRequest struct {
+	To          []string `json:"to"`
+	Note        string   `json:"note"`
+	RequestedBy string   `json:"requested_by"`
+}
+
+func parseRecipients(list []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, item := range list {
+		for _, part := range strings.Split(item, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			a, err := mail.ParseAddress(part)
+			if err != nil {
+				return nil, fmt.Errorf("Email manzili noto'g'ri: %q", part)
+			}
+			addr := strings.ToLower(a.Address)
+			if !seen[addr] {
+				seen[addr] = true
+				out = append(out, addr)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("Qabul qiluvchi ko'rsatilmagan")
+	}
+	if len(out) > 10 {
+		return nil, errors.New("Qabul qiluvchilar 10 tadan oshmasligi kerak")
+	}
+	return out, nil
+}
+
+// Ставит письмо в очередь и сразу пытается его отправить.
+func enqueueEmail(kind string, refID int, req EmailRequest) (EmailMessage, error) {
+	to, err := parseRecipients(req.To)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	data, _, err := emailContent(kind, refID)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	data["Note"] = strings.TrimSpace(req.Note)
+	subject, body, err := renderEmailTemplate(kind, data)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+
+	m := EmailMessage{Kind: kind, RefID: refID, Recipients: to, Subject: subject, Body: body,
+		Status: emailStatusPending, RequestedBy: req.RequestedBy}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO email_messages (kind, ref_id, recipients, subject, body, status, requested_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+		kind, refID, strings.Join(to, ", "), subject, body, emailStatusPending, nullIfEmpty(req.RequestedBy),
+	).Scan(&m.ID, &m.CreatedAt)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	if err := enqueueEmailJob(tx, m.ID); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return EmailMessage{}, err
+	}
+	jobs.notify()
+	log.Printf("Xat navbatga qo'yildi: #%d %s %d → %s", m.ID, kind, refID, strings.Join(to, ", "))
+	return m, nil
+}
+
+type emailJob struct {
+	MessageID int `json:"message_id"`
+}
+
+func enqueueEmailJob(tx *sql.Tx, id int) error {
+	_, err := enqueueJobWith(tx, jobEmailSend, emailJob{MessageID: id}, jobOptions{MaxAttempts: emailMaxAttempts()})
+	return err
+}
+
+// Задача email.send: одна попытка отправки с записью в журнал.
+func emailSendJob(ctx context.Context, j *Job) error {
+	var p emailJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	id := p.MessageID
+
+	var kind, recipients, subject, body, status string
+	var refID int
+	err := db.QueryRow(`
+		SELECT kind, ref_id, recipients, subject, body, status
+		FROM email_messages WHERE id=$1`, id,
+	).Scan(&kind, &refID, &recipients, &subject, &body, &status)
+	if err == sql.ErrNoRows || (err == nil && status != emailStatusPending) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	cfg := loadSMTPConfig()
+	to := strings.Split(recipients, ", ")
+	_, att, sendErr := emailContent(kind, refID)
+	if sendErr == nil {
+		sendErr = sendSMTP(cfg, to, buildEmail(cfg.From, to, subject, body, att))
+	}
+
+	errText := ""
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	// Документ отозван после постановки в очередь — повторять бессмысленно
+	revoked := sendErr == errDocumentRevoked
+	var attempt int
+	err = db.QueryRow(`
+		UPDATE email_messages SET attempts = attempts + 1 WHERE id=$1 RETURNING attempts`, id,
+	).Scan(&attempt)
+	if err == nil {
+		_, err = db.Exec(`
+			INSERT INTO email_attempts (message_id, attempt, success, error, duration_ms)
+			VALUES ($1, $2, $3, $4, $5)`,
+			id, attempt, sendErr == nil, nullIfEmpty(errText), time.Since(started).Milliseconds())
+	}
+	if err != nil {
+		log.Printf("Xat #%d urinishini yozish xatosi: %v", id, err)
+	}
+
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`
+			UPDATE email_messages SET status=$2, last_error=NULL, sent_at=NOW() WHERE id=$1`,
+			id, emailStatusSent)
+		log.Printf("✉️ Xat #%d yuborildi: %s", id, recipients)
+	case j.lastAttempt() || revoked:
+		_, err = db.Exec(`UPDATE email_messages SET status=$2, last_error=$3 WHERE id=$1`,
+			id, emailStatusFailed, errText)
+	default:
+		_, err = db.Exec(`UPDATE email_messages SET last_error=$2 WHERE id=$1`, id, errText)
+	}
+	if err != nil {
+		log.Printf("Xat #%d holatini yangilash xatosi: %v", id, err)
+	}
+	if revoked {
+		return nil
+	}
+	return sendErr
+}
+
+/* ---------- API ---------- */
+
+func emailSendHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.Error(w, "Noto'g'ri ID", 400)
+			return
+		}
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Noto'g'ri ma'lumot", 400)
+			return
+		}
+		if _, err := parseRecipients(req.To); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m, err := enqueueEmail(kind, id, req)
+		if err == errDocumentNotFound || err == errInvoiceNotFound {
+			http.Error(w, err.Error(), 404)
+			return
+		} else if err == errDocumentRevoked {
+			http.Error(w, err.Error(), 409)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		respondJSON(w, m)
+	}
+}
+
+const emailColumns = `
+	id, kind, ref_id, recipients, subject, status, attempts, COALESCE(last_error, ''),
+	COALESCE(requested_by, ''), to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'),
+	COALESCE(to_char(sent_at, 'YYYY-MM-DD HH24:MI:SS'), '')`
+
+func scanEmail(row interface{ Scan(...interface{}) error }, m *EmailMessage) error {
+	var recipients string
+	err := row.Scan(&m.ID, &m.Kind, &m.RefID, &recipients, &m.Subject, &m.Status, &m.Attempts,
+		&m.LastError, &m.RequestedBy, &m.CreatedAt, &m.SentAt)
+	m.Recipients = strings.Split(recipients, ", ")
+	return err
+}
+
+// GET /api/emails?status=&kind=&ref_id=
+func emailsList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	refID, _ := strconv.Atoi(q.Get("ref_id"))
+	rows, err := db.Query(`SELECT `+emailColumns+`
+		FROM email_messages
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2) AND ($3 = 0 OR ref_id = $3)
+		ORDER BY id DESC
+		LIMIT 500`, q.Get("status"), q.Get("kind"), refID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []EmailMessage{}
+	for rows.Next() {
+		var m EmailMessage
+		if err := scanEmail(rows, &m); err != nil {
+			log.Printf("Error scanning email: %v", err)
+			continue
+		}
+		list = append(list, m)
+	}
+	respondJSON(w, list)
+}
+
+// GET /api/emails/{id} — письмо с журналом попыток.
+func emailGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	var m EmailMessage
+	err = scanEmail(db.QueryRow(`SELECT `+emailColumns+` FROM email_messages WHERE id=$1`, id), &m)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Xat topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	db.QueryRow(`SELECT body FROM email_messages WHERE id=$1`, id).Scan(&m.Body)
+
+	rows, err := db.Query(`
+		SELECT attempt, to_char(attempted_at, 'YYYY-MM-DD HH24:MI:SS'), success, COALESCE(error, ''), duration_ms
+		FROM email_attempts WHERE message_id=$1 ORDER BY attempt`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	m.Log = []EmailAttempt{}
+	for rows.Next() {
+		var a EmailAttempt
+		if err := rows.Scan(&a.Attempt, &a.AttemptedAt, &a.Success, &a.Error, &a.DurationMS); err != nil {
+			log.Printf("Error scanning email attempt: %v", err)
+			continue
+		}
+		m.Log = append(m.Log, a)
+	}
+	respondJSON(w, m)
+}
+
+// POST /api/emails/{id}/retry — снова поставить неотправленное письмо в очередь.
+func emailRetry(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE email_messages SET status=$2 WHERE id=$1 AND status=$3`,
+		id, emailStatusPending, emailStatusFailed)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Xat topilmadi yoki yuborilmagan deb belgilanmagan", 409)
+		return
+	}
+	if err := enqueueEmailJob(tx, id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	jobs.notify()
+	respondJSON(w, map[string]string{"status": "queued"})
+}
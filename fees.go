@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/* =========================
+   UNPAID FEE POLICY
+========================= */
+
+// Политика выдачи свидетельства при долге студента (FEE_POLICY):
+//
+//	off      — не проверять;
+//	block    — отказать, пока долг не погашен;
+//	override — отказать, если директор не разрешил выдачу с указанием причины.
+const (
+	feePolicyOff      = "off"
+	feePolicyBlock    = "block"
+	feePolicyOverride = "override"
+)
+
+// Разрешение директора выдать свидетельство при неоплаченном обучении.
+type FeeOverride struct {
+	Reason     string `json:"reason"`
+	ApprovedBy string `json:"approved_by"`
+	Role       string `json:"role"`
+}
+
+type FeeOverrideRecord struct {
+	DocumentID     int    `json:"document_id"`
+	CertificateNo  string `json:"certificate_number"`
+	StudentJSHSHIR string `json:"student_jshshir"`
+	StudentName    string `json:"student_name"`
+	Outstanding    Money  `json:"outstanding"`
+	Reason         string `json:"reason"`
+	ApprovedBy     string `json:"approved_by"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func feePolicy() string {
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("FEE_POLICY"))); p {
+	case feePolicyOff, feePolicyBlock:
+		return p
+	case "", feePolicyOverride:
+		return feePolicyOverride
+	default:
+		log.Printf("FEE_POLICY=%q noma'lum, %q ishlatiladi", p, feePolicyOverride)
+		return feePolicyOverride
+	}
+}
+
+// Проверяет долг студента перед выдачей свидетельства. Возвращает долг,
+// который фиксируется в документе, и HTTP-код при отказе. Разрешение
+// директора без долга игнорируется.
+func checkFeePolicy(input *DocumentInput) (Money, int, error) {
+	policy := feePolicy()
+	jshshir := strings.TrimSpace(input.StudentJSHSHIR)
+	if policy == feePolicyOff || jshshir == "" {
+		input.FeeOverride = nil
+		return 0, 0, nil
+	}
+
+	b, err := loadStudentBalance(jshshir)
+	if err == errStudentNotFound {
+		return 0, 404, err
+	} else if err != nil {
+		log.Printf("Talaba qarzini tekshirish xatosi: %v", err)
+		return 0, 500, fmt.Errorf("Baza xatosi")
+	}
+	if b.Balance <= 0 {
+		input.FeeOverride = nil
+		return 0, 0, nil
+	}
+
+	debt := fmt.Sprintf("Talabaning to'lanmagan qarzi bor: %s so'm", formatMoney(b.Balance))
+	if policy == feePolicyBlock {
+		return b.Balance, http.StatusPaymentRequired, fmt.Errorf("%s", debt)
+	}
+
+	o := input.FeeOverride
+	if o == nil {
+		return b.Balance, http.StatusPaymentRequired,
+			fmt.Errorf("%s. Guvohnoma faqat direktor ruxsati bilan beriladi", debt)
+	}
+	o.Reason = strings.TrimSpace(o.Reason)
+	o.ApprovedBy = strings.TrimSpace(o.ApprovedBy)
+	if err := checkRole(o.Role, []string{roleDirector}); err != nil {
+		return b.Balance, 403, err
+	}
+	if o.Reason == "" {
+		return b.Balance, 400, fmt.Errorf("Direktor ruxsati sababi ko'rsatilmagan")
+	}
+	if o.ApprovedBy == "" {
+		return b.Balance, 400, fmt.Errorf("Ruxsat bergan direktor ko'rsatilmagan")
+	}
+
+	log.Printf("Qarz bilan guvohnoma berishga ruxsat: %s, qarz %s, %s: %s",
+		jshshir, b.Balance, o.ApprovedBy, o.Reason)
+	return b.Balance, 0, nil
+}
+
+// GET /api/documents/fee-overrides — журнал выдач с разрешения директора.
+func feeOverridesList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rows, err := db.Query(`
+		SELECT id, COALESCE(certificate_number, ''), COALESCE(student_jshshir, ''),
+			COALESCE(student_name, ''), fee_outstanding, fee_override_reason, fee_override_by,
+			to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM documents
+		WHERE fee_override_by IS NOT NULL
+			AND ($1::date IS NULL OR created_at::date >= $1::date)
+			AND ($2::date IS NULL OR created_at::date <= $2::date)
+		ORDER BY created_at DESC`,
+		nullIfEmpty(q.Get("from")), nullIfEmpty(q.Get("to")))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []FeeOverrideRecord{}
+	for rows.Next() {
+		var f FeeOverrideRecord
+		if err := rows.Scan(&f.DocumentID, &f.CertificateNo, &f.StudentJSHSHIR, &f.StudentName,
+			&f.Outstanding, &f.Reason, &f.ApprovedBy, &f.CreatedAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		list = append(list, f)
+	}
+	respondJSON(w, list)
+}
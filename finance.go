@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+/* =========================
+   FINANCIAL REPORTS
+========================= */
+
+// Дата выставления счёта; у старых счетов issue_date может быть пустой.
+const invoiceIssueDateSQL = `COALESCE(NULLIF(i.issue_date::text, '')::date, i.created_at::date)`
+
+// Сумма, списанная при отмене счёта: неоплаченная часть, как в выписке студента.
+const invoiceWrittenOffSQL = `GREATEST(i.amount -
+	COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0), 0)`
+
+type FinanceSummary struct {
+	From             string  `json:"from"`
+	To               string  `json:"to"`
+	Invoiced         Money   `json:"invoiced"`
+	InvoiceCount     int     `json:"invoice_count"`
+	Cancelled        Money   `json:"cancelled"`
+	CancelledCount   int     `json:"cancelled_count"`
+	Collected        Money   `json:"collected"`
+	Refunded         Money   `json:"refunded"`
+	NetCollected     Money   `json:"net_collected"`
+	Outstanding      Money   `json:"outstanding"` // на конец периода
+	PaidCount        int     `json:"paid_count"`
+	AvgDaysToPayment float64 `json:"avg_days_to_payment"`
+}
+
+type RevenueRow struct {
+	Key          string `json:"key"`
+	Label        string `json:"label"`
+	Invoiced     Money  `json:"invoiced"`
+	Cancelled    Money  `json:"cancelled"`
+	Collected    Money  `json:"collected"`
+	Refunded     Money  `json:"refunded"`
+	NetCollected Money  `json:"net_collected"`
+}
+
+type FinanceReport struct {
+	GroupBy string         `json:"group_by"`
+	Summary FinanceSummary `json:"summary"`
+	Rows    []RevenueRow   `json:"rows"`
+}
+
+// Группировки отчёта: ключ и подпись строки. Дата m.d — дата движения
+// (выставление, оплата, возврат или отмена счёта).
+var revenueGroupings = map[string][2]string{
+	"day":    {`to_char(m.d, 'YYYY-MM-DD')`, `to_char(m.d, 'YYYY-MM-DD')`},
+	"month":  {`to_char(m.d, 'YYYY-MM')`, `to_char(m.d, 'YYYY-MM')`},
+	"course": {`COALESCE(c.id, 0)::text`, `COALESCE(c.name, 'Kurssiz')`},
+}
+
+// Курс счёта определяется по последней группе студента, как в отчёте о долгах.
+const revenueCourseJoin = `
+	JOIN invoices i ON i.id = m.invoice_id
+	LEFT JOIN LATERAL (
+		SELECT sg.course_id
+		FROM group_students gs
+		JOIN study_groups sg ON sg.id = gs.group_id
+		WHERE gs.student_jshshir = i.student_jshshir
+		ORDER BY sg.start_date DESC NULLS LAST, sg.id DESC
+		LIMIT 1
+	) g ON TRUE
+	LEFT JOIN courses c ON c.id = g.course_id`
+
+var (
+	errBadDate   = errors.New("Noto'g'ri sana (YYYY-MM-DD)")
+	errBadPeriod = errors.New("Davr boshi oxiridan keyin bo'lishi mumkin emas")
+)
+
+// Период отчёта: по умолчанию с начала текущего месяца до сегодня.
+func reportPeriod(r *http.Request) (string, string, error) {
+	now := time.Now()
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" {
+		from = now.Format("2006-01") + "-01"
+	}
+	if to == "" {
+		to = now.Format("2006-01-02")
+	}
+	f, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return "", "", errBadDate
+	}
+	t, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return "", "", errBadDate
+	}
+	if t.Before(f) {
+		return "", "", errBadPeriod
+	}
+	return from, to, nil
+}
+
+func loadFinanceSummary(from, to string) (FinanceSummary, error) {
+	s := FinanceSummary{From: from, To: to}
+	var avgDays sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT
+			COALESCE(SUM(i.amount) FILTER (WHERE `+invoiceIssueDateSQL+` BETWEEN $1::date AND $2::date), 0),
+			COUNT(*) FILTER (WHERE `+invoiceIssueDateSQL+` BETWEEN $1::date AND $2::date),
+			COALESCE(SUM(`+invoiceWrittenOffSQL+`) FILTER (WHERE i.status = $3 AND i.cancelled_at BETWEEN $1::date AND $2::date), 0),
+			COUNT(*) FILTER (WHERE i.status = $3 AND i.cancelled_at BETWEEN $1::date AND $2::date),
+			COALESCE(SUM(GREATEST(i.amount - COALESCE((SELECT SUM(p.amount) FROM payments p
+				WHERE p.invoice_id = i.id AND p.paid_at <= $2::date), 0), 0))
+				FILTER (WHERE i.created_at::date <= $2::date AND (i.status <> $3 OR i.cancelled_at > $2::date)), 0),
+			COUNT(*) FILTER (WHERE i.status = $4 AND NULLIF(i.payment_date::text, '')::date BETWEEN $1::date AND $2::date),
+			AVG(NULLIF(i.payment_date::text, '')::date - `+invoiceIssueDateSQL+`)
+				FILTER (WHERE i.status = $4 AND NULLIF(i.payment_date::text, '')::date BETWEEN $1::date AND $2::date)
+		FROM invoices i`,
+		from, to, invoiceStatusCancelled, invoiceStatusPaid,
+	).Scan(&s.Invoiced, &s.InvoiceCount, &s.Cancelled, &s.CancelledCount,
+		&s.Outstanding, &s.PaidCount, &avgDays)
+	if err != nil {
+		return s, err
+	}
+	if avgDays.Valid {
+		s.AvgDaysToPayment = math.Round(avgDays.Float64*10) / 10
+	}
+
+	err = db.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(amount) FROM payments WHERE paid_at BETWEEN $1::date AND $2::date), 0),
+			COALESCE((SELECT SUM(amount) FROM credit_notes WHERE issued_at BETWEEN $1::date AND $2::date), 0)`,
+		from, to,
+	).Scan(&s.Collected, &s.Refunded)
+	if err != nil {
+		return s, err
+	}
+	s.NetCollected = s.Collected - s.Refunded
+	return s, nil
+}
+
+func loadRevenueRows(from, to, groupBy string) ([]RevenueRow, error) {
+	g := revenueGroupings[groupBy]
+	join := ""
+	if groupBy == "course" {
+		join = revenueCourseJoin
+	}
+
+	rows, err := db.Query(`
+		SELECT `+g[0]+`, `+g[1]+`,
+			SUM(m.invoiced), SUM(m.cancelled), SUM(m.collected), SUM(m.refunded)
+		FROM (
+			SELECT `+invoiceIssueDateSQL+` AS d, i.id AS invoice_id,
+				i.amount AS invoiced, 0 AS cancelled, 0 AS collected, 0 AS refunded
+			FROM invoices i
+			WHERE `+invoiceIssueDateSQL+` BETWEEN $1::date AND $2::date
+
+			UNION ALL
+			SELECT i.cancelled_at, i.id, 0, `+invoiceWrittenOffSQL+`, 0, 0
+			FROM invoices i
+			WHERE i.status = $3 AND i.cancelled_at BETWEEN $1::date AND $2::date
+
+			UNION ALL
+			SELECT p.paid_at, p.invoice_id, 0, 0, p.amount, 0
+			FROM payments p WHERE p.paid_at BETWEEN $1::date AND $2::date
+
+			UNION ALL
+			SELECT cn.issued_at, cn.invoice_id, 0, 0, 0, cn.amount
+			FROM credit_notes cn WHERE cn.issued_at BETWEEN $1::date AND $2::date
+		) m`+join+`
+		GROUP BY 1, 2
+		ORDER BY 1`,
+		from, to, invoiceStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []RevenueRow{}
+	for rows.Next() {
+		var row RevenueRow
+		if err := rows.Scan(&row.Key, &row.Label, &row.Invoiced, &row.Cancelled,
+			&row.Collected, &row.Refunded); err != nil {
+			return nil, err
+		}
+		row.NetCollected = row.Collected - row.Refunded
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// GET /api/reports/finance?from=&to=&group_by=day|month|course
+// Выручка считается по движению денег (оплаты минус возвраты), начисления —
+// по дате счёта, отмена — по дате отмены. Долг — остаток на конец периода.
+func financeReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "month"
+	}
+	if _, ok := revenueGroupings[groupBy]; !ok {
+		http.Error(w, "group_by: day, month yoki course", 400)
+		return
+	}
+
+	report := FinanceReport{GroupBy: groupBy}
+	if report.Summary, err = loadFinanceSummary(from, to); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if report.Rows, err = loadRevenueRows(from, to, groupBy); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, report)
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   COURSES & GRADING RULES
+========================= */
+
+// Оценки по пятибалльной шкале: grade1 — теория (Y.H.Q), grade2 — практика (H.X.Q).
+const (
+	minGrade = 2
+	maxGrade = 5
+)
+
+const examPassed = "passed"
+
+var validDocumentStatuses = []string{"active", "inactive", "archived"}
+
+type Course struct {
+	ID             int     `json:"id"`
+	Name           string  `json:"name"`
+	MinTheory      int     `json:"min_theory"`
+	MinPractice    int     `json:"min_practice"`
+	MaxAttempts    int     `json:"max_attempts"`
+	TheoryWeight   int     `json:"theory_weight"` // в процентах, остальное — практика
+	MinFinal       float64 `json:"min_final"`
+	ValidityMonths *int    `json:"validity_months"` // nil — бессрочно
+	CreatedAt      string  `json:"created_at"`
+}
+
+type ExamAttempt struct {
+	ID             int     `json:"id"`
+	StudentJSHSHIR string  `json:"student_jshshir"`
+	CourseID       int     `json:"course_id"`
+	CourseName     string  `json:"course_name,omitempty"`
+	SessionID      int     `json:"session_id,omitempty"`
+	AttemptNo      int     `json:"attempt_no"`
+	Grade1         int     `json:"grade1"`
+	Grade2         int     `json:"grade2"`
+	FinalScore     float64 `json:"final_score"`
+	Passed         bool    `json:"passed"`
+	CreatedAt      string  `json:"created_at"`
+}
+
+// Правило для документов, курс которых не заведён в справочнике.
+var defaultCourse = Course{
+	MinTheory:    3,
+	MinPractice:  3,
+	MaxAttempts:  3,
+	TheoryWeight: 50,
+	MinFinal:     3,
+}
+
+var errRetakeLimit = errors.New("Qayta topshirishlar soni tugagan")
+
+func validGrade(g int) bool {
+	return g >= minGrade && g <= maxGrade
+}
+
+func validateCourse(c Course) error {
+	switch {
+	case strings.TrimSpace(c.Name) == "":
+		return errors.New("Kurs nomi kiritilmagan")
+	case !validGrade(c.MinTheory) || !validGrade(c.MinPractice):
+		return errors.New("Minimal baho 2 dan 5 gacha bo'lishi kerak")
+	case c.MaxAttempts < 1:
+		return errors.New("Urinishlar soni kamida 1 bo'lishi kerak")
+	case c.TheoryWeight < 0 || c.TheoryWeight > 100:
+		return errors.New("Nazariya ulushi 0 dan 100 gacha bo'lishi kerak")
+	case c.MinFinal < minGrade || c.MinFinal > maxGrade:
+		return errors.New("Yakuniy minimal ball 2 dan 5 gacha bo'lishi kerak")
+	case c.ValidityMonths != nil && *c.ValidityMonths <= 0:
+		return errors.New("Amal qilish muddati musbat bo'lishi kerak")
+	}
+	return nil
+}
+
+// Взвешенная итоговая оценка и результат по правилам курса.
+func evaluateGrades(c Course, grade1, grade2 int) (float64, bool) {
+	w := float64(c.TheoryWeight) / 100
+	score := float64(grade1)*w + float64(grade2)*(1-w)
+	score = math.Round(score*100) / 100
+	passed := grade1 >= c.MinTheory && grade2 >= c.MinPractice && score >= c.MinFinal
+	return score, passed
+}
+
+const courseColumns = `
+	id, name, min_theory, min_practice, max_attempts, theory_weight, min_final,
+	validity_months, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanCourse(row interface{ Scan(...interface{}) error }, c *Course) error {
+	return row.Scan(&c.ID, &c.Name, &c.MinTheory, &c.MinPractice,
+		&c.MaxAttempts, &c.TheoryWeight, &c.MinFinal, &c.ValidityMonths, &c.CreatedAt)
+}
+
+// Курс по ID, а если он не указан — по названию (в документах это title).
+// Если курс не найден, возвращается defaultCourse с ID 0.
+func findCourse(id int, name string) (Course, error) {
+	var c Course
+	var err error
+	if id != 0 {
+		err = scanCourse(db.QueryRow(`SELECT `+courseColumns+` FROM courses WHERE id=$1`, id), &c)
+		if err == sql.ErrNoRows {
+			return c, errors.New("Kurs topilmadi")
+		}
+		return c, err
+	}
+
+	err = scanCourse(db.QueryRow(`SELECT `+courseColumns+` FROM courses WHERE lower(name)=lower($1)`,
+		strings.TrimSpace(name)), &c)
+	if err == sql.ErrNoRows {
+		return defaultCourse, nil
+	}
+	return c, err
+}
+
+func coursesList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT ` + courseColumns + ` FROM courses ORDER BY name`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Course{}
+	for rows.Next() {
+		var c Course
+		if err := scanCourse(rows, &c); err != nil {
+			log.Printf("Error scanning course: %v", err)
+			continue
+		}
+		list = append(list, c)
+	}
+
+	respondJSON(w, list)
+}
+
+func courseGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri kurs ID", 400)
+		return
+	}
+
+	var c Course
+	err = scanCourse(db.QueryRow(`SELECT `+courseColumns+` FROM courses WHERE id=$1`, id), &c)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Kurs topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	respondJSON(w, c)
+}
+
+func courseCreate(w http.ResponseWriter, r *http.Request) {
+	input := defaultCourse
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if err := validateCourse(input); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO courses (name, min_theory, min_practice, max_attempts, theory_weight, min_final, validity_months)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		input.Name, input.MinTheory, input.MinPractice,
+		input.MaxAttempts, input.TheoryWeight, input.MinFinal, input.ValidityMonths,
+	).Scan(&id)
+	if err != nil {
+		log.Printf("Kurs yaratish xatosi: %v", err)
+		http.Error(w, "Kurs yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Kurs yaratildi",
+		"id":      id,
+	})
+}
+
+func courseUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri kurs ID", 400)
+		return
+	}
+
+	input := defaultCourse
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if err := validateCourse(input); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE courses
+		SET name=$1, min_theory=$2, min_practice=$3, max_attempts=$4,
+			theory_weight=$5, min_final=$6, validity_months=$7
+		WHERE id=$8`,
+		input.Name, input.MinTheory, input.MinPractice,
+		input.MaxAttempts, input.TheoryWeight, input.MinFinal, input.ValidityMonths, id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Kurs topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+func courseDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri kurs ID", 400)
+		return
+	}
+
+	var used bool
+	err = db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM exam_attempts WHERE course_id=$1)
+		    OR EXISTS(SELECT 1 FROM documents WHERE course_id=$1)`, id,
+	).Scan(&used)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if used {
+		http.Error(w, "Kurs ishlatilmoqda", 409)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM courses WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Kurs topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+/* ---------- attempts ---------- */
+
+// Записывает попытку сдачи экзамена. Повторная запись за ту же сессию
+// обновляет существующую попытку, новая — проверяется по лимиту пересдач.
+func recordAttempt(tx *sql.Tx, c Course, a *ExamAttempt) error {
+	a.FinalScore, a.Passed = evaluateGrades(c, a.Grade1, a.Grade2)
+
+	if a.SessionID != 0 {
+		err := tx.QueryRow(`
+			UPDATE exam_attempts
+			SET grade1=$1, grade2=$2, final_score=$3, passed=$4
+			WHERE session_id=$5 AND student_jshshir=$6
+			RETURNING id, attempt_no`,
+			a.Grade1, a.Grade2, a.FinalScore, a.Passed, a.SessionID, a.StudentJSHSHIR,
+		).Scan(&a.ID, &a.AttemptNo)
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	var count int
+	var alreadyPassed bool
+	err := tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(bool_or(passed), false) FROM exam_attempts
+		WHERE student_jshshir=$1 AND course_id=$2`,
+		a.StudentJSHSHIR, c.ID,
+	).Scan(&count, &alreadyPassed)
+	if err != nil {
+		return err
+	}
+	if alreadyPassed {
+		return errors.New("Talaba bu kurs imtihonini allaqachon topshirgan")
+	}
+	if count >= c.MaxAttempts {
+		return errRetakeLimit
+	}
+
+	a.AttemptNo = count + 1
+	return tx.QueryRow(`
+		INSERT INTO exam_attempts
+		(student_jshshir, course_id, session_id, attempt_no, grade1, grade2, final_score, passed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		a.StudentJSHSHIR, c.ID, nullIfZero(a.SessionID), a.AttemptNo,
+		a.Grade1, a.Grade2, a.FinalScore, a.Passed,
+	).Scan(&a.ID)
+}
+
+func studentAttemptsList(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	rows, err := db.Query(`
+		SELECT a.id, a.student_jshshir, a.course_id, c.name, COALESCE(a.session_id, 0),
+			a.attempt_no, a.grade1, a.grade2, a.final_score, a.passed,
+			to_char(a.created_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM exam_attempts a
+		JOIN courses c ON c.id = a.course_id
+		WHERE a.student_jshshir=$1
+		ORDER BY c.name, a.attempt_no`, jshshir)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []ExamAttempt{}
+	for rows.Next() {
+		var a ExamAttempt
+		err := rows.Scan(&a.ID, &a.StudentJSHSHIR, &a.CourseID, &a.CourseName, &a.SessionID,
+			&a.AttemptNo, &a.Grade1, &a.Grade2, &a.FinalScore, &a.Passed, &a.CreatedAt)
+		if err != nil {
+			log.Printf("Error scanning attempt: %v", err)
+			continue
+		}
+		list = append(list, a)
+	}
+
+	respondJSON(w, list)
+}
+
+func studentAttemptCreate(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	var a ExamAttempt
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	a.StudentJSHSHIR = jshshir
+	if a.CourseID == 0 {
+		http.Error(w, "Kurs ko'rsatilmagan", 400)
+		return
+	}
+	if !validGrade(a.Grade1) || !validGrade(a.Grade2) {
+		http.Error(w, "Baho 2 dan 5 gacha bo'lishi kerak", 400)
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, jshshir).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !exists {
+		http.Error(w, "Talaba topilmadi", 404)
+		return
+	}
+
+	c, err := findCourse(a.CourseID, "")
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := recordAttempt(tx, c, &a); err != nil {
+		log.Printf("Urinishni yozish xatosi: %v", err)
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, a)
+}
+
+/* ---------- documents ---------- */
+
+// Проверяет статус и оценки документа и вычисляет итог экзамена по
+// правилам курса. Сертификат не выдаётся, если студент не сдал экзамен.
+// При правке (prev — сохранённый документ) проверяются только изменённые
+// поля: у старых документов бывают нулевые оценки, и без этого их
+// нельзя было бы отредактировать. Возвращает HTTP-код при ошибке.
+func applyGradingRules(input *DocumentInput, prev *DocumentInput) (int, error) {
+	input.Status = strings.TrimSpace(input.Status)
+	if input.Status == "" {
+		input.Status = "active"
+	}
+	valid := prev != nil && input.Status == prev.Status
+	for _, s := range validDocumentStatuses {
+		if input.Status == s {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return 400, errors.New("Noto'g'ri guvohnoma holati")
+	}
+
+	c, err := findCourse(input.CourseID, input.Title)
+	if err != nil {
+		return 404, err
+	}
+	input.CourseID = c.ID
+
+	if prev != nil && input.Grade1 == prev.Grade1 && input.Grade2 == prev.Grade2 &&
+		input.CourseID == prev.CourseID &&
+		strings.TrimSpace(input.StudentJSHSHIR) == strings.TrimSpace(prev.StudentJSHSHIR) {
+		input.FinalScore = prev.FinalScore
+		return 0, nil
+	}
+
+	if !validGrade(input.Grade1) || !validGrade(input.Grade2) {
+		return 400, errors.New("Baho 2 dan 5 gacha bo'lishi kerak")
+	}
+
+	// Если по курсу есть история попыток, нужна хотя бы одна сданная.
+	if c.ID != 0 && input.StudentJSHSHIR != "" {
+		var attempts int
+		var anyPassed bool
+		err := db.QueryRow(`
+			SELECT COUNT(*), COALESCE(bool_or(passed), false) FROM exam_attempts
+			WHERE student_jshshir=$1 AND course_id=$2`,
+			strings.TrimSpace(input.StudentJSHSHIR), c.ID,
+		).Scan(&attempts, &anyPassed)
+		if err != nil {
+			return 500, err
+		}
+		if attempts > 0 && !anyPassed {
+			return 422, errors.New("Talaba imtihondan o'tmagan, guvohnoma berilmaydi")
+		}
+	}
+
+	score, passed := evaluateGrades(c, input.Grade1, input.Grade2)
+	input.FinalScore = score
+	if !passed {
+		return 422, errors.New("Talaba imtihondan o'tmagan, guvohnoma berilmaydi")
+	}
+	return 0, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+/* =========================
+   GROUPS
+========================= */
+
+type Group struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	CourseID    int               `json:"course_id,omitempty"`
+	CourseName  string            `json:"course_name,omitempty"`
+	Categories  CategoryList      `json:"categories"`
+	StartDate   string            `json:"start_date"`
+	EndDate     string            `json:"end_date"`
+	CreatedAt   string            `json:"created_at"`
+	Students    []Student         `json:"students,omitempty"`
+	Instructors []GroupInstructor `json:"instructors,omitempty"`
+}
+
+type GroupInstructor struct {
+	InstructorID int    `json:"instructor_id"`
+	FullName     string `json:"full_name"`
+	Kind         string `json:"kind"`
+	Subject      string `json:"subject"`
+	Hours        int    `json:"hours"`
+}
+
+const groupColumns = `
+	g.id, g.name, COALESCE(g.course_id, 0), COALESCE(c.name, ''),
+	COALESCE(to_char(g.start_date, 'YYYY-MM-DD'), ''),
+	COALESCE(to_char(g.end_date, 'YYYY-MM-DD'), ''),
+	to_char(g.created_at, 'YYYY-MM-DD HH24:MI:SS'),
+	COALESCE(g.category_codes, '{}')`
+
+const groupFrom = ` FROM study_groups g LEFT JOIN courses c ON c.id = g.course_id`
+
+func scanGroup(row interface{ Scan(...interface{}) error }, g *Group) error {
+	return row.Scan(&g.ID, &g.Name, &g.CourseID, &g.CourseName,
+		&g.StartDate, &g.EndDate, &g.CreatedAt, pq.Array((*[]string)(&g.Categories)))
+}
+
+func groupsList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT ` + groupColumns + groupFrom + ` ORDER BY g.start_date DESC NULLS LAST, g.id DESC`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Group{}
+	for rows.Next() {
+		var g Group
+		if err := scanGroup(rows, &g); err != nil {
+			log.Printf("Error scanning group: %v", err)
+			continue
+		}
+		list = append(list, g)
+	}
+
+	respondJSON(w, list)
+}
+
+func groupGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+
+	var g Group
+	err = scanGroup(db.QueryRow(`SELECT `+groupColumns+groupFrom+` WHERE g.id=$1`, id), &g)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Guruh topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT s.jshshir, s.full_name, s.birth_date, s.phone
+		FROM group_students gs
+		JOIN students s ON s.jshshir = gs.student_jshshir
+		WHERE gs.group_id=$1
+		ORDER BY s.full_name`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Student
+		if err := rows.Scan(&s.JSHSHIR, &s.FullName, &s.BirthDate, &s.Phone); err != nil {
+			log.Printf("Error scanning group student: %v", err)
+			continue
+		}
+		g.Students = append(g.Students, s)
+	}
+
+	irows, err := db.Query(`
+		SELECT i.id, i.full_name, i.kind, gi.subject, gi.hours
+		FROM group_instructors gi
+		JOIN instructors i ON i.id = gi.instructor_id
+		WHERE gi.group_id=$1
+		ORDER BY i.kind, i.full_name`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer irows.Close()
+	for irows.Next() {
+		var gi GroupInstructor
+		if err := irows.Scan(&gi.InstructorID, &gi.FullName, &gi.Kind, &gi.Subject, &gi.Hours); err != nil {
+			log.Printf("Error scanning group instructor: %v", err)
+			continue
+		}
+		g.Instructors = append(g.Instructors, gi)
+	}
+
+	respondJSON(w, g)
+}
+
+func groupCreate(w http.ResponseWriter, r *http.Request) {
+	var input Group
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		http.Error(w, "Guruh nomi kiritilmagan", 400)
+		return
+	}
+	if err := checkCategoryCodes(input.Categories); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO study_groups (name, course_id, start_date, end_date, category_codes)
+		VALUES ($1, $2, NULLIF($3, '')::date, NULLIF($4, '')::date, $5)
+		RETURNING id`,
+		input.Name, nullIfZero(input.CourseID), input.StartDate, input.EndDate,
+		pq.Array([]string(input.Categories)),
+	).Scan(&id)
+	if err != nil {
+		log.Printf("Guruh yaratish xatosi: %v", err)
+		http.Error(w, "Guruh yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, map[string]interface{}{
+		"status":  "success",
+		"message": "Guruh yaratildi",
+		"id":      id,
+	})
+}
+
+func groupUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+
+	var input Group
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		http.Error(w, "Guruh nomi kiritilmagan", 400)
+		return
+	}
+	if err := checkCategoryCodes(input.Categories); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE study_groups
+		SET name=$1, course_id=$2, start_date=NULLIF($3, '')::date, end_date=NULLIF($4, '')::date,
+		    category_codes=$5
+		WHERE id=$6`,
+		input.Name, nullIfZero(input.CourseID), input.StartDate, input.EndDate,
+		pq.Array([]string(input.Categories)), id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Guruh topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+func groupDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM study_groups WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Guruh topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+func groupStudentAdd(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+
+	var input struct {
+		StudentJSHSHIR string `json:"student_jshshir"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	jshshir := strings.TrimSpace(input.StudentJSHSHIR)
+
+	var categories CategoryList
+	var startDate string
+	err = db.QueryRow(`
+		SELECT COALESCE(category_codes, '{}'), COALESCE(to_char(start_date, 'YYYY-MM-DD'), '')
+		FROM study_groups WHERE id=$1`, id,
+	).Scan(pq.Array((*[]string)(&categories)), &startDate)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Guruh topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var studentExists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, jshshir).Scan(&studentExists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !studentExists {
+		http.Error(w, "Talaba topilmadi", 404)
+		return
+	}
+
+	// Допуск проверяется на начало занятий, а для уже идущей группы — на сегодня
+	on := currentDate()
+	if start, err := time.Parse("2006-01-02", startDate); err == nil && start.After(on) {
+		on = start
+	}
+	if code, err := checkPrerequisites(jshshir, categories, on); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO group_students (group_id, student_jshshir)
+		VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, jshshir)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "added"})
+}
+
+func groupStudentRemove(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+	jshshir := mux.Vars(r)["jshshir"]
+
+	result, err := db.Exec(`DELETE FROM group_students WHERE group_id=$1 AND student_jshshir=$2`, id, jshshir)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Talaba guruhda emas", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "removed"})
+}
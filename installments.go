@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/* =========================
+   INSTALLMENTS
+========================= */
+
+const maxInstallments = 12
+
+type Installment struct {
+	ID         int    `json:"id,omitempty"`
+	InvoiceID  int    `json:"invoice_id,omitempty"`
+	Seq        int    `json:"seq"`
+	Amount     Money  `json:"amount"`
+	DueDate    string `json:"due_date"`
+	PaidAmount Money  `json:"paid_amount"`
+	Status     string `json:"status"` // pending | partially_paid | paid | overdue | cancelled
+	DaysLate   int    `json:"days_late,omitempty"`
+}
+
+type OverdueInstallment struct {
+	Installment
+	InvoiceNumber  string `json:"invoice_number"`
+	StudentJSHSHIR string `json:"student_jshshir"`
+	StudentName    string `json:"student_name"`
+	StudentPhone   string `json:"student_phone"`
+}
+
+// Строит график платежей для нового счёта. Если график передан явно,
+// проверяет его; если задано только число частей — делит сумму поровну
+// с ежемесячными сроками, остаток от деления уходит в последнюю часть.
+func buildInstallments(amount Money, count int, given []Installment, issued time.Time) ([]Installment, error) {
+	if len(given) > 0 {
+		if len(given) > maxInstallments {
+			return nil, fmt.Errorf("Bo'lib to'lash %d qismdan oshmasligi kerak", maxInstallments)
+		}
+		var sum Money
+		prev := ""
+		for i := range given {
+			given[i].Seq = i + 1
+			if given[i].Amount <= 0 {
+				return nil, errors.New("Har bir qism summasi musbat bo'lishi kerak")
+			}
+			if _, err := time.Parse("2006-01-02", given[i].DueDate); err != nil {
+				return nil, errors.New("Qism muddati noto'g'ri (YYYY-MM-DD)")
+			}
+			if given[i].DueDate < prev {
+				return nil, errors.New("Qismlar muddati o'sish tartibida bo'lishi kerak")
+			}
+			prev = given[i].DueDate
+			sum += given[i].Amount
+		}
+		if sum != amount {
+			return nil, errors.New("Qismlar yig'indisi invoyis summasiga teng emas")
+		}
+		return given, nil
+	}
+
+	if count <= 1 {
+		return nil, nil
+	}
+	if count > maxInstallments {
+		return nil, fmt.Errorf("Bo'lib to'lash %d qismdan oshmasligi kerak", maxInstallments)
+	}
+
+	part := amount / Money(count)
+	list := make([]Installment, count)
+	var sum Money
+	for i := 0; i < count; i++ {
+		list[i] = Installment{
+			Seq:     i + 1,
+			Amount:  part,
+			DueDate: issued.AddDate(0, i+1, 0).Format("2006-01-02"),
+		}
+		sum += part
+	}
+	list[count-1].Amount = part + amount - sum
+	return list, nil
+}
+
+func insertInstallments(tx *sql.Tx, invoiceID int, list []Installment) error {
+	for _, inst := range list {
+		_, err := tx.Exec(`
+			INSERT INTO invoice_installments (invoice_id, seq, amount, due_date)
+			VALUES ($1, $2, $3, $4::date)`,
+			invoiceID, inst.Seq, inst.Amount, inst.DueDate,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Распределяет оплаченную сумму по частям по порядку и проставляет статусы.
+func allocateInstallments(list []Installment, paid Money, cancelled bool, today string) {
+	for i := range list {
+		inst := &list[i]
+		inst.PaidAmount = maxMoney(minMoney(paid, inst.Amount), 0)
+		paid -= inst.PaidAmount
+
+		switch {
+		case cancelled:
+			inst.Status = "cancelled"
+		case inst.PaidAmount >= inst.Amount:
+			inst.Status = "paid"
+		case inst.DueDate < today:
+			inst.Status = "overdue"
+			if due, err := time.Parse("2006-01-02", inst.DueDate); err == nil {
+				now, _ := time.Parse("2006-01-02", today)
+				inst.DaysLate = int(now.Sub(due).Hours() / 24)
+			}
+		case inst.PaidAmount > 0:
+			inst.Status = "partially_paid"
+		default:
+			inst.Status = "pending"
+		}
+	}
+}
+
+// График счёта со статусами. У счёта без графика одна часть —
+// вся сумма со сроком due_date.
+func loadInstallments(invoiceID int) ([]Installment, error) {
+	var amount, paid, credit Money
+	var status, dueDate string
+	err := db.QueryRow(`
+		SELECT i.amount, i.status, COALESCE(i.due_date::text, ''),
+			COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+			`+invoiceCreditSQL+`
+		FROM invoices i WHERE i.id=$1`, invoiceID,
+	).Scan(&amount, &status, &dueDate, &paid, &credit)
+	if err == sql.ErrNoRows {
+		return nil, errInvoiceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, invoice_id, seq, amount, to_char(due_date, 'YYYY-MM-DD')
+		FROM invoice_installments WHERE invoice_id=$1
+		ORDER BY seq`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Installment{}
+	for rows.Next() {
+		var inst Installment
+		if err := rows.Scan(&inst.ID, &inst.InvoiceID, &inst.Seq, &inst.Amount, &inst.DueDate); err != nil {
+			return nil, err
+		}
+		list = append(list, inst)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		list = append(list, Installment{InvoiceID: invoiceID, Seq: 1, Amount: amount, DueDate: dueDate})
+	}
+
+	applyCredit(list, credit)
+	allocateInstallments(list, paid-credit, status == invoiceStatusCancelled, time.Now().Format("2006-01-02"))
+	return list, nil
+}
+
+func invoiceInstallmentsList(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", 400)
+		return
+	}
+
+	list, err := loadInstallments(invoiceID)
+	if err == errInvoiceNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, list)
+}
+
+// Просроченные части по всем неоплаченным счетам: основа для
+// напоминаний и отчёта по задолженности.
+func findOverdueInstallments() ([]OverdueInstallment, error) {
+	rows, err := db.Query(`
+		SELECT i.id,
+			COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')),
+			i.student_jshshir, COALESCE(s.full_name, i.student_name, ''), COALESCE(s.phone, '')
+		FROM invoices i
+		LEFT JOIN students s ON s.jshshir = i.student_jshshir
+		WHERE i.status IN ($1, $2)
+		ORDER BY i.id`,
+		invoiceStatusPending, invoiceStatusPartial)
+	if err != nil {
+		return nil, err
+	}
+
+	type invoiceRef struct {
+		id                    int
+		number, jshshir, name string
+		phone                 string
+	}
+	var refs []invoiceRef
+	for rows.Next() {
+		var ref invoiceRef
+		if err := rows.Scan(&ref.id, &ref.number, &ref.jshshir, &ref.name, &ref.phone); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []OverdueInstallment{}
+	for _, ref := range refs {
+		insts, err := loadInstallments(ref.id)
+		if err != nil {
+			log.Printf("Invoyis %d grafigini yuklash xatosi: %v", ref.id, err)
+			continue
+		}
+		for _, inst := range insts {
+			if inst.Status != "overdue" {
+				continue
+			}
+			list = append(list, OverdueInstallment{
+				Installment:    inst,
+				InvoiceNumber:  ref.number,
+				StudentJSHSHIR: ref.jshshir,
+				StudentName:    ref.name,
+				StudentPhone:   ref.phone,
+			})
+		}
+	}
+	return list, nil
+}
+
+func installmentsOverdue(w http.ResponseWriter, r *http.Request) {
+	list, err := findOverdueInstallments()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, list)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+/* =========================
+   INSTRUCTORS
+========================= */
+
+// teacher — преподаватель теории, master — мастер практического вождения
+var instructorKinds = []string{"teacher", "master"}
+
+type Instructor struct {
+	ID             int      `json:"id"`
+	FullName       string   `json:"full_name"`
+	Kind           string   `json:"kind"`
+	Qualifications []string `json:"qualifications"`
+	Phone          string   `json:"phone"`
+	Email          string   `json:"email"`
+	Active         bool     `json:"active"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+type InstructorWorkload struct {
+	InstructorID int    `json:"instructor_id"`
+	FullName     string `json:"full_name"`
+	Kind         string `json:"kind"`
+	Groups       int    `json:"groups"`
+	Students     int    `json:"students"`
+	Hours        int    `json:"hours"`
+	ExamSessions int    `json:"exam_sessions"`
+}
+
+var errInstructorNotFound = errors.New("O'qituvchi topilmadi")
+
+func isValidInstructorKind(kind string) bool {
+	for _, k := range instructorKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+const instructorColumns = `
+	id, full_name, kind, qualifications, phone, email, active,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanInstructor(row interface{ Scan(...interface{}) error }, i *Instructor) error {
+	return row.Scan(&i.ID, &i.FullName, &i.Kind, pq.Array(&i.Qualifications),
+		&i.Phone, &i.Email, &i.Active, &i.CreatedAt)
+}
+
+func instructorsList(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + instructorColumns + ` FROM instructors`
+	args := []interface{}{}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		query += ` WHERE kind=$1`
+		args = append(args, kind)
+	}
+	query += ` ORDER BY full_name`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Instructor{}
+	for rows.Next() {
+		var i Instructor
+		if err := scanInstructor(rows, &i); err != nil {
+			log.Printf("Error scanning instructor: %v", err)
+			continue
+		}
+		list = append(list, i)
+	}
+
+	respondJSON(w, list)
+}
+
+func instructorGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri o'qituvchi ID", 400)
+		return
+	}
+
+	var i Instructor
+	err = scanInstructor(db.QueryRow(`SELECT `+instructorColumns+` FROM instructors WHERE id=$1`, id), &i)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "O'qituvchi topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	respondJSON(w, i)
+}
+
+func decodeInstructor(r *http.Request) (Instructor, string) {
+	input := Instructor{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return input, "Noto'g'ri ma'lumot"
+	}
+	input.FullName = strings.TrimSpace(input.FullName)
+	if input.FullName == "" {
+		return input, "O'qituvchi ismi kiritilmagan"
+	}
+	if !isValidInstructorKind(input.Kind) {
+		return input, "O'qituvchi turi noto'g'ri (teacher yoki master)"
+	}
+	if input.Qualifications == nil {
+		input.Qualifications = []string{}
+	}
+	return input, ""
+}
+
+func instructorCreate(w http.ResponseWriter, r *http.Request) {
+	input, msg := decodeInstructor(r)
+	if msg != "" {
+		http.Error(w, msg, 400)
+		return
+	}
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO instructors (full_name, kind, qualifications, phone, email, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		input.FullName, input.Kind, pq.Array(input.Qualifications),
+		input.Phone, input.Email, input.Active,
+	).Scan(&id)
+	if err != nil {
+		log.Printf("O'qituvchi yaratish xatosi: %v", err)
+		http.Error(w, "O'qituvchi yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, map[string]interface{}{
+		"status":  "success",
+		"message": "O'qituvchi qo'shildi",
+		"id":      id,
+	})
+}
+
+func instructorUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri o'qituvchi ID", 400)
+		return
+	}
+
+	input, msg := decodeInstructor(r)
+	if msg != "" {
+		http.Error(w, msg, 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE instructors
+		SET full_name=$1, kind=$2, qualifications=$3, phone=$4, email=$5, active=$6
+		WHERE id=$7`,
+		input.FullName, input.Kind, pq.Array(input.Qualifications),
+		input.Phone, input.Email, input.Active, id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "O'qituvchi topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+func instructorDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri o'qituvchi ID", 400)
+		return
+	}
+
+	var used bool
+	err = db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM group_instructors WHERE instructor_id=$1)
+		    OR EXISTS(SELECT 1 FROM session_instructors WHERE instructor_id=$1)
+		    OR EXISTS(SELECT 1 FROM documents WHERE instructor_id=$1)`, id,
+	).Scan(&used)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if used {
+		http.Error(w, "O'qituvchi guruh yoki guvohnomalarga biriktirilgan, uni faqat nofaol qilish mumkin", 409)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM instructors WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "O'qituvchi topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+// Преподаватель в документе необязателен; если указан — должен существовать.
+func checkDocumentInstructor(id int) error {
+	if id == 0 {
+		return nil
+	}
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM instructors WHERE id=$1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errInstructorNotFound
+	}
+	return nil
+}
+
+/* ---------- assignments ---------- */
+
+// POST /api/groups/{id}/instructors — назначает (или переназначает)
+// преподавателя группе с предметом и количеством часов.
+func groupInstructorAssign(w http.ResponseWriter, r *http.Request) {
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+
+	var input GroupInstructor
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if input.InstructorID == 0 || input.Hours < 0 {
+		http.Error(w, "O'qituvchi va soatlar noto'g'ri ko'rsatilgan", 400)
+		return
+	}
+
+	var active bool
+	err = db.QueryRow(`SELECT active FROM instructors WHERE id=$1`, input.InstructorID).Scan(&active)
+	if err == sql.ErrNoRows {
+		http.Error(w, "O'qituvchi topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !active {
+		http.Error(w, "O'qituvchi nofaol", 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO group_instructors (group_id, instructor_id, subject, hours)
+		SELECT id, $2::int, $3, $4::int FROM study_groups WHERE id=$1
+		ON CONFLICT (group_id, instructor_id)
+		DO UPDATE SET subject=EXCLUDED.subject, hours=EXCLUDED.hours`,
+		groupID, input.InstructorID, input.Subject, input.Hours,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Guruh topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "assigned"})
+}
+
+func groupInstructorRemove(w http.ResponseWriter, r *http.Request) {
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guruh ID", 400)
+		return
+	}
+	instructorID, err := pathID(r, "instructorId")
+	if err != nil {
+		http.Error(w, "Noto'g'ri o'qituvchi ID", 400)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM group_instructors WHERE group_id=$1 AND instructor_id=$2`, groupID, instructorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "O'qituvchi guruhga biriktirilmagan", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "removed"})
+}
+
+func sessionInstructorAssign(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+
+	var input struct {
+		InstructorID int `json:"instructor_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.InstructorID == 0 {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+
+	var sessionExists, instructorExists bool
+	err = db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM exam_sessions WHERE id=$1),
+		       EXISTS(SELECT 1 FROM instructors WHERE id=$2 AND active)`,
+		sessionID, input.InstructorID,
+	).Scan(&sessionExists, &instructorExists)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !sessionExists {
+		http.Error(w, "Imtihon sessiyasi topilmadi", 404)
+		return
+	}
+	if !instructorExists {
+		http.Error(w, "O'qituvchi topilmadi", 404)
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO session_instructors (session_id, instructor_id)
+		VALUES ($1, $2) ON CONFLICT DO NOTHING`, sessionID, input.InstructorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "assigned"})
+}
+
+func sessionInstructorRemove(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+	instructorID, err := pathID(r, "instructorId")
+	if err != nil {
+		http.Error(w, "Noto'g'ri o'qituvchi ID", 400)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM session_instructors WHERE session_id=$1 AND instructor_id=$2`, sessionID, instructorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "O'qituvchi sessiyaga biriktirilmagan", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "removed"})
+}
+
+// GET /api/reports/instructor-workload?from=YYYY-MM-DD&to=YYYY-MM-DD
+// Группы учитываются, если их период пересекается с заданным,
+// экзаменационные сессии — по дате экзамена.
+func instructorWorkloadReport(w http.ResponseWriter, r *http.Request) {
+	from := strings.TrimSpace(r.URL.Query().Get("from"))
+	to := strings.TrimSpace(r.URL.Query().Get("to"))
+
+	rows, err := db.Query(`
+		SELECT i.id, i.full_name, i.kind,
+			COUNT(DISTINCT g.id),
+			COUNT(DISTINCT gs.student_jshshir),
+			COALESCE((
+				SELECT SUM(gi2.hours) FROM group_instructors gi2
+				JOIN study_groups g2 ON g2.id = gi2.group_id
+				WHERE gi2.instructor_id = i.id
+				  AND ($1::date IS NULL OR COALESCE(g2.end_date, g2.start_date, CURRENT_DATE) >= $1::date)
+				  AND ($2::date IS NULL OR COALESCE(g2.start_date, CURRENT_DATE) <= $2::date)
+			), 0),
+			(
+				SELECT COUNT(*) FROM session_instructors si
+				JOIN exam_sessions es ON es.id = si.session_id
+				WHERE si.instructor_id = i.id
+				  AND ($1::date IS NULL OR es.exam_date >= $1::date)
+				  AND ($2::date IS NULL OR es.exam_date <= $2::date)
+			)
+		FROM instructors i
+		LEFT JOIN group_instructors gi ON gi.instructor_id = i.id
+		LEFT JOIN study_groups g ON g.id = gi.group_id
+			AND ($1::date IS NULL OR COALESCE(g.end_date, g.start_date, CURRENT_DATE) >= $1::date)
+			AND ($2::date IS NULL OR COALESCE(g.start_date, CURRENT_DATE) <= $2::date)
+		LEFT JOIN group_students gs ON gs.group_id = g.id
+		WHERE i.active
+		GROUP BY i.id, i.full_name, i.kind
+		ORDER BY i.full_name`, nullIfEmpty(from), nullIfEmpty(to))
+	if err != nil {
+		log.Printf("Workload report error: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []InstructorWorkload{}
+	for rows.Next() {
+		var wl InstructorWorkload
+		err := rows.Scan(&wl.InstructorID, &wl.FullName, &wl.Kind,
+			&wl.Groups, &wl.Students, &wl.Hours, &wl.ExamSessions)
+		if err != nil {
+			log.Printf("Error scanning workload: %v", err)
+			continue
+		}
+		list = append(list, wl)
+	}
+
+	respondJSON(w, list)
+}
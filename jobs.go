@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* =========================
+   BACKGROUND JOBS
+========================= */
+
+// Очередь фоновых задач в таблице jobs. Задача ставится тем же
+// соединением или транзакцией, что и бизнес-запись (outbox): если
+// транзакция откатилась, задачи нет; если зафиксирована — задача
+// обязательно выполнится, даже после перезапуска сервера.
+//
+// Воркеры забирают задачи через FOR UPDATE SKIP LOCKED, поэтому
+// несколько экземпляров backend могут работать с одной базой.
+// Ошибка обработчика — повтор с экспоненциальной паузой; после
+// max_attempts задача уходит в dead и ждёт разбора через /api/jobs.
+//
+//	JOB_WORKERS      — число воркеров (по умолчанию 4);
+//	JOB_MAX_ATTEMPTS — попыток по умолчанию (5).
+
+const (
+	jobStatusQueued  = "queued"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusDead    = "dead"
+)
+
+const (
+	jobPollInterval = 2 * time.Second
+	jobTimeout      = 2 * time.Minute
+	// Задача в running дольше этого срока считается брошенной
+	// (экземпляр упал посреди работы) и возвращается в очередь.
+	jobLockTimeout = 10 * time.Minute
+	jobMaxBackoff  = time.Hour
+)
+
+// Типы задач
+const (
+	jobNotifyInvoice     = "notify.invoice"
+	jobNotifyPayment     = "notify.payment"
+	jobNotifyCertificate = "notify.certificate"
+	jobSMSDeliver        = "sms.deliver"
+	jobTelegramDeliver   = "telegram.deliver"
+	jobEmailSend         = "email.send"
+	jobMarkOverdue       = "invoices.mark_overdue"
+	jobMarkExpired       = "documents.mark_expired"
+	jobPaymentReminders  = "payments.remind"
+	jobWebhookDispatch   = "webhook.dispatch"
+	jobWebhookDeliver    = "webhook.deliver"
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       string          `json:"run_at"`
+	LockedBy    string          `json:"locked_by,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	DedupKey    string          `json:"dedup_key,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	FinishedAt  string          `json:"finished_at,omitempty"`
+}
+
+// Последняя ли это попытка: обработчик может записать окончательную
+// ошибку в свой журнал (SMS, письма) перед уходом задачи в dead.
+func (j *Job) lastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+func (j *Job) decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("%s #%d: noto'g'ri payload: %w", j.Kind, j.ID, err)
+	}
+	return nil
+}
+
+type jobHandler func(ctx context.Context, j *Job) error
+
+// Обработчики по типу задачи; заполняется registerJobHandlers до старта
+// сервера (литералом нельзя — обработчики сами ставят задачи).
+var jobHandlers = map[string]jobHandler{}
+
+func registerJobHandlers() {
+	jobHandlers[jobNotifyInvoice] = notifyInvoiceJob
+	jobHandlers[jobNotifyPayment] = notifyPaymentJob
+	jobHandlers[jobNotifyCertificate] = notifyCertificateJob
+	jobHandlers[jobSMSDeliver] = deliverSMSJob
+	jobHandlers[jobTelegramDeliver] = deliverTelegramJob
+	jobHandlers[jobEmailSend] = emailSendJob
+	jobHandlers[jobMarkOverdue] = markOverdueJob
+	jobHandlers[jobMarkExpired] = markExpiredJob
+	jobHandlers[jobPaymentReminders] = paymentRemindersJob
+	jobHandlers[jobWebhookDispatch] = webhookDispatchJob
+	jobHandlers[jobWebhookDeliver] = webhookDeliverJob
+}
+
+// *sql.DB или *sql.Tx — задача ставится в той же транзакции, что и данные.
+type jobQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type jobOptions struct {
+	Delay       time.Duration
+	MaxAttempts int
+	// Задача с уже существующим ключом не ставится повторно.
+	DedupKey string
+}
+
+func defaultJobMaxAttempts() int {
+	if n, err := strconv.Atoi(envDefault("JOB_MAX_ATTEMPTS", "5")); err == nil && n > 0 {
+		return n
+	}
+	return 5
+}
+
+func enqueueJob(q jobQueryer, kind string, payload interface{}) (int64, error) {
+	return enqueueJobWith(q, kind, payload, jobOptions{})
+}
+
+// Возвращает id задачи; 0 — задача с таким DedupKey уже есть.
+func enqueueJobWith(q jobQueryer, kind string, payload interface{}, opts jobOptions) (int64, error) {
+	if _, ok := jobHandlers[kind]; !ok {
+		return 0, fmt.Errorf("Noma'lum vazifa turi: %s", kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultJobMaxAttempts()
+	}
+
+	var id int64
+	err = q.QueryRow(`
+		INSERT INTO jobs (kind, payload, max_attempts, run_at, dedup_key)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond', $5)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		kind, string(data), opts.MaxAttempts, opts.Delay.Milliseconds(), nullIfEmpty(opts.DedupKey),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Пауза перед повтором: 10с, 20с, 40с… до часа, с разбросом ±20%,
+// чтобы упавший шлюз не получил все повторы одновременно.
+func jobBackoff(attempts int) time.Duration {
+	d := jobMaxBackoff
+	if attempts < 20 {
+		if b := 10 * time.Second << uint(attempts-1); b < jobMaxBackoff {
+			d = b
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+/* ---------- воркеры ---------- */
+
+type jobRunner struct {
+	workers  int
+	instance string
+	wake     chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var jobs *jobRunner
+
+func startJobRunner() *jobRunner {
+	workers, err := strconv.Atoi(envDefault("JOB_WORKERS", "4"))
+	if err != nil || workers < 1 {
+		workers = 4
+	}
+	host, _ := os.Hostname()
+	r := &jobRunner{
+		workers:  workers,
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	r.wg.Add(1)
+	go r.reap()
+	log.Printf("⚙️ Fon vazifalari: %d ta ishchi (%s)", workers, r.instance)
+	jobs = r
+	return r
+}
+
+// Будит воркер, не дожидаясь следующего опроса (после коммита транзакции с задачей).
+func (r *jobRunner) notify() {
+	if r == nil {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown перестаёт брать новые задачи и ждёт завершения текущих.
+// Незавершённые к сроку задачи останутся в running и вернутся в очередь
+// после jobLockTimeout.
+func (r *jobRunner) Shutdown(ctx context.Context) error {
+	close(r.stop)
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *jobRunner) work() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		j, err := r.claim()
+		if err != nil {
+			log.Printf("Vazifani olish xatosi: %v", err)
+		}
+		if j != nil {
+			r.run(j)
+			continue // возможно, в очереди есть ещё
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+func (r *jobRunner) claim() (*Job, error) {
+	var j Job
+	var payload string
+	err := db.QueryRow(`
+		UPDATE jobs SET status=$1, attempts=attempts+1, locked_at=NOW(), locked_by=$2
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status=$3 AND run_at <= NOW()
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1)
+		RETURNING id, kind, payload::text, attempts, max_attempts`,
+		jobStatusRunning, r.instance, jobStatusQueued,
+	).Scan(&j.ID, &j.Kind, &payload, &j.Attempts, &j.MaxAttempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	j.Payload = json.RawMessage(payload)
+	j.Status = jobStatusRunning
+	return &j, nil
+}
+
+func (r *jobRunner) run(j *Job) {
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		h, ok := jobHandlers[j.Kind]
+		if !ok {
+			return fmt.Errorf("Noma'lum vazifa turi: %s", j.Kind)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		defer cancel()
+		return h(ctx, j)
+	}()
+
+	switch {
+	case err == nil:
+		_, err = db.Exec(`
+			UPDATE jobs SET status=$2, last_error=NULL, locked_at=NULL, locked_by=NULL, finished_at=NOW()
+			WHERE id=$1`, j.ID, jobStatusDone)
+	case j.lastAttempt():
+		log.Printf("☠️ Vazifa %s #%d to'xtatildi (%d-urinish): %v", j.Kind, j.ID, j.Attempts, err)
+		_, err = db.Exec(`
+			UPDATE jobs SET status=$2, last_error=$3, locked_at=NULL, locked_by=NULL, finished_at=NOW()
+			WHERE id=$1`, j.ID, jobStatusDead, err.Error())
+	default:
+		delay := jobBackoff(j.Attempts)
+		log.Printf("Vazifa %s #%d xatosi (%d-urinish), %s dan keyin qayta: %v",
+			j.Kind, j.ID, j.Attempts, delay.Round(time.Second), err)
+		_, err = db.Exec(`
+			UPDATE jobs SET status=$2, last_error=$3, locked_at=NULL, locked_by=NULL,
+				run_at=NOW() + $4 * INTERVAL '1 millisecond'
+			WHERE id=$1`, j.ID, jobStatusQueued, err.Error(), delay.Milliseconds())
+	}
+	if err != nil {
+		log.Printf("Vazifa #%d holatini yozish xatosi: %v", j.ID, err)
+	}
+}
+
+// Возвращает в очередь задачи, брошенные упавшими экземплярами.
+func (r *jobRunner) reap() {
+	defer r.wg.Done()
+	for {
+		res, err := db.Exec(`
+			UPDATE jobs SET status=$1, locked_at=NULL, locked_by=NULL,
+				last_error=COALESCE(last_error, 'ishchi javob bermadi')
+			WHERE status=$2 AND locked_at < NOW() - $3 * INTERVAL '1 second'`,
+			jobStatusQueued, jobStatusRunning, int(jobLockTimeout.Seconds()))
+		if err != nil {
+			log.Printf("Osilib qolgan vazifalarni qaytarish xatosi: %v", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("⚙️ %d ta osilib qolgan vazifa navbatga qaytarildi", n)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+/* ---------- периодические задачи ---------- */
+
+// Ставит задачу раз в interval. Ключ содержит номер интервала, поэтому
+// при нескольких экземплярах backend задача выполняется один раз.
+func schedulePeriodicJob(kind string, interval time.Duration) {
+	go func() {
+		for {
+			slot := time.Now().Truncate(interval).Unix()
+			_, err := enqueueJobWith(db, kind, struct{}{}, jobOptions{
+				MaxAttempts: 1,
+				DedupKey:    fmt.Sprintf("%s:%d", kind, slot),
+			})
+			if err != nil {
+				log.Printf("Davriy vazifa %s xatosi: %v", kind, err)
+			} else {
+				jobs.notify()
+			}
+			time.Sleep(time.Until(time.Unix(slot, 0).Add(interval)) + time.Second)
+		}
+	}()
+}
+
+/* ---------- API ---------- */
+
+type JobStat struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+const jobColumns = `
+	id, kind, payload::text, status, attempts, max_attempts,
+	to_char(run_at, 'YYYY-MM-DD HH24:MI:SS'), COALESCE(locked_by, ''), COALESCE(last_error, ''),
+	COALESCE(dedup_key, ''), to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'),
+	COALESCE(to_char(finished_at, 'YYYY-MM-DD HH24:MI:SS'), '')`
+
+func scanJob(row interface{ Scan(...interface{}) error }, j *Job) error {
+	var payload string
+	err := row.Scan(&j.ID, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &j.LockedBy, &j.LastError, &j.DedupKey, &j.CreatedAt, &j.FinishedAt)
+	j.Payload = json.RawMessage(payload)
+	return err
+}
+
+// GET /api/jobs?status=dead&kind=
+func jobsList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rows, err := db.Query(`SELECT `+jobColumns+`
+		FROM jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2)
+		ORDER BY id DESC
+		LIMIT 500`, q.Get("status"), q.Get("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Job{}
+	for rows.Next() {
+		var j Job
+		if err := scanJob(rows, &j); err != nil {
+			log.Printf("Error scanning job: %v", err)
+			continue
+		}
+		list = append(list, j)
+	}
+	respondJSON(w, list)
+}
+
+// GET /api/jobs/stats — количество задач по типу и статусу.
+func jobsStats(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT kind, status, COUNT(*) FROM jobs GROUP BY kind, status ORDER BY kind, status`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []JobStat{}
+	for rows.Next() {
+		var s JobStat
+		if err := rows.Scan(&s.Kind, &s.Status, &s.Count); err != nil {
+			log.Printf("Error scanning job stat: %v", err)
+			continue
+		}
+		list = append(list, s)
+	}
+	respondJSON(w, list)
+}
+
+func jobGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri vazifa ID", 400)
+		return
+	}
+	var j Job
+	err = scanJob(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id=$1`, id), &j)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Vazifa topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, j)
+}
+
+var jobAdminRoles = []string{roleDirector, roleAccountant}
+
+// POST /api/jobs/{id}/retry — вернуть задачу из dead в очередь с новыми попытками.
+func jobRetry(w http.ResponseWriter, r *http.Request) {
+	jobAdminAction(w, r, `
+		UPDATE jobs SET status='queued', attempts=0, run_at=NOW(), finished_at=NULL
+		WHERE id=$1 AND status='dead'`, "queued")
+}
+
+// POST /api/jobs/{id}/discard — удалить задачу из dead без выполнения.
+func jobDiscard(w http.ResponseWriter, r *http.Request) {
+	jobAdminAction(w, r, `DELETE FROM jobs WHERE id=$1 AND status='dead'`, "discarded")
+}
+
+func jobAdminAction(w http.ResponseWriter, r *http.Request, query, status string) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri vazifa ID", 400)
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(input.Role, jobAdminRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+
+	res, err := db.Exec(query, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Vazifa topilmadi yoki dead holatida emas", 409)
+		return
+	}
+	log.Printf("Vazifa #%d: %s (%s)", id, status, input.Role)
+	jobs.notify()
+	respondJSON(w, map[string]string{"status": status})
+}
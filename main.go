@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	//"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 //	"image/png"
@@ -12,14 +14,16 @@ import (
 	"net/http"
 //	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	//"github.com/skip2/go-qrcode"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 /* =========================
@@ -36,6 +40,9 @@ type Dashboard struct {
 	Users     int `json:"users"`
 	Students  int `json:"students"`
 	Documents int `json:"documents"`
+
+	// Финансы за текущий месяц
+	Finance *FinanceSummary `json:"finance,omitempty"`
 }
 
 type Student struct {
@@ -43,6 +50,7 @@ type Student struct {
 	FullName  string `json:"full_name"`
 	BirthDate string `json:"birth_date"`
 	Phone     string `json:"phone"`
+	Age       *int   `json:"age,omitempty"` // по birth_date, только в studentGet
 }
 
 type Document struct {
@@ -62,6 +70,17 @@ type Document struct {
 	CommissionNo    sql.NullString `json:"commission_number"`
 	DirectorName    sql.NullString `json:"director_name"`
 	CreatedAt       sql.NullString `json:"created_at"`
+	CommissionID    sql.NullInt64  `json:"commission_id"`
+	SessionID       sql.NullInt64  `json:"session_id"`
+	CourseID        sql.NullInt64  `json:"course_id"`
+	FinalScore      sql.NullFloat64 `json:"final_score"`
+	ExamResult      sql.NullString `json:"exam_result"`
+	CategoryCodes   pq.StringArray `json:"category_codes"`
+	ExpiresAt       sql.NullString `json:"expires_at"`
+	Expired         bool           `json:"expired"`
+	InstructorID    sql.NullInt64  `json:"instructor_id"`
+	RevokedAt       sql.NullString `json:"revoked_at"`
+	RevokeReason    sql.NullString `json:"revoke_reason"`
 }
 
 type DocumentOutput struct {
@@ -81,12 +100,27 @@ type DocumentOutput struct {
 	CommissionNo    string `json:"commission_number"`
 	DirectorName    string `json:"director_name"`
 	CreatedAt       string `json:"created_at"`
+	CommissionID    int    `json:"commission_id,omitempty"`
+	SessionID       int    `json:"session_id,omitempty"`
+	CourseID        int     `json:"course_id,omitempty"`
+	FinalScore      float64 `json:"final_score,omitempty"`
+	ExamResult      string  `json:"exam_result,omitempty"`
+	CategoryCodes   []string `json:"category_codes"`
+	ExpiresAt       string   `json:"expires_at,omitempty"`
+	Expired         bool     `json:"expired"`
+	InstructorID    int      `json:"instructor_id,omitempty"`
+	Revoked         bool     `json:"revoked"`
+	RevokedAt       string   `json:"revoked_at,omitempty"`
+	RevokeReason    string   `json:"revoke_reason,omitempty"`
 }
 
 type DocumentDetail struct {
 	DocumentOutput
 	StudentBirthDate string `json:"student_birth_date"`
 	StudentPhone     string `json:"student_phone"`
+	Commission       *Commission `json:"commission,omitempty"`
+	InstructorName   string `json:"instructor_name,omitempty"`
+	PhotoID          int    `json:"photo_attachment_id,omitempty"`
 	// QRCodeBase64     string `json:"qr_code_base64"`
 }
 
@@ -97,7 +131,7 @@ type DocumentInput struct {
 	CourseStart     string `json:"course_start"`
 	CourseEnd       string `json:"course_end"`
 	ExamDate        string `json:"exam_date"`
-	Categories      string `json:"categories"`
+	Categories      CategoryList `json:"categories"`
 	CourseHours     int    `json:"course_hours"`
 	Grade1          int    `json:"grade1"`
 	Grade2          int    `json:"grade2"`
@@ -105,6 +139,13 @@ type DocumentInput struct {
 	Status          string `json:"status"`
 	CommissionNo    string `json:"commission_number"`
 	DirectorName    string `json:"director_name"`
+	CommissionID    int    `json:"commission_id"`
+	SessionID       int    `json:"session_id"`
+	CourseID        int    `json:"course_id"`
+	FinalScore      float64 `json:"-"`
+	ExpiresAt       string  `json:"-"`
+	InstructorID    int     `json:"instructor_id"`
+	FeeOverride     *FeeOverride `json:"fee_override,omitempty"`
 }
 
 type Invoice struct {
@@ -112,8 +153,13 @@ type Invoice struct {
     StudentJSHSHIR  string    `json:"student_jshshir"`
     StudentName     string    `json:"student_name"`
     Description     string    `json:"description"`
-    Amount          float64   `json:"amount"`
+    Amount          Money     `json:"amount"`
+    Currency        string    `json:"currency"`
     Status          string    `json:"status"`
+    StatusCode      string    `json:"status_code"`
+    PaidAmount      Money     `json:"paid_amount"`
+    Balance         Money     `json:"balance"`
+    Overdue         bool      `json:"overdue"`
     InvoiceNumber   string    `json:"invoice_number"`
     CreatedAt       time.Time `json:"created_at"`
     IssueDate       string    `json:"issue_date,omitempty"`
@@ -163,6 +209,10 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 
 
 func convertDocumentToOutput(doc Document) DocumentOutput {
+	categories := getStringValue(doc.Categories)
+	if len(doc.CategoryCodes) > 0 {
+		categories = strings.Join(doc.CategoryCodes, ",")
+	}
 	return DocumentOutput{
 		ID:              doc.ID,
 		Title:           getStringValue(doc.Title),
@@ -171,7 +221,7 @@ func convertDocumentToOutput(doc Document) DocumentOutput {
 		CourseStart:     getStringValue(doc.CourseStart),
 		CourseEnd:       getStringValue(doc.CourseEnd),
 		ExamDate:        getStringValue(doc.ExamDate),
-		Categories:      getStringValue(doc.Categories),
+		Categories:      categories,
 		CourseHours:     int(getIntValue(doc.CourseHours)),
 		Grade1:          int(getIntValue(doc.Grade1)),
 		Grade2:          int(getIntValue(doc.Grade2)),
@@ -180,6 +230,18 @@ func convertDocumentToOutput(doc Document) DocumentOutput {
 		CommissionNo:    getStringValue(doc.CommissionNo),
 		DirectorName:    getStringValue(doc.DirectorName),
 		CreatedAt:       getStringValue(doc.CreatedAt),
+		CommissionID:    int(getIntValue(doc.CommissionID)),
+		SessionID:       int(getIntValue(doc.SessionID)),
+		CourseID:        int(getIntValue(doc.CourseID)),
+		FinalScore:      doc.FinalScore.Float64,
+		ExamResult:      getStringValue(doc.ExamResult),
+		CategoryCodes:   doc.CategoryCodes,
+		ExpiresAt:       getStringValue(doc.ExpiresAt),
+		Expired:         doc.Expired,
+		InstructorID:    int(getIntValue(doc.InstructorID)),
+		Revoked:         doc.RevokedAt.Valid,
+		RevokedAt:       getStringValue(doc.RevokedAt),
+		RevokeReason:    getStringValue(doc.RevokeReason),
 	}
 }
 
@@ -197,6 +259,25 @@ func getIntValue(ni sql.NullInt64) int64 {
 	return 0
 }
 
+func pathID(r *http.Request, key string) (int, error) {
+	return strconv.Atoi(mux.Vars(r)[key])
+}
+
+// Для необязательных внешних ключей: 0 пишется в базу как NULL.
+func nullIfZero(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func getNextCertificateNumber() (string, error) {
 	// Ищем максимальный номер сертификата как число
 	query := `
@@ -245,6 +326,13 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 	db.QueryRow(`SELECT COUNT(*) FROM students`).Scan(&d.Students)
 	db.QueryRow(`SELECT COUNT(*) FROM documents`).Scan(&d.Documents)
 
+	now := time.Now()
+	if f, err := loadFinanceSummary(now.Format("2006-01")+"-01", now.Format("2006-01-02")); err == nil {
+		d.Finance = &f
+	} else {
+		log.Printf("Dashboard moliya xatosi: %v", err)
+	}
+
 	respondJSON(w, d)
 }
 
@@ -283,6 +371,9 @@ func studentGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Student not found", 404)
 		return
 	}
+	if age, ok := studentAge(s.BirthDate, currentDate()); ok {
+		s.Age = &age
+	}
 
 	respondJSON(w, s)
 }
@@ -297,7 +388,14 @@ func studentCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec(`
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
 		INSERT INTO students (jshshir, full_name, birth_date, phone)
 		VALUES ($1,$2,$3,$4)
 	`, s.JSHSHIR, s.FullName, s.BirthDate, s.Phone)
@@ -307,6 +405,15 @@ func studentCreate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	if err := emitWebhook(tx, webhookStudentCreated, 0, s.JSHSHIR); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	jobs.notify()
 
 	w.WriteHeader(http.StatusCreated)
 	respondJSON(w, map[string]string{"status": "created"})
@@ -336,7 +443,43 @@ func studentUpdate(w http.ResponseWriter, r *http.Request) {
 func studentDelete(w http.ResponseWriter, r *http.Request) {
 	jshshir := mux.Vars(r)["jshshir"]
 
-	db.Exec(`DELETE FROM students WHERE jshshir=$1`, jshshir)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	// Всё, что привязано к JShShIR, удаляется вместе со студентом, иначе
+	// это унаследует новый студент с тем же JShShIR: вложения, справки,
+	// группы, приглашения и результаты сессий, попытки сдачи (лимит
+	// пересдач), а чат Telegram отвязывается и перестаёт получать уведомления.
+	keys, err := deleteOwnerAttachments(tx, attachmentOwnerStudent, jshshir)
+	for _, q := range []string{
+		`DELETE FROM student_prerequisites WHERE student_jshshir=$1`,
+		`DELETE FROM group_students WHERE student_jshshir=$1`,
+		`DELETE FROM session_students WHERE student_jshshir=$1`,
+		`DELETE FROM exam_results WHERE student_jshshir=$1`,
+		`DELETE FROM exam_attempts WHERE student_jshshir=$1`,
+		`UPDATE telegram_chats SET student_jshshir=NULL, linked_at=NULL WHERE student_jshshir=$1`,
+		`UPDATE telegram_chats SET pending_jshshir=NULL WHERE pending_jshshir=$1`,
+		`DELETE FROM students WHERE jshshir=$1`,
+	} {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(q, jshshir)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Talabani o'chirish xatosi: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	removeStoredFiles(r.Context(), keys)
+
 	respondJSON(w, map[string]string{"status": "deleted"})
 }
 
@@ -350,7 +493,10 @@ func documentsList(w http.ResponseWriter, r *http.Request) {
 		course_start, course_end, exam_date,
 		categories, course_hours,
 		grade1, grade2, certificate_number, status,
-		commission_number, director_name, created_at
+		commission_number, director_name, created_at,
+		commission_id, session_id, course_id, final_score, exam_result,
+		category_codes, to_char(expires_at, 'YYYY-MM-DD'), expired,
+		instructor_id, to_char(revoked_at, 'YYYY-MM-DD HH24:MI:SS'), revoke_reason
 		FROM documents
 		ORDER BY created_at DESC
 	`)
@@ -370,6 +516,9 @@ func documentsList(w http.ResponseWriter, r *http.Request) {
 			&d.Grade1, &d.Grade2,
 			&d.CertificateNo, &d.Status,
 			&d.CommissionNo, &d.DirectorName, &d.CreatedAt,
+			&d.CommissionID, &d.SessionID, &d.CourseID, &d.FinalScore, &d.ExamResult,
+			&d.CategoryCodes, &d.ExpiresAt, &d.Expired,
+			&d.InstructorID, &d.RevokedAt, &d.RevokeReason,
 		)
 		if err != nil {
 			log.Printf("Error scanning document: %v", err)
@@ -381,23 +530,19 @@ func documentsList(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, docs)
 }
 
-func documentGet(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr := vars["id"]
-	
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, "Invalid document ID", 400)
-		return
-	}
-	
+var errDocumentNotFound = errors.New("Document not found")
+
+func loadDocument(id int) (DocumentOutput, error) {
 	var d Document
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 		SELECT id, title, student_jshshir, student_name,
 		course_start, course_end, exam_date,
 		categories, course_hours,
 		grade1, grade2, certificate_number, status,
-		commission_number, director_name, created_at
+		commission_number, director_name, created_at,
+		commission_id, session_id, course_id, final_score, exam_result,
+		category_codes, to_char(expires_at, 'YYYY-MM-DD'), expired,
+		instructor_id, to_char(revoked_at, 'YYYY-MM-DD HH24:MI:SS'), revoke_reason
 		FROM documents WHERE id=$1`, id,
 	).Scan(
 		&d.ID, &d.Title, &d.StudentJSHSHIR, &d.StudentName,
@@ -406,18 +551,39 @@ func documentGet(w http.ResponseWriter, r *http.Request) {
 		&d.Grade1, &d.Grade2,
 		&d.CertificateNo, &d.Status,
 		&d.CommissionNo, &d.DirectorName, &d.CreatedAt,
+		&d.CommissionID, &d.SessionID, &d.CourseID, &d.FinalScore, &d.ExamResult,
+		&d.CategoryCodes, &d.ExpiresAt, &d.Expired,
+		&d.InstructorID, &d.RevokedAt, &d.RevokeReason,
 	)
+	if err == sql.ErrNoRows {
+		return DocumentOutput{}, errDocumentNotFound
+	} else if err != nil {
+		return DocumentOutput{}, err
+	}
+	return convertDocumentToOutput(d), nil
+}
 
+func documentGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Document not found", 404)
+		http.Error(w, "Invalid document ID", 400)
+		return
+	}
+
+	doc, err := loadDocument(id)
+	if err != nil {
+		if err == errDocumentNotFound {
+			http.Error(w, err.Error(), 404)
 		} else {
 			http.Error(w, err.Error(), 500)
 		}
 		return
 	}
 
-	respondJSON(w, convertDocumentToOutput(d))
+	respondJSON(w, doc)
 }
 
 func documentDetails(w http.ResponseWriter, r *http.Request) {
@@ -439,9 +605,15 @@ func documentDetails(w http.ResponseWriter, r *http.Request) {
 			d.categories, d.course_hours,
 			d.grade1, d.grade2, d.certificate_number, 
 			d.status, d.commission_number, d.director_name, d.created_at,
+			COALESCE(d.commission_id, 0), COALESCE(d.session_id, 0),
+			COALESCE(d.course_id, 0), COALESCE(d.final_score, 0), COALESCE(d.exam_result, ''),
+			COALESCE(d.category_codes, '{}'),
+			COALESCE(to_char(d.expires_at, 'YYYY-MM-DD'), ''), d.expired,
+			COALESCE(d.instructor_id, 0), COALESCE(i.full_name, ''),
 			s.birth_date, s.phone
 		FROM documents d
 		LEFT JOIN students s ON d.student_jshshir = s.jshshir
+		LEFT JOIN instructors i ON d.instructor_id = i.id
 		WHERE d.id = $1
 	`, id).Scan(
 		&detail.ID, &detail.Title, &detail.StudentJSHSHIR, &detail.StudentName,
@@ -449,6 +621,11 @@ func documentDetails(w http.ResponseWriter, r *http.Request) {
 		&detail.Categories, &detail.CourseHours,
 		&detail.Grade1, &detail.Grade2, &detail.CertificateNo,
 		&detail.Status, &detail.CommissionNo, &detail.DirectorName, &detail.CreatedAt,
+		&detail.CommissionID, &detail.SessionID,
+		&detail.CourseID, &detail.FinalScore, &detail.ExamResult,
+		pq.Array(&detail.CategoryCodes),
+		&detail.ExpiresAt, &detail.Expired,
+		&detail.InstructorID, &detail.InstructorName,
 		&detail.StudentBirthDate, &detail.StudentPhone,
 	)
 
@@ -456,6 +633,26 @@ func documentDetails(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Document not found", 404)
 		return
 	}
+	if len(detail.CategoryCodes) > 0 {
+		detail.Categories = strings.Join(detail.CategoryCodes, ",")
+	}
+
+	// Состав комиссии для печати сертификата
+	if detail.CommissionID != 0 {
+		c, err := loadCommission(detail.CommissionID)
+		if err != nil {
+			log.Printf("Komissiya %d yuklanmadi: %v", detail.CommissionID, err)
+		} else {
+			detail.Commission = &c
+		}
+	}
+
+	// Фото для печатной формы — то же, что и в PDF
+	if photoID, _, err := certificatePhoto(detail.ID, detail.StudentJSHSHIR); err != nil {
+		log.Printf("Guvohnoma %d surati: %v", detail.ID, err)
+	} else {
+		detail.PhotoID = photoID
+	}
 
 	// ===== QR: ТОЛЬКО ССЫЛКА =====
 	// qrURL := fmt.Sprintf(
@@ -505,10 +702,41 @@ func documentCreate(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Qabul qilingan guvohnoma: %+v", input)
 
-	// Установить значение по умолчанию для commission_number
-	if input.CommissionNo == "" || strings.TrimSpace(input.CommissionNo) == "" {
-		input.CommissionNo = "15"
-		log.Printf("CommissionNo bo'sh, 15 ga o'rnatildi")
+	// Комиссия, директор, дата экзамена и оценки — из записей комиссии и сессии
+	if code, err := resolveExamRecords(&input); err != nil {
+		log.Printf("Komissiya ma'lumotlari xatosi: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	// Итог экзамена по правилам курса: не сдавшим сертификат не выдаём
+	if code, err := applyGradingRules(&input, nil); err != nil {
+		log.Printf("Baholash qoidalari: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if err := validateCategories(input.Categories, input.CourseHours); err != nil {
+		log.Printf("Toifalar xatosi: %v", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := computeExpiresAt(&input); err == errBadDate {
+		http.Error(w, err.Error(), 400)
+		return
+	} else if err != nil {
+		log.Printf("Amal qilish muddatini hisoblash xatosi: %v", err)
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+
+	if err := checkDocumentInstructor(input.InstructorID); err == errInstructorNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
 	}
 
 	// Проверяем, существует ли студент
@@ -529,6 +757,25 @@ func documentCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Допуск по категориям: возраст и медицинская справка на дату выдачи
+	if code, err := checkPrerequisites(strings.TrimSpace(input.StudentJSHSHIR), input.Categories, currentDate()); err != nil {
+		log.Printf("Toifa talablari: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	// Долг за обучение: по политике FEE_POLICY отказ или разрешение директора
+	feeDebt, code, err := checkFeePolicy(&input)
+	if err != nil {
+		log.Printf("To'lov siyosati: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	var overrideReason, overrideBy interface{}
+	if input.FeeOverride != nil {
+		overrideReason, overrideBy = input.FeeOverride.Reason, input.FeeOverride.ApprovedBy
+	}
+
 	// Генерация номера сертификата (просто номер)
 	if input.CertificateNo == "" || strings.TrimSpace(input.CertificateNo) == "" {
 	certNumber, err := getNextCertificateNumber()
@@ -547,30 +794,67 @@ func documentCreate(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Generatsiya qilingan guvohnoma raqami: %s", certNumber)
 }
 
-	// Вставка в базу данных
-	_, err = db.Exec(`
+	// Вставка в базу данных вместе с уведомлением и webhook — в одной транзакции
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	var docID int
+	err = tx.QueryRow(`
 		INSERT INTO documents 
 		(title, student_jshshir, student_name, course_start, course_end, 
 		 exam_date, categories, course_hours, grade1, grade2, 
-		 certificate_number, status, commission_number, director_name, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW())`,
+		 certificate_number, status, commission_number, director_name, created_at,
+		 commission_id, session_id, course_id, final_score, exam_result, category_codes,
+		 expires_at, expired, instructor_id,
+		 fee_outstanding, fee_override_reason, fee_override_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW(), $15, $16, $17, $18, $19, $20,
+		 NULLIF($21, '')::date, COALESCE(NULLIF($21, '')::date < CURRENT_DATE, FALSE), $22,
+		 $23, $24, $25)
+		RETURNING id`,
 		input.Title, input.StudentJSHSHIR, input.StudentName, input.CourseStart,
-		input.CourseEnd, input.ExamDate, input.Categories, input.CourseHours,
+		input.CourseEnd, input.ExamDate, input.Categories.String(), input.CourseHours,
 		input.Grade1, input.Grade2, input.CertificateNo, input.Status,
 		input.CommissionNo, input.DirectorName,
-	)
+		nullIfZero(input.CommissionID), nullIfZero(input.SessionID),
+		nullIfZero(input.CourseID), input.FinalScore, examPassed,
+		pq.Array([]string(input.Categories)), input.ExpiresAt,
+		nullIfZero(input.InstructorID),
+		feeDebt, overrideReason, overrideBy,
+	).Scan(&docID)
 
 	if err != nil {
 		log.Printf("Guvohnoma yaratish xatosi: %v", err)
 		http.Error(w, "Guvohnoma yaratishda xatolik: "+err.Error(), 500)
 		return
 	}
+	if _, err := enqueueJob(tx, jobNotifyCertificate, certificateNotifyJob{input.StudentJSHSHIR, input.CertificateNo}); err != nil {
+		log.Printf("Guvohnoma xabarnomasini navbatga qo'yish xatosi: %v", err)
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	if err := emitWebhook(tx, webhookDocumentCreated, docID, ""); err != nil {
+		log.Printf("Webhook hodisasini navbatga qo'yish xatosi: %v", err)
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	jobs.notify()
 
 	respondJSON(w, map[string]interface{}{
 		"status":            "success",
 		"message":           "Guvohnoma muvaffaqiyatli yaratildi",
+		"id":                docID,
 		"certificate_number": input.CertificateNo,
 		"commission_number":  input.CommissionNo,
+		"commission_id":      input.CommissionID,
+		"fee_outstanding":    feeDebt,
 	})
 }
 
@@ -588,13 +872,19 @@ func verifyHandler(w http.ResponseWriter, r *http.Request) {
     err := db.QueryRow(`
         SELECT id, certificate_number, student_name, student_jshshir,
                course_start, course_end, exam_date, categories,
-               course_hours, grade1, grade2, status, director_name
+               course_hours, grade1, grade2, status, director_name,
+               COALESCE(to_char(expires_at, 'YYYY-MM-DD'), ''),
+               COALESCE(expires_at < CURRENT_DATE, FALSE),
+               revoked_at IS NOT NULL, COALESCE(to_char(revoked_at, 'YYYY-MM-DD HH24:MI:SS'), ''),
+               COALESCE(revoke_reason, '')
         FROM documents 
         WHERE certificate_number=$1 OR id::text=$1
     `, cert).Scan(
         &doc.ID, &doc.CertificateNo, &doc.StudentName, &doc.StudentJSHSHIR,
         &doc.CourseStart, &doc.CourseEnd, &doc.ExamDate, &doc.Categories,
         &doc.CourseHours, &doc.Grade1, &doc.Grade2, &doc.Status, &doc.DirectorName,
+        &doc.ExpiresAt, &doc.Expired,
+        &doc.Revoked, &doc.RevokedAt, &doc.RevokeReason,
     )
 
     if err != nil {
@@ -640,6 +930,33 @@ func documentUpdate(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Yangilanayotgan guvohnoma ID %d ma'lumotlari: %+v", id, input)
 
+	// Отозванное свидетельство не редактируется
+	if revoked, err := documentRevoked(id); err == errDocumentNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	} else if revoked {
+		http.Error(w, errDocumentRevoked.Error(), 409)
+		return
+	}
+
+	// Сохранённые значения: проверки касаются только изменённых полей
+	var prev DocumentInput
+	var prevCategories pq.StringArray
+	err = db.QueryRow(`
+		SELECT COALESCE(student_jshshir, ''), COALESCE(category_codes, '{}'), COALESCE(status, ''),
+			COALESCE(grade1, 0), COALESCE(grade2, 0), COALESCE(final_score, 0), COALESCE(course_id, 0)
+		FROM documents WHERE id=$1`, id,
+	).Scan(&prev.StudentJSHSHIR, &prevCategories, &prev.Status,
+		&prev.Grade1, &prev.Grade2, &prev.FinalScore, &prev.CourseID)
+	if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	prev.Categories = CategoryList(prevCategories)
+
 	if input.StudentJSHSHIR != "" && len(strings.TrimSpace(input.StudentJSHSHIR)) > 0 {
 		var exists bool
 		err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, 
@@ -657,19 +974,96 @@ func documentUpdate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if code, err := resolveExamRecords(&input); err != nil {
+		log.Printf("Komissiya ma'lumotlari xatosi: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if code, err := applyGradingRules(&input, &prev); err != nil {
+		log.Printf("Baholash qoidalari: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if err := validateCategories(input.Categories, input.CourseHours); err != nil {
+		log.Printf("Toifalar xatosi: %v", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := computeExpiresAt(&input); err == errBadDate {
+		http.Error(w, err.Error(), 400)
+		return
+	} else if err != nil {
+		log.Printf("Amal qilish muddatini hisoblash xatosi: %v", err)
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+
+	if err := checkDocumentInstructor(input.InstructorID); err == errInstructorNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+
+	studentChanged := strings.TrimSpace(input.StudentJSHSHIR) != strings.TrimSpace(prev.StudentJSHSHIR)
+	categoriesChanged := input.Categories.String() != prev.Categories.String()
+
+	// Допуск перепроверяется, если сменились студент или категории;
+	// прочие правки старого документа не упираются в истёкшую справку.
+	if studentChanged || categoriesChanged {
+		if code, err := checkPrerequisites(strings.TrimSpace(input.StudentJSHSHIR), input.Categories, currentDate()); err != nil {
+			log.Printf("Toifa talablari: %v", err)
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+
+	// Студент назначен или сменён — долг проверяется так же, как при выдаче
+	var feeDebt Money
+	var overrideReason, overrideBy interface{}
+	if studentChanged {
+		debt, code, err := checkFeePolicy(&input)
+		if err != nil {
+			log.Printf("To'lov siyosati: %v", err)
+			http.Error(w, err.Error(), code)
+			return
+		}
+		feeDebt = debt
+		if input.FeeOverride != nil {
+			overrideReason, overrideBy = input.FeeOverride.Reason, input.FeeOverride.ApprovedBy
+		}
+	}
+
 	result, err := db.Exec(`
 		UPDATE documents 
 		SET title=$1, student_jshshir=$2, student_name=$3, 
 			course_start=$4, course_end=$5, exam_date=$6,
 			categories=$7, course_hours=$8, grade1=$9, grade2=$10,
 			certificate_number=$11, status=$12, 
-			commission_number=$13, director_name=$14
-		WHERE id=$15`,
+			commission_number=$13, director_name=$14,
+			commission_id=$15, session_id=$16,
+			course_id=$17, final_score=$18, exam_result=$19,
+			category_codes=$20, expires_at=NULLIF($21, '')::date,
+			expired=COALESCE(NULLIF($21, '')::date < CURRENT_DATE, FALSE),
+			instructor_id=$22,
+			fee_outstanding=CASE WHEN $24 THEN $25 ELSE fee_outstanding END,
+			fee_override_reason=CASE WHEN $24 THEN $26 ELSE fee_override_reason END,
+			fee_override_by=CASE WHEN $24 THEN $27 ELSE fee_override_by END
+		WHERE id=$23`,
 		input.Title, input.StudentJSHSHIR, input.StudentName,
 		input.CourseStart, input.CourseEnd, input.ExamDate,
-		input.Categories, input.CourseHours, input.Grade1, input.Grade2,
+		input.Categories.String(), input.CourseHours, input.Grade1, input.Grade2,
 		input.CertificateNo, input.Status,
-		input.CommissionNo, input.DirectorName, id,
+		input.CommissionNo, input.DirectorName,
+		nullIfZero(input.CommissionID), nullIfZero(input.SessionID),
+		nullIfZero(input.CourseID), input.FinalScore, examPassed,
+		pq.Array([]string(input.Categories)), input.ExpiresAt,
+		nullIfZero(input.InstructorID), id,
+		studentChanged, feeDebt, overrideReason, overrideBy,
 	)
 
 	if err != nil {
@@ -704,7 +1098,20 @@ func documentDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := db.Exec(`DELETE FROM documents WHERE id=$1`, id)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	keys, err := deleteOwnerAttachments(tx, attachmentOwnerDocument, strconv.Itoa(id))
+	if err != nil {
+		log.Printf("Guvohnoma fayllarini o'chirish xatosi: %v", err)
+		http.Error(w, "Guvohnoma o'chirishda xatolik: "+err.Error(), 500)
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM documents WHERE id=$1`, id)
 	if err != nil {
 		log.Printf("Guvohnoma o'chirish xatosi: %v", err)
 		http.Error(w, "Guvohnoma o'chirishda xatolik: "+err.Error(), 500)
@@ -716,6 +1123,11 @@ func documentDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Guvohnoma topilmadi", 404)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Baza xatosi", 500)
+		return
+	}
+	removeStoredFiles(r.Context(), keys)
 
 	log.Printf("Guvohnoma ID %d muvaffaqiyatli o'chirildi", id)
 
@@ -745,7 +1157,9 @@ func invoicesList(w http.ResponseWriter, r *http.Request) {
                COALESCE(s.full_name, 'Noma''lum talaba') as student_name,
                i.description, i.amount, i.status, 
                COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')) as invoice_number,
-               i.created_at, i.issue_date, i.due_date, i.payment_date
+               i.created_at, i.issue_date, i.due_date, i.payment_date,
+               COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+               i.overdue, i.currency
         FROM invoices i
         LEFT JOIN students s ON i.student_jshshir = s.jshshir
         ORDER BY i.created_at DESC
@@ -774,11 +1188,16 @@ func invoicesList(w http.ResponseWriter, r *http.Request) {
             &issueDate,
             &dueDate,
             &paymentDate,
+            &i.PaidAmount,
+            &i.Overdue,
+            &i.Currency,
         )
         if err != nil {
             log.Printf("Error scanning invoice: %v", err)
             continue
         }
+        i.StatusCode = invoiceStatusCode(i.Status)
+        i.Balance = i.Amount - i.PaidAmount
         invoices = append(invoices, i)
     }
 
@@ -795,7 +1214,12 @@ func invoiceCreate(w http.ResponseWriter, r *http.Request) {
     var input struct {
         StudentJSHSHIR string  `json:"student_jshshir"`
         Description    string  `json:"description"`
-        Amount         float64 `json:"amount"`
+        Amount         Money   `json:"amount"`
+        Currency       string  `json:"currency"`
+        InstallmentCount int           `json:"installment_count"`
+        Installments     []Installment `json:"installments"`
+        Items            []InvoiceItem `json:"items"`
+        Discount         *Discount     `json:"discount"`
     }
 
     // Логируем полученные данные
@@ -811,18 +1235,44 @@ func invoiceCreate(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    log.Printf("Parsed data: JShShIR=%s, Description=%s, Amount=%f", 
+    // Счёт только по прейскуранту: сумма считается на сервере,
+    // присланная из браузера "amount" не принимается
+    if len(input.Items) == 0 {
+        http.Error(w, "Invoyis summasi prayskurant bo'yicha hisoblanadi: xizmatlarni (items) ko'rsating", 400)
+        return
+    }
+    totals, err := priceInvoice(input.Items, input.Discount)
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    input.Amount = totals.Total
+    if strings.TrimSpace(input.Description) == "" {
+        names := make([]string, len(totals.Items))
+        for i, item := range totals.Items {
+            names[i] = item.Name
+        }
+        input.Description = strings.Join(names, ", ")
+    }
+
+    log.Printf("Parsed data: JShShIR=%s, Description=%s, Amount=%s", 
         input.StudentJSHSHIR, input.Description, input.Amount)
 
     if input.StudentJSHSHIR == "" || input.Amount <= 0 {
-        log.Printf("Missing fields: JShShIR='%s', Amount=%f", input.StudentJSHSHIR, input.Amount)
+        log.Printf("Missing fields: JShShIR='%s', Amount=%s", input.StudentJSHSHIR, input.Amount)
         http.Error(w, "Missing required fields", 400)
         return
     }
+    if input.Currency = strings.ToUpper(strings.TrimSpace(input.Currency)); input.Currency == "" {
+        input.Currency = defaultCurrency
+    } else if input.Currency != defaultCurrency {
+        http.Error(w, "Faqat "+defaultCurrency+" valyutasi qo'llab-quvvatlanadi", 400)
+        return
+    }
 
     // Получаем имя студента из базы
     var studentName string
-    err := db.QueryRow(`SELECT full_name FROM students WHERE jshshir=$1`, 
+    err = db.QueryRow(`SELECT full_name FROM students WHERE jshshir=$1`, 
         strings.TrimSpace(input.StudentJSHSHIR)).Scan(&studentName)
     
     if err != nil {
@@ -838,25 +1288,62 @@ func invoiceCreate(w http.ResponseWriter, r *http.Request) {
     log.Printf("Found student: %s", studentName)
 
     // Устанавливаем даты
-    issueDate := time.Now().Format("2006-01-02")
-    dueDate := time.Now().AddDate(0, 0, 30).Format("2006-01-02") // +30 дней
-    
+    now := time.Now()
+    issueDate := now.Format("2006-01-02")
+    dueDate := now.AddDate(0, 0, 30).Format("2006-01-02") // +30 дней
+
+    // График оплаты частями: срок счёта — срок последней части
+    installments, err := buildInstallments(input.Amount, input.InstallmentCount, input.Installments, now)
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    if len(installments) > 0 {
+        dueDate = installments[len(installments)-1].DueDate
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    defer tx.Rollback()
+
+    // Номер выделяется в той же транзакции, что и сам счёт
+    invoiceNumber, err := nextInvoiceNumber(tx, now)
+    if err != nil {
+        log.Printf("Error allocating invoice number: %v", err)
+        http.Error(w, "Bazada xatolik: "+err.Error(), 500)
+        return
+    }
+
+    dType, dValue, dReason := discountColumns(totals.Discount)
+
     var id int
-    err = db.QueryRow(`
+    err = tx.QueryRow(`
         INSERT INTO invoices (
             student_jshshir, student_name, description, amount, status,
-            issue_date, due_date, created_at
+            issue_date, due_date, created_at,
+            subtotal, discount_type, discount_value, discount_reason, discount_amount, currency,
+            invoice_number
         )
-        VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $9, $10, $11, $12, $13, $14)
         RETURNING id
     `,
         strings.TrimSpace(input.StudentJSHSHIR),
         studentName,
         input.Description,
         input.Amount,
-        "To'lov kutilmoqda", // default status
+        invoiceStatusPending,
         issueDate,
         dueDate,
+        totals.Subtotal,
+        dType,
+        dValue,
+        dReason,
+        totals.DiscountAmount,
+        input.Currency,
+        invoiceNumber,
     ).Scan(&id)
 
     if err != nil {
@@ -865,19 +1352,30 @@ func invoiceCreate(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Генерируем номер инвойса
-    invoiceNumber := fmt.Sprintf("INV-%06d", id)
-    
-    _, err = db.Exec(
-        `UPDATE invoices SET invoice_number=$1 WHERE id=$2`,
-        invoiceNumber, id,
-    )
+    if err := insertInvoiceItems(tx, id, totals.Items); err != nil {
+        log.Printf("Error saving invoice items: %v", err)
+        http.Error(w, "Bazada xatolik: "+err.Error(), 500)
+        return
+    }
 
-    if err != nil {
-        log.Printf("Error updating invoice number: %v", err)
-        // Не прерываем выполнение, так как инвойс уже создан
+    if err := insertInstallments(tx, id, installments); err != nil {
+        log.Printf("Error saving installments: %v", err)
+        http.Error(w, "Bazada xatolik: "+err.Error(), 500)
+        return
     }
 
+    // Уведомление уходит только если счёт действительно создан
+    if _, err := enqueueJob(tx, jobNotifyInvoice, invoiceNotifyJob{InvoiceID: id}); err != nil {
+        http.Error(w, "Bazada xatolik: "+err.Error(), 500)
+        return
+    }
+
+    if err := tx.Commit(); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    jobs.notify()
+
     log.Printf("Invoice created successfully: ID=%d, Number=%s", id, invoiceNumber)
 
     respondJSON(w, map[string]interface{}{
@@ -885,36 +1383,63 @@ func invoiceCreate(w http.ResponseWriter, r *http.Request) {
         "id":            id,
         "invoice_number": invoiceNumber,
         "student_name":   studentName,
+        "installments":   len(installments),
+        "amount":         input.Amount,
+        "currency":       input.Currency,
         "message":        "Invoyis muvaffaqiyatli yaratildi",
     })
 }
 
 func invoiceDelete(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    id := vars["id"]
+    invoiceID, err := pathID(r, "id")
+    if err != nil {
+        http.Error(w, "Invalid invoice ID", 400)
+        return
+    }
 
-    // Проверяем существование инвойса
-    var exists bool
-    err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM invoices WHERE id=$1)`, id).Scan(&exists)
+    tx, err := db.Begin()
     if err != nil {
         http.Error(w, err.Error(), 500)
         return
     }
-    
-    if !exists {
+    defer tx.Rollback()
+
+    // Платежи, возвраты и операции платёжных систем — это учёт денег:
+    // такой счёт не удаляется, его можно только отменить
+    var number string
+    var hasMoney bool
+    err = tx.QueryRow(`
+        SELECT COALESCE(i.invoice_number, ''),
+            EXISTS(SELECT 1 FROM payments WHERE invoice_id = i.id)
+            OR EXISTS(SELECT 1 FROM credit_notes WHERE invoice_id = i.id)
+            OR EXISTS(SELECT 1 FROM merchant_transactions WHERE invoice_id = i.id)
+        FROM invoices i WHERE i.id=$1
+        FOR UPDATE OF i`, invoiceID,
+    ).Scan(&number, &hasMoney)
+    if err == sql.ErrNoRows {
         http.Error(w, "Invoyis topilmadi", 404)
         return
+    } else if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    // Нумерация без пропусков: счёт с номером остаётся в реестре навсегда
+    if number != "" {
+        http.Error(w, "Raqamlangan invoyisni ("+number+") o'chirib bo'lmaydi, uni bekor qiling", 409)
+        return
+    }
+    if hasMoney {
+        http.Error(w, "Invoyis bo'yicha to'lovlar yoki qaytarishlar bor, uni o'chirib bo'lmaydi. "+
+            "To'lovlarni /refund orqali qaytaring va invoyisni bekor qiling", 409)
+        return
     }
 
-    res, err := db.Exec(`DELETE FROM invoices WHERE id=$1`, id)
-    if err != nil {
+    if _, err := tx.Exec(`DELETE FROM invoices WHERE id=$1`, invoiceID); err != nil {
         http.Error(w, err.Error(), 500)
         return
     }
-
-    count, _ := res.RowsAffected()
-    if count == 0 {
-        http.Error(w, "Invoyis topilmadi", 404)
+    if err := tx.Commit(); err != nil {
+        http.Error(w, err.Error(), 500)
         return
     }
 
@@ -935,7 +1460,9 @@ func invoicesSearch(w http.ResponseWriter, r *http.Request) {
                COALESCE(s.full_name, 'Noma''lum talaba') as student_name,
                i.description, i.amount, i.status, 
                COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')) as invoice_number,
-               i.created_at
+               i.created_at,
+               COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+               i.overdue, i.currency
         FROM invoices i
         LEFT JOIN students s ON i.student_jshshir = s.jshshir
         WHERE i.student_jshshir ILIKE $1
@@ -964,18 +1491,25 @@ func invoicesSearch(w http.ResponseWriter, r *http.Request) {
             &i.Status,
             &i.InvoiceNumber,
             &i.CreatedAt,
+            &i.PaidAmount,
+            &i.Overdue,
+            &i.Currency,
         )
         if err != nil {
             log.Printf("Error scanning search result: %v", err)
             continue
         }
+        i.StatusCode = invoiceStatusCode(i.Status)
+        i.Balance = i.Amount - i.PaidAmount
         invoices = append(invoices, i)
     }
 
     respondJSON(w, invoices)
 }
 
-// Функция для обновления статуса инвойса
+// Функция для обновления статуса инвойса.
+// Статус оплаты выводится из платежей: "To'landi" записывает платёж
+// на весь остаток, "To'lov kutilmoqda" снимает отмену и пересчитывает статус.
 func invoiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
@@ -988,7 +1522,9 @@ func invoiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
     }
     
     var input struct {
-        Status string `json:"status"`
+        Status     string `json:"status"`
+        Method     string `json:"method"`
+        ReceivedBy string `json:"received_by"`
     }
     
     if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -997,7 +1533,7 @@ func invoiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
     }
     
     // Проверяем допустимые статусы
-    validStatuses := []string{"To'lov kutilmoqda", "To'landi", "Bekor qilindi"}
+    validStatuses := []string{invoiceStatusPending, invoiceStatusPaid, invoiceStatusCancelled}
     isValid := false
     for _, status := range validStatuses {
         if input.Status == status {
@@ -1010,37 +1546,90 @@ func invoiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Invalid status", 400)
         return
     }
-    
-    // Обновляем статус и дату оплаты если статус "To'landi"
-    var paymentDate interface{}
-    if input.Status == "To'landi" {
-        paymentDate = time.Now().Format("2006-01-02")
-    } else {
-        paymentDate = nil
+
+    if input.Method == "" {
+        input.Method = "cash"
     }
-    
-    result, err := db.Exec(`
-        UPDATE invoices 
-        SET status = $1, payment_date = $2 
-        WHERE id = $3
-    `, input.Status, paymentDate, invoiceID)
-    
+    if !isValidPaymentMethod(input.Method) {
+        http.Error(w, "To'lov usuli noto'g'ri ("+strings.Join(paymentMethods, ", ")+")", 400)
+        return
+    }
+
+    tx, err := db.Begin()
     if err != nil {
-        log.Printf("Error updating invoice status: %v", err)
         http.Error(w, err.Error(), 500)
         return
     }
-    
-    rowsAffected, _ := result.RowsAffected()
-    if rowsAffected == 0 {
+    defer tx.Rollback()
+
+    var amount, paid, credited Money
+    err = tx.QueryRow(`
+        SELECT i.amount,
+            COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+            `+invoiceCreditSQL+`
+        FROM invoices i WHERE i.id=$1
+        FOR UPDATE OF i`, invoiceID,
+    ).Scan(&amount, &paid, &credited)
+    if err == sql.ErrNoRows {
         http.Error(w, "Invoice not found", 404)
         return
+    } else if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    switch input.Status {
+    case invoiceStatusCancelled:
+        // Отменить можно только счёт без невозвращённых денег: иначе
+        // платёж остаётся в кассе, а долг и выписка показывают ноль
+        if paid-credited > 0 {
+            http.Error(w, "Invoyis bo'yicha qaytarilmagan to'lovlar bor ("+(paid-credited).String()+
+                "). Avval to'lovlarni /refund orqali qaytaring", 409)
+            return
+        }
+        _, err = tx.Exec(`UPDATE invoices SET status=$1, payment_date=NULL, overdue=FALSE, cancelled_at=CURRENT_DATE WHERE id=$2`,
+            invoiceStatusCancelled, invoiceID)
+    case invoiceStatusPending:
+        _, err = tx.Exec(`UPDATE invoices SET status=$1, cancelled_at=NULL WHERE id=$2`, invoiceStatusPending, invoiceID)
+        if err == nil {
+            err = recalcInvoice(tx, invoiceID)
+        }
+    case invoiceStatusPaid:
+        // Снимаем отмену, если была, и доплачиваем остаток
+        _, err = tx.Exec(`UPDATE invoices SET status=$1, cancelled_at=NULL WHERE id=$2 AND status=$3`,
+            invoiceStatusPending, invoiceID, invoiceStatusCancelled)
+        if err == nil && amount-paid > 0 {
+            err = addPayment(tx, &Payment{
+                InvoiceID:  invoiceID,
+                Amount:     amount - paid,
+                Method:     input.Method,
+                ReceivedBy: strings.TrimSpace(input.ReceivedBy),
+            })
+        } else if err == nil {
+            err = recalcInvoice(tx, invoiceID)
+        }
+    }
+    if err != nil {
+        log.Printf("Error updating invoice status: %v", err)
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    var status string
+    if err := tx.QueryRow(`SELECT status FROM invoices WHERE id=$1`, invoiceID).Scan(&status); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
     }
     
     respondJSON(w, map[string]interface{}{
         "success": true,
         "message": "Invoyis holati yangilandi",
-        "status": input.Status,
+        "status": status,
+        "status_code": invoiceStatusCode(status),
     })
 }
 
@@ -1061,8 +1650,13 @@ func invoiceGetDetails(w http.ResponseWriter, r *http.Request) {
         StudentJSHSHIR  string         `json:"student_jshshir"`
         StudentName     string         `json:"student_name"`
         Description     string         `json:"description"`
-        Amount          float64        `json:"amount"`
+        Amount          Money          `json:"amount"`
         Status          string         `json:"status"`
+        StatusCode      string         `json:"status_code"`
+        Currency        string         `json:"currency"`
+        PaidAmount      Money          `json:"paid_amount"`
+        Balance         Money          `json:"balance"`
+        Overdue         bool           `json:"overdue"`
         InvoiceNumber   string         `json:"invoice_number"`
         IssueDate       string         `json:"issue_date"`
         DueDate         string         `json:"due_date"`
@@ -1070,6 +1664,13 @@ func invoiceGetDetails(w http.ResponseWriter, r *http.Request) {
         CreatedAt       string         `json:"created_at"`
         StudentBirthDate string        `json:"student_birth_date,omitempty"`
         StudentPhone     string        `json:"student_phone,omitempty"`
+        Payments         []Payment     `json:"payments"`
+        Installments     []Installment `json:"installments"`
+        Items            []InvoiceItem `json:"items"`
+        Discount         *Discount     `json:"discount,omitempty"`
+        DiscountAmount   Money         `json:"discount_amount"`
+        RefundedAmount   Money         `json:"refunded_amount"`
+        CreditNotes      []CreditNote  `json:"credit_notes"`
     }
     
     var issueDate, dueDate, paymentDate, studentBirthDate, studentPhone sql.NullString
@@ -1081,7 +1682,9 @@ func invoiceGetDetails(w http.ResponseWriter, r *http.Request) {
             COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')) as invoice_number,
             i.issue_date, i.due_date, i.payment_date,
             i.created_at,
-            s.birth_date, s.phone
+            s.birth_date, s.phone,
+            COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+            i.overdue, i.currency
         FROM invoices i
         LEFT JOIN students s ON i.student_jshshir = s.jshshir
         WHERE i.id = $1
@@ -1099,6 +1702,9 @@ func invoiceGetDetails(w http.ResponseWriter, r *http.Request) {
         &invoiceDetail.CreatedAt,
         &studentBirthDate,
         &studentPhone,
+        &invoiceDetail.PaidAmount,
+        &invoiceDetail.Overdue,
+        &invoiceDetail.Currency,
     )
     
     if err != nil {
@@ -1127,6 +1733,46 @@ func invoiceGetDetails(w http.ResponseWriter, r *http.Request) {
     if studentPhone.Valid {
         invoiceDetail.StudentPhone = studentPhone.String
     }
+    invoiceDetail.StatusCode = invoiceStatusCode(invoiceDetail.Status)
+    invoiceDetail.Balance = invoiceDetail.Amount - invoiceDetail.PaidAmount
+
+    // История платежей
+    invoiceDetail.Payments, err = loadInvoicePayments(invoiceID)
+    if err != nil {
+        log.Printf("Error getting invoice payments: %v", err)
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    // Строки счёта и скидка
+    invoiceDetail.Items, err = loadInvoiceItems(invoiceID)
+    if err == nil {
+        invoiceDetail.Discount, invoiceDetail.DiscountAmount, err = loadInvoiceDiscount(invoiceID)
+    }
+    if err != nil {
+        log.Printf("Error getting invoice items: %v", err)
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    // Возвраты по счёту
+    invoiceDetail.CreditNotes, err = queryCreditNotes(`cn.invoice_id = $1`, invoiceID)
+    if err != nil {
+        log.Printf("Error getting invoice credit notes: %v", err)
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    for _, cn := range invoiceDetail.CreditNotes {
+        invoiceDetail.RefundedAmount += cn.Amount
+    }
+
+    // График оплаты со статусами частей
+    invoiceDetail.Installments, err = loadInstallments(invoiceID)
+    if err != nil {
+        log.Printf("Error getting invoice installments: %v", err)
+        http.Error(w, err.Error(), 500)
+        return
+    }
     
     respondJSON(w, invoiceDetail)
 }
@@ -1167,6 +1813,22 @@ if err != nil {
 
   log.Println("✅ Baza ulandi")
 
+  if err := migrate(); err != nil {
+    log.Fatal("MIGRATSIYA XATOSI:", err)
+  }
+
+  if err := initStorage(); err != nil {
+    log.Fatal("FAYL OMBORI XATOSI:", err)
+  }
+  initNotifications()
+  initTelegramBot()
+
+  registerJobHandlers()
+  startJobRunner()
+  schedulePeriodicJob(jobMarkExpired, expiryCheckInterval)
+  schedulePeriodicJob(jobMarkOverdue, overdueCheckInterval)
+  schedulePeriodicJob(jobPaymentReminders, paymentReminderInterval)
+
   // Создание роутера
   r := mux.NewRouter()
 
@@ -1179,14 +1841,30 @@ if err != nil {
   r.HandleFunc("/api/students/{jshshir}", enableCORS(studentGet)).Methods("GET")
   r.HandleFunc("/api/students/{jshshir}", enableCORS(studentUpdate)).Methods("PUT")
   r.HandleFunc("/api/students/{jshshir}", enableCORS(studentDelete)).Methods("DELETE")
+  r.HandleFunc("/api/students/{jshshir}/balance", enableCORS(studentBalanceHandler)).Methods("GET")
+  r.HandleFunc("/api/students/{jshshir}/statement", enableCORS(studentStatement)).Methods("GET")
+  r.HandleFunc("/api/students/{jshshir}/attachments", enableCORS(attachmentsListHandler(attachmentOwnerStudent, "jshshir"))).Methods("GET")
+  r.HandleFunc("/api/students/{jshshir}/attachments", enableCORS(attachmentUploadHandler(attachmentOwnerStudent, "jshshir"))).Methods("POST")
+  r.HandleFunc("/api/students/{jshshir}/prerequisites", enableCORS(studentPrerequisitesList)).Methods("GET")
+  r.HandleFunc("/api/students/{jshshir}/prerequisites", enableCORS(studentPrerequisiteCreate)).Methods("POST")
+  r.HandleFunc("/api/students/{jshshir}/clearance", enableCORS(studentClearance)).Methods("GET")
+  r.HandleFunc("/api/prerequisites/{id}", enableCORS(studentPrerequisiteDelete)).Methods("DELETE")
   
   // Documents API
   r.HandleFunc("/api/documents", enableCORS(documentsList)).Methods("GET")
   r.HandleFunc("/api/documents", enableCORS(documentCreate)).Methods("POST")
+  r.HandleFunc("/api/documents/expiring", enableCORS(documentsExpiring)).Methods("GET")
+  r.HandleFunc("/api/documents/fee-overrides", enableCORS(feeOverridesList)).Methods("GET")
   r.HandleFunc("/api/documents/{id}", enableCORS(documentGet)).Methods("GET")
   r.HandleFunc("/api/documents/{id}/details", enableCORS(documentDetails)).Methods("GET")
+  r.HandleFunc("/api/documents/{id}/pdf", enableCORS(documentPDF)).Methods("GET")
+  r.HandleFunc("/api/documents/{id}/email", enableCORS(emailSendHandler(emailKindCertificate))).Methods("POST")
+  r.HandleFunc("/api/documents/{id}/revoke", enableCORS(documentRevoke)).Methods("POST")
+  r.HandleFunc("/api/documents/{id}/attachments", enableCORS(attachmentsListHandler(attachmentOwnerDocument, "id"))).Methods("GET")
+  r.HandleFunc("/api/documents/{id}/attachments", enableCORS(attachmentUploadHandler(attachmentOwnerDocument, "id"))).Methods("POST")
   r.HandleFunc("/api/documents/{id}", enableCORS(documentUpdate)).Methods("PUT")
   r.HandleFunc("/api/documents/{id}", enableCORS(documentDelete)).Methods("DELETE")
+  r.HandleFunc("/api/verify", enableCORS(verifyHandler)).Methods("GET")
 
 
   r.HandleFunc("/api/invoices", enableCORS(invoicesList)).Methods("GET")
@@ -1195,6 +1873,129 @@ r.HandleFunc("/api/invoices/{id}", enableCORS(invoiceDelete)).Methods("DELETE")
 r.HandleFunc("/api/invoices/search", enableCORS(invoicesSearch)).Methods("GET")
 r.HandleFunc("/api/invoices/{id}/details", enableCORS(invoiceGetDetails)).Methods("GET")
 r.HandleFunc("/api/invoices/{id}/status", enableCORS(invoiceUpdateStatus)).Methods("PUT")
+r.HandleFunc("/api/invoices/{id}/payments", enableCORS(invoicePaymentsList)).Methods("GET")
+r.HandleFunc("/api/invoices/{id}/payments", enableCORS(paymentCreate)).Methods("POST")
+r.HandleFunc("/api/payments/{id}", enableCORS(paymentDelete)).Methods("DELETE")
+r.HandleFunc("/api/invoices/{id}/installments", enableCORS(invoiceInstallmentsList)).Methods("GET")
+r.HandleFunc("/api/installments/overdue", enableCORS(installmentsOverdue)).Methods("GET")
+r.HandleFunc("/api/reports/aging", enableCORS(agingReport)).Methods("GET")
+r.HandleFunc("/api/reports/finance", enableCORS(financeReport)).Methods("GET")
+r.HandleFunc("/api/invoices/{id}/pdf", enableCORS(invoicePDF)).Methods("GET")
+r.HandleFunc("/api/invoices/{id}/email", enableCORS(emailSendHandler(emailKindInvoice))).Methods("POST")
+r.HandleFunc("/api/payments/{id}/receipt", enableCORS(paymentReceipt)).Methods("GET")
+r.HandleFunc("/api/payments/{id}/refund", enableCORS(refundCreate)).Methods("POST")
+r.HandleFunc("/api/invoices/{id}/credit-notes", enableCORS(invoiceCreditNotesList)).Methods("GET")
+r.HandleFunc("/api/credit-notes", enableCORS(creditNotesList)).Methods("GET")
+r.HandleFunc("/api/invoices/quote", enableCORS(invoiceQuote)).Methods("POST")
+
+  // SMS notifications API
+  r.HandleFunc("/api/notifications", enableCORS(notificationsList)).Methods("GET")
+  r.HandleFunc("/api/notifications/templates", enableCORS(notificationTemplatesList)).Methods("GET")
+  r.HandleFunc("/api/notifications/test", enableCORS(notificationTest)).Methods("POST")
+  r.HandleFunc("/api/telegram/chats", enableCORS(telegramChatsList)).Methods("GET")
+  r.HandleFunc("/api/telegram/chats/{chatId}", enableCORS(telegramChatDelete)).Methods("DELETE")
+
+  // Email delivery API
+  r.HandleFunc("/api/emails", enableCORS(emailsList)).Methods("GET")
+  r.HandleFunc("/api/emails/{id}", enableCORS(emailGet)).Methods("GET")
+  r.HandleFunc("/api/emails/{id}/retry", enableCORS(emailRetry)).Methods("POST")
+
+  // Attachments API
+  r.HandleFunc("/api/attachments/{id}", enableCORS(attachmentGet)).Methods("GET")
+  r.HandleFunc("/api/attachments/{id}", enableCORS(attachmentDelete)).Methods("DELETE")
+  r.HandleFunc("/api/attachments/{id}/download", enableCORS(attachmentDownload)).Methods("GET")
+  r.HandleFunc("/api/attachments/{id}/thumbnail", enableCORS(attachmentThumbnail)).Methods("GET")
+
+  // Jobs API
+  r.HandleFunc("/api/jobs", enableCORS(jobsList)).Methods("GET")
+  r.HandleFunc("/api/jobs/stats", enableCORS(jobsStats)).Methods("GET")
+  r.HandleFunc("/api/jobs/{id}", enableCORS(jobGet)).Methods("GET")
+  r.HandleFunc("/api/jobs/{id}/retry", enableCORS(jobRetry)).Methods("POST")
+  r.HandleFunc("/api/jobs/{id}/discard", enableCORS(jobDiscard)).Methods("POST")
+
+  // Webhooks API
+  r.HandleFunc("/api/webhooks", enableCORS(webhooksList)).Methods("GET")
+  r.HandleFunc("/api/webhooks", enableCORS(webhookCreate)).Methods("POST")
+  r.HandleFunc("/api/webhooks/events", enableCORS(webhookEventsList)).Methods("GET")
+  r.HandleFunc("/api/webhooks/{id}", enableCORS(webhookGet)).Methods("GET")
+  r.HandleFunc("/api/webhooks/{id}", enableCORS(webhookUpdate)).Methods("PUT")
+  r.HandleFunc("/api/webhooks/{id}", enableCORS(webhookDelete)).Methods("DELETE")
+  r.HandleFunc("/api/webhooks/{id}/ping", enableCORS(webhookPingHandler)).Methods("POST")
+  r.HandleFunc("/api/webhooks/{id}/deliveries", enableCORS(webhookDeliveriesList)).Methods("GET")
+  r.HandleFunc("/api/webhooks/{id}/deliveries/{deliveryId}", enableCORS(webhookDeliveryGet)).Methods("GET")
+  r.HandleFunc("/api/webhooks/{id}/deliveries/{deliveryId}/redeliver", enableCORS(webhookRedeliver)).Methods("POST")
+
+  // Registry export API
+  r.HandleFunc("/api/registry/fields", enableCORS(registryFieldsList)).Methods("GET")
+  r.HandleFunc("/api/registry/mappings", enableCORS(registryMappingsList)).Methods("GET")
+  r.HandleFunc("/api/registry/mappings/{name}", enableCORS(registryMappingSave)).Methods("PUT")
+  r.HandleFunc("/api/registry/public-key", enableCORS(registryPublicKey)).Methods("GET")
+  r.HandleFunc("/api/registry/exports", enableCORS(registryExportsList)).Methods("GET")
+  r.HandleFunc("/api/registry/exports", enableCORS(registryExportCreate)).Methods("POST")
+  r.HandleFunc("/api/registry/exports/{id}", enableCORS(registryExportGet)).Methods("GET")
+  r.HandleFunc("/api/registry/exports/{id}/file", enableCORS(registryExportFile)).Methods("GET")
+
+  // Payme / Click merchant callbacks
+  r.HandleFunc("/api/merchant/payme", paymeMerchantHandler).Methods("POST")
+  r.HandleFunc("/api/merchant/click/prepare", clickPrepare).Methods("POST")
+  r.HandleFunc("/api/merchant/click/complete", clickComplete).Methods("POST")
+
+  // Price list API
+  r.HandleFunc("/api/services", enableCORS(servicesList)).Methods("GET")
+  r.HandleFunc("/api/services", enableCORS(serviceCreate)).Methods("POST")
+  r.HandleFunc("/api/services/{id}", enableCORS(serviceGet)).Methods("GET")
+  r.HandleFunc("/api/services/{id}", enableCORS(serviceUpdate)).Methods("PUT")
+  r.HandleFunc("/api/services/{id}", enableCORS(serviceDelete)).Methods("DELETE")
+
+  // Commissions & exam sessions API
+  r.HandleFunc("/api/commissions", enableCORS(commissionsList)).Methods("GET")
+  r.HandleFunc("/api/commissions", enableCORS(commissionCreate)).Methods("POST")
+  r.HandleFunc("/api/commissions/{id}", enableCORS(commissionGet)).Methods("GET")
+  r.HandleFunc("/api/commissions/{id}", enableCORS(commissionUpdate)).Methods("PUT")
+  r.HandleFunc("/api/commissions/{id}", enableCORS(commissionDelete)).Methods("DELETE")
+  r.HandleFunc("/api/commissions/{id}/members", enableCORS(commissionMemberAdd)).Methods("POST")
+  r.HandleFunc("/api/commissions/{id}/members/{memberId}", enableCORS(commissionMemberDelete)).Methods("DELETE")
+  r.HandleFunc("/api/exam-sessions", enableCORS(examSessionsList)).Methods("GET")
+  r.HandleFunc("/api/exam-sessions", enableCORS(examSessionCreate)).Methods("POST")
+  r.HandleFunc("/api/exam-sessions/{id}", enableCORS(examSessionGet)).Methods("GET")
+  r.HandleFunc("/api/exam-sessions/{id}", enableCORS(examSessionDelete)).Methods("DELETE")
+  r.HandleFunc("/api/exam-sessions/{id}/results", enableCORS(examResultsSave)).Methods("PUT")
+  r.HandleFunc("/api/exam-sessions/{id}/notify", enableCORS(examSessionNotify)).Methods("POST")
+
+  // Courses & grading API
+  r.HandleFunc("/api/courses", enableCORS(coursesList)).Methods("GET")
+  r.HandleFunc("/api/courses", enableCORS(courseCreate)).Methods("POST")
+  r.HandleFunc("/api/courses/{id}", enableCORS(courseGet)).Methods("GET")
+  r.HandleFunc("/api/courses/{id}", enableCORS(courseUpdate)).Methods("PUT")
+  r.HandleFunc("/api/courses/{id}", enableCORS(courseDelete)).Methods("DELETE")
+  r.HandleFunc("/api/students/{jshshir}/attempts", enableCORS(studentAttemptsList)).Methods("GET")
+  r.HandleFunc("/api/students/{jshshir}/attempts", enableCORS(studentAttemptCreate)).Methods("POST")
+
+  // Machine categories API
+  r.HandleFunc("/api/categories", enableCORS(categoriesList)).Methods("GET")
+  r.HandleFunc("/api/categories", enableCORS(categoryCreate)).Methods("POST")
+  r.HandleFunc("/api/categories/{code}", enableCORS(categoryGet)).Methods("GET")
+  r.HandleFunc("/api/categories/{code}", enableCORS(categoryUpdate)).Methods("PUT")
+  r.HandleFunc("/api/categories/{code}", enableCORS(categoryDelete)).Methods("DELETE")
+
+  // Groups & instructors API
+  r.HandleFunc("/api/groups", enableCORS(groupsList)).Methods("GET")
+  r.HandleFunc("/api/groups", enableCORS(groupCreate)).Methods("POST")
+  r.HandleFunc("/api/groups/{id}", enableCORS(groupGet)).Methods("GET")
+  r.HandleFunc("/api/groups/{id}", enableCORS(groupUpdate)).Methods("PUT")
+  r.HandleFunc("/api/groups/{id}", enableCORS(groupDelete)).Methods("DELETE")
+  r.HandleFunc("/api/groups/{id}/students", enableCORS(groupStudentAdd)).Methods("POST")
+  r.HandleFunc("/api/groups/{id}/students/{jshshir}", enableCORS(groupStudentRemove)).Methods("DELETE")
+  r.HandleFunc("/api/groups/{id}/instructors", enableCORS(groupInstructorAssign)).Methods("POST")
+  r.HandleFunc("/api/groups/{id}/instructors/{instructorId}", enableCORS(groupInstructorRemove)).Methods("DELETE")
+  r.HandleFunc("/api/instructors", enableCORS(instructorsList)).Methods("GET")
+  r.HandleFunc("/api/instructors", enableCORS(instructorCreate)).Methods("POST")
+  r.HandleFunc("/api/instructors/{id}", enableCORS(instructorGet)).Methods("GET")
+  r.HandleFunc("/api/instructors/{id}", enableCORS(instructorUpdate)).Methods("PUT")
+  r.HandleFunc("/api/instructors/{id}", enableCORS(instructorDelete)).Methods("DELETE")
+  r.HandleFunc("/api/exam-sessions/{id}/instructors", enableCORS(sessionInstructorAssign)).Methods("POST")
+  r.HandleFunc("/api/exam-sessions/{id}/instructors/{instructorId}", enableCORS(sessionInstructorRemove)).Methods("DELETE")
+  r.HandleFunc("/api/reports/instructor-workload", enableCORS(instructorWorkloadReport)).Methods("GET")
 
   // ВАЖНОЕ ИСПРАВЛЕНИЕ: Путь к статическим файлам
   // Получаем текущую директорию
@@ -1247,6 +2048,27 @@ if port == "" {
   port = "8080"
 }
 
-log.Println("🚀 Server ishga tushdi, port:", port)
-log.Fatal(http.ListenAndServe(":"+port, r))
+srv := &http.Server{Addr: ":" + port, Handler: r}
+go func() {
+  log.Println("🚀 Server ishga tushdi, port:", port)
+  if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+    log.Fatal(err)
+  }
+}()
+
+// Плавная остановка: дослушиваем запросы и ждём текущие фоновые задачи
+stop := make(chan os.Signal, 1)
+signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+<-stop
+log.Println("⏹ Server to'xtatilmoqda...")
+
+ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+defer cancel()
+if err := srv.Shutdown(ctx); err != nil {
+  log.Printf("HTTP to'xtatish xatosi: %v", err)
+}
+if err := jobs.Shutdown(ctx); err != nil {
+  log.Printf("Fon vazifalari to'xtamadi: %v", err)
+}
+log.Println("✅ Server to'xtadi")
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* =========================
+   ONLINE PAYMENTS (PAYME / CLICK)
+========================= */
+
+// Состояния транзакции платёжной системы (нумерация как у Payme).
+const (
+	merchantStateCreated         = 1
+	merchantStatePerformed       = 2
+	merchantStateCancelled       = -1
+	merchantStateCancelledRefund = -2
+)
+
+// Созданная, но не проведённая транзакция отменяется через 12 часов.
+const merchantTransactionTimeout = 12 * time.Hour
+
+type MerchantTransaction struct {
+	ID           int
+	Provider     string
+	ExternalID   string
+	InvoiceID    int
+	Amount       Money
+	State        int
+	ProviderTime int64
+	CreateTime   int64
+	PerformTime  int64
+	CancelTime   int64
+	Reason       sql.NullInt64
+	PaymentID    sql.NullInt64
+}
+
+var (
+	errMerchantTxNotFound  = errors.New("Tranzaksiya topilmadi")
+	errMerchantInvoicePaid = errors.New("Invoyis allaqachon to'langan")
+	errMerchantAmount      = errors.New("Summa noto'g'ri")
+)
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Счёт указывается в платёжной системе как id или номер (INV-000123).
+func resolveMerchantInvoice(ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if id, err := strconv.Atoi(ref); err == nil {
+		return id, nil
+	}
+	var id int
+	err := db.QueryRow(`SELECT id FROM invoices WHERE invoice_number=$1`, ref).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errInvoiceNotFound
+	}
+	return id, err
+}
+
+// Проверяет, можно ли принять по счёту указанную сумму.
+func checkMerchantInvoice(tx *sql.Tx, invoiceID int, amount Money) error {
+	var status string
+	var total, paid Money
+	err := tx.QueryRow(`
+		SELECT i.status, i.amount,
+			COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0)
+		FROM invoices i WHERE i.id=$1
+		FOR UPDATE OF i`, invoiceID,
+	).Scan(&status, &total, &paid)
+	if err == sql.ErrNoRows {
+		return errInvoiceNotFound
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case status == invoiceStatusCancelled:
+		return errInvoiceNotFound
+	case status == invoiceStatusPaid:
+		return errMerchantInvoicePaid
+	case amount <= 0 || amount > total-paid:
+		return errMerchantAmount
+	}
+	return nil
+}
+
+const merchantTxColumns = `id, provider, external_id, invoice_id, amount, state,
+	provider_time, create_time, perform_time, cancel_time, reason, payment_id`
+
+func scanMerchantTx(row interface{ Scan(...interface{}) error }, t *MerchantTransaction) error {
+	return row.Scan(&t.ID, &t.Provider, &t.ExternalID, &t.InvoiceID, &t.Amount, &t.State,
+		&t.ProviderTime, &t.CreateTime, &t.PerformTime, &t.CancelTime, &t.Reason, &t.PaymentID)
+}
+
+// Транзакция по внешнему id, заблокированная до конца tx.
+func loadMerchantTx(tx *sql.Tx, provider, externalID string) (MerchantTransaction, error) {
+	var t MerchantTransaction
+	err := scanMerchantTx(tx.QueryRow(`SELECT `+merchantTxColumns+` FROM merchant_transactions
+		WHERE provider=$1 AND external_id=$2 FOR UPDATE`, provider, externalID), &t)
+	if err == sql.ErrNoRows {
+		return t, errMerchantTxNotFound
+	}
+	return t, err
+}
+
+func loadMerchantTxByID(tx *sql.Tx, provider string, id int) (MerchantTransaction, error) {
+	var t MerchantTransaction
+	err := scanMerchantTx(tx.QueryRow(`SELECT `+merchantTxColumns+` FROM merchant_transactions
+		WHERE provider=$1 AND id=$2 FOR UPDATE`, provider, id), &t)
+	if err == sql.ErrNoRows {
+		return t, errMerchantTxNotFound
+	}
+	return t, err
+}
+
+func insertMerchantTx(tx *sql.Tx, t *MerchantTransaction) error {
+	t.State = merchantStateCreated
+	t.CreateTime = nowMillis()
+	return tx.QueryRow(`
+		INSERT INTO merchant_transactions (provider, external_id, invoice_id, amount, state, provider_time, create_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		t.Provider, t.ExternalID, t.InvoiceID, t.Amount, t.State, t.ProviderTime, t.CreateTime,
+	).Scan(&t.ID)
+}
+
+// Есть ли по счёту другая незавершённая транзакция этой платёжной системы.
+func hasPendingMerchantTx(tx *sql.Tx, provider string, invoiceID int, externalID string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM merchant_transactions
+			WHERE provider=$1 AND invoice_id=$2 AND state=$3 AND external_id<>$4)`,
+		provider, invoiceID, merchantStateCreated, externalID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (t *MerchantTransaction) expired() bool {
+	return t.State == merchantStateCreated &&
+		nowMillis()-t.CreateTime > int64(merchantTransactionTimeout/time.Millisecond)
+}
+
+// Проводит транзакцию: записывает платёж по счёту.
+func performMerchantTx(tx *sql.Tx, t *MerchantTransaction) error {
+	p := Payment{
+		InvoiceID:  t.InvoiceID,
+		Amount:     t.Amount,
+		Method:     t.Provider,
+		ReceivedBy: t.Provider,
+	}
+	if err := addPayment(tx, &p); err != nil {
+		return err
+	}
+	t.State = merchantStatePerformed
+	t.PerformTime = nowMillis()
+	t.PaymentID = sql.NullInt64{Int64: int64(p.ID), Valid: true}
+	_, err := tx.Exec(`UPDATE merchant_transactions SET state=$1, perform_time=$2, payment_id=$3 WHERE id=$4`,
+		t.State, t.PerformTime, p.ID, t.ID)
+	return err
+}
+
+// Отменяет транзакцию. Платёж проведённой транзакции остаётся в журнале,
+// а возврат оформляется кредит-нотой на ещё не возвращённую часть.
+func cancelMerchantTx(tx *sql.Tx, t *MerchantTransaction, reason int) error {
+	switch t.State {
+	case merchantStateCreated:
+		t.State = merchantStateCancelled
+	case merchantStatePerformed:
+		t.State = merchantStateCancelledRefund
+		if t.PaymentID.Valid {
+			if err := refundMerchantPayment(tx, t, reason); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	t.CancelTime = nowMillis()
+	t.Reason = sql.NullInt64{Int64: int64(reason), Valid: true}
+	_, err := tx.Exec(`UPDATE merchant_transactions SET state=$1, cancel_time=$2, reason=$3 WHERE id=$4`,
+		t.State, t.CancelTime, reason, t.ID)
+	return err
+}
+
+func refundMerchantPayment(tx *sql.Tx, t *MerchantTransaction, reason int) error {
+	var rest Money
+	err := tx.QueryRow(`
+		SELECT p.amount - COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn WHERE cn.payment_id = p.id), 0)
+		FROM payments p WHERE p.id=$1`, t.PaymentID.Int64,
+	).Scan(&rest)
+	if err != nil {
+		return err
+	}
+	// Уже возвращено вручную — вторая кредит-нота не нужна
+	if rest <= 0 {
+		return nil
+	}
+	c := CreditNote{
+		Amount:   rest,
+		Reason:   fmt.Sprintf("%s: to'lov bekor qilindi (sabab %d)", t.Provider, reason),
+		IssuedBy: t.Provider,
+		Role:     t.Provider,
+	}
+	return addCreditNote(tx, int(t.PaymentID.Int64), &c)
+}
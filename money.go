@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* =========================
+   MONEY
+========================= */
+
+// Денежная сумма в тийинах (1 сум = 100 тийин). Все расчёты ведутся
+// в целых числах, в JSON и в базе сумма записывается десятичной
+// строкой с двумя знаками ("1250000.50"), поэтому float нигде не
+// участвует и суммы сходятся с банковскими до тийина.
+type Money int64
+
+// Валюта всех сумм; хранится в счёте, чтобы отчёты не складывали разные валюты.
+const defaultCurrency = "UZS"
+
+var errMoneyFormat = errors.New("Summa noto'g'ri formatda")
+
+// parseMoney разбирает десятичную запись без потери точности.
+// Допускаются пробелы между разрядами и запятая вместо точки;
+// больше двух знаков после запятой — ошибка, а не округление.
+func parseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer(" ", "", " ", "", ",", ".").Replace(s)
+	if s == "" {
+		return 0, errMoneyFormat
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, errMoneyFormat
+	}
+	if len(frac) > 2 {
+		// Лишние нули в конце (1.500) точности не добавляют
+		if strings.Trim(frac[2:], "0") != "" {
+			return 0, errors.New("Summa tiyingacha aniqlikda bo'lishi kerak")
+		}
+		frac = frac[:2]
+	}
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, errMoneyFormat
+			}
+		}
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100-1 {
+		return 0, errMoneyFormat
+	}
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+	m := Money(units*100 + cents)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// Сумма из целого числа сумов.
+func soums(n int64) Money {
+	return Money(n * 100)
+}
+
+// String даёт точную десятичную запись: 1250000.50, -0.05.
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Целые сумы (с отбрасыванием тийинов) — для суммы прописью.
+func (m Money) Soums() int64 {
+	return int64(m) / 100
+}
+
+func (m Money) Tiyins() int64 {
+	v := int64(m) % 100
+	if v < 0 {
+		v = -v
+	}
+	return v
+}
+
+// percent — p процентов от суммы; p записан с точностью до сотых
+// (Money(1250) — 12,5%). Округление до тийина по правилу половины вверх.
+func (m Money) percent(p Money) Money {
+	v := int64(m) * int64(p)
+	if v >= 0 {
+		return Money((v + 5000) / 10000)
+	}
+	return Money((v - 5000) / 10000)
+}
+
+func minMoney(a, b Money) Money {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxMoney(a, b Money) Money {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// В JSON сумма принимается и числом (150000.5), и строкой ("150 000,50").
+// Число разбирается по исходному тексту, без промежуточного float64.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*m = 0
+		return nil
+	}
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		unq, err := strconv.Unquote(s)
+		if err != nil {
+			return errMoneyFormat
+		}
+		s = unq
+	} else if strings.ContainsAny(s, "eE") {
+		return errMoneyFormat
+	}
+	v, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// В базе суммы хранятся в NUMERIC(14,2); драйвер отдаёт их строкой.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = soums(v)
+	case float64:
+		*m = Money(math.Round(v * 100))
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("Money: неподдерживаемый тип %T", src)
+	}
+	return nil
+}
+
+// NUMERIC без масштаба (например, SUM или AVG) может прийти с большим
+// числом знаков; такие значения округляются до тийина.
+func (m *Money) scanString(s string) error {
+	v, err := parseMoney(s)
+	if err == nil {
+		*m = v
+		return nil
+	}
+	f, ferr := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if ferr != nil {
+		return fmt.Errorf("Money: %q: %v", s, err)
+	}
+	*m = Money(math.Round(f * 100))
+	return nil
+}
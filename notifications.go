@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/* =========================
+   NOTIFICATIONS
+========================= */
+
+// Шаблоны уведомлений
+const (
+	notifyInvoiceCreated   = "invoice_created"
+	notifyPaymentDue       = "payment_due"
+	notifyPaymentReceived  = "payment_received"
+	notifyExamScheduled    = "exam_scheduled"
+	notifyCertificateReady = "certificate_ready"
+)
+
+type NotificationTemplate struct {
+	Code  string `json:"code"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// Тексты на латинице без спецсимволов: так сообщение укладывается
+// в меньшее число SMS. {{.Name}} и {{.Org}} подставляются всегда.
+var notificationTemplates = []NotificationTemplate{
+	{notifyInvoiceCreated, "Invoyis yaratildi",
+		"Hurmatli {{.Name}}! Sizga {{.Number}} raqamli hisob yozildi: {{.Amount}} so'm. To'lov muddati: {{.DueDate}}. {{.Org}}"},
+	{notifyPaymentDue, "To'lov muddati yaqinlashdi",
+		"Hurmatli {{.Name}}! {{.Number}} hisob bo'yicha {{.Amount}} so'm to'lov muddati {{.DueDate}}. {{.Org}}"},
+	{notifyPaymentReceived, "To'lov qabul qilindi",
+		"Hurmatli {{.Name}}! {{.Number}} hisob bo'yicha {{.Amount}} so'm to'lov qabul qilindi. Qoldiq: {{.Balance}} so'm. {{.Org}}"},
+	{notifyExamScheduled, "Imtihon belgilandi",
+		"Hurmatli {{.Name}}! Imtihon {{.Date}} kuni bo'lib o'tadi{{if .Location}}, manzil: {{.Location}}{{end}}. {{.Org}}"},
+	{notifyCertificateReady, "Guvohnoma tayyor",
+		"Hurmatli {{.Name}}! {{.Number}} raqamli guvohnomangiz tayyor, uni {{.Org}} ofisidan olishingiz mumkin."},
+}
+
+var notificationTemplateSet = func() map[string]*template.Template {
+	set := map[string]*template.Template{}
+	for _, t := range notificationTemplates {
+		set[t.Code] = template.Must(template.New(t.Code).Option("missingkey=zero").Parse(t.Text))
+	}
+	return set
+}()
+
+type Notification struct {
+	ID             int    `json:"id"`
+	Channel        string `json:"channel"`
+	Template       string `json:"template"`
+	Ref            string `json:"ref,omitempty"`
+	StudentJSHSHIR string `json:"student_jshshir,omitempty"`
+	Recipient      string `json:"recipient"`
+	Body           string `json:"body"`
+	Status         string `json:"status"` // pending | sent | failed
+	Provider       string `json:"provider,omitempty"`
+	ProviderID     string `json:"provider_id,omitempty"`
+	Error          string `json:"error,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	SentAt         string `json:"sent_at,omitempty"`
+}
+
+// Шлюз выбирается при старте; при ошибке настройки сообщения только пишутся в лог.
+var smsSender SMSSender = consoleSender{}
+
+func initNotifications() {
+	s, err := newSMSSender()
+	if err != nil {
+		log.Printf("SMS sozlamalari xatosi: %v — xabarlar faqat logga yoziladi", err)
+		return
+	}
+	smsSender = s
+	log.Printf("SMS provayderi: %s", s.Name())
+}
+
+func renderNotification(code string, data map[string]string) (string, error) {
+	t, ok := notificationTemplateSet[code]
+	if !ok {
+		return "", fmt.Errorf("Shablon topilmadi: %s", code)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// Записывает уведомление студенту и ставит его отправку в очередь задач
+// (SMS и, если чат привязан, Telegram). ref защищает от повторов:
+// уведомление с тем же шаблоном и ref отправляется один раз.
+func notifyStudent(code, ref, jshshir string, data map[string]string) error {
+	var name, phone string
+	err := db.QueryRow(`SELECT full_name, COALESCE(phone, '') FROM students WHERE jshshir=$1`, jshshir).
+		Scan(&name, &phone)
+	if err == sql.ErrNoRows {
+		log.Printf("Xabarnoma %s: talaba %s topilmadi", code, jshshir)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if data == nil {
+		data = map[string]string{}
+	}
+	if data["Name"] == "" {
+		data["Name"] = name
+	}
+	data["Org"] = loadOrganization().Name
+
+	text, err := renderNotification(code, data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := notifyTelegram(tx, code, ref, jshshir, text); err != nil {
+		return err
+	}
+
+	// Без корректного номера SMS сразу записывается как неотправленное
+	status, errText := "pending", ""
+	recipient, phoneErr := normalizePhone(phone)
+	if phoneErr != nil {
+		recipient, status, errText = phone, "failed", phoneErr.Error()
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO notifications (channel, template, ref, student_jshshir, recipient, body, status, provider, error)
+		VALUES ('sms', $1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		code, nullIfEmpty(ref), jshshir, recipient, text, status, smsSender.Name(), nullIfEmpty(errText),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return tx.Commit() // SMS уже отправляли
+	} else if err != nil {
+		return err
+	}
+	if phoneErr == nil {
+		if _, err := enqueueJob(tx, jobSMSDeliver, notificationJob{NotificationID: id}); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("SMS #%d yuborilmadi: %v", id, phoneErr)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	jobs.notify()
+	return nil
+}
+
+type notificationJob struct {
+	NotificationID int `json:"notification_id"`
+}
+
+// Задача sms.deliver: отправка через шлюз; ошибка шлюза — повтор задачи.
+func deliverSMSJob(ctx context.Context, j *Job) error {
+	var p notificationJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var phone, text, status string
+	err := db.QueryRow(`SELECT recipient, body, status FROM notifications WHERE id=$1`, p.NotificationID).
+		Scan(&phone, &text, &status)
+	if err == sql.ErrNoRows || (err == nil && status != "pending") {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	providerID, err := smsSender.Send(ctx, strconv.Itoa(p.NotificationID), phone, text)
+	return finishNotification(j, p.NotificationID, providerID, err)
+}
+
+// Записывает результат отправки. Неудача до последней попытки оставляет
+// уведомление в pending (с текстом ошибки) и возвращает ошибку для повтора.
+func finishNotification(j *Job, id int, providerID string, sendErr error) error {
+	status, errText := "sent", ""
+	if sendErr != nil {
+		status, errText = "pending", sendErr.Error()
+		if j.lastAttempt() {
+			status = "failed"
+		}
+	}
+	_, err := db.Exec(`
+		UPDATE notifications
+		SET status=$1, provider_id=$2, error=$3, sent_at=CASE WHEN $1='sent' THEN NOW() END
+		WHERE id=$4`,
+		status, nullIfEmpty(providerID), nullIfEmpty(errText), id)
+	if err != nil {
+		log.Printf("Xabarnoma holatini yangilash xatosi: %v", err)
+	}
+	return sendErr
+}
+
+/* ---------- события ---------- */
+
+// Задачи событий ставятся в транзакции бизнес-операции; текст
+// уведомления собирается уже при выполнении задачи.
+type invoiceNotifyJob struct {
+	InvoiceID int `json:"invoice_id"`
+}
+
+type paymentNotifyJob struct {
+	PaymentID int `json:"payment_id"`
+}
+
+type certificateNotifyJob struct {
+	StudentJSHSHIR string `json:"student_jshshir"`
+	CertificateNo  string `json:"certificate_number"`
+}
+
+func notifyInvoiceJob(ctx context.Context, j *Job) error {
+	var p invoiceNotifyJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var number, jshshir, dueDate string
+	var amount Money
+	err := db.QueryRow(`
+		SELECT COALESCE(invoice_number, ''), student_jshshir, amount, COALESCE(due_date::text, '')
+		FROM invoices WHERE id=$1`, p.InvoiceID,
+	).Scan(&number, &jshshir, &amount, &dueDate)
+	if err == sql.ErrNoRows {
+		return nil // счёт уже удалён
+	} else if err != nil {
+		return err
+	}
+	return notifyStudent(notifyInvoiceCreated, fmt.Sprintf("invoice:%d", p.InvoiceID), jshshir, map[string]string{
+		"Number":  number,
+		"Amount":  formatMoney(amount),
+		"DueDate": dueDate,
+	})
+}
+
+func notifyPaymentJob(ctx context.Context, j *Job) error {
+	var p paymentNotifyJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var number, jshshir string
+	var amount Money
+	err := db.QueryRow(`
+		SELECT COALESCE(i.invoice_number, ''), i.student_jshshir, p.amount
+		FROM payments p JOIN invoices i ON i.id = p.invoice_id
+		WHERE p.id=$1`, p.PaymentID,
+	).Scan(&number, &jshshir, &amount)
+	if err == sql.ErrNoRows {
+		return nil // платёж отменён до отправки
+	} else if err != nil {
+		return err
+	}
+	// Остаток — общий долг студента с учётом кредит-нот и отмен
+	b, err := loadStudentBalance(jshshir)
+	if err == errStudentNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return notifyStudent(notifyPaymentReceived, fmt.Sprintf("payment:%d", p.PaymentID), jshshir, map[string]string{
+		"Number":  number,
+		"Amount":  formatMoney(amount),
+		"Balance": formatMoney(maxMoney(b.Balance, 0)),
+	})
+}
+
+func notifyCertificateJob(ctx context.Context, j *Job) error {
+	var p certificateNotifyJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	if strings.TrimSpace(p.StudentJSHSHIR) == "" {
+		return nil
+	}
+	return notifyStudent(notifyCertificateReady, "certificate:"+p.CertificateNo, p.StudentJSHSHIR, map[string]string{
+		"Number": p.CertificateNo,
+	})
+}
+
+// POST /api/exam-sessions/{id}/notify — {"group_id": 3} или {"students": ["..."]}
+func examSessionNotify(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri sessiya ID", 400)
+		return
+	}
+	var input struct {
+		GroupID  int      `json:"group_id"`
+		Students []string `json:"students"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+
+	var examDate, location string
+	err = db.QueryRow(`SELECT to_char(exam_date, 'YYYY-MM-DD'), location FROM exam_sessions WHERE id=$1`, id).
+		Scan(&examDate, &location)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Imtihon sessiyasi topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	students := input.Students
+	if input.GroupID != 0 {
+		rows, err := db.Query(`SELECT student_jshshir FROM group_students WHERE group_id=$1`, input.GroupID)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for rows.Next() {
+			var j string
+			if rows.Scan(&j) == nil {
+				students = append(students, j)
+			}
+		}
+		rows.Close()
+	}
+	if len(students) == 0 {
+		http.Error(w, "Guruh yoki talabalar ko'rsatilmagan", 400)
+		return
+	}
+
+	for _, j := range students {
+		// Приглашение записывается до рассылки: "мой экзамен" в боте
+		// не зависит от того, ушло ли SMS
+		_, err := db.Exec(`
+			INSERT INTO session_students (session_id, student_jshshir)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, id, strings.TrimSpace(j))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		err = notifyStudent(notifyExamScheduled, fmt.Sprintf("exam:%d:%s:%s", id, examDate, j), j, map[string]string{
+			"Date":     examDate,
+			"Location": location,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	respondJSON(w, map[string]interface{}{"status": "queued", "students": len(students)})
+}
+
+/* ---------- напоминания о сроке оплаты ---------- */
+
+const paymentReminderInterval = 6 * time.Hour
+
+// За сколько дней до срока части напоминать (SMS_REMIND_DAYS, по умолчанию 3).
+func paymentReminderDays() int {
+	if d, err := strconv.Atoi(envDefault("SMS_REMIND_DAYS", "3")); err == nil && d >= 0 {
+		return d
+	}
+	return 3
+}
+
+func sendPaymentReminders() (int, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(invoice_number, ''), student_jshshir
+		FROM invoices WHERE status IN ($1, $2)`,
+		invoiceStatusPending, invoiceStatusPartial)
+	if err != nil {
+		return 0, err
+	}
+	type ref struct {
+		id              int
+		number, jshshir string
+	}
+	var refs []ref
+	for rows.Next() {
+		var r ref
+		if err := rows.Scan(&r.id, &r.number, &r.jshshir); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		refs = append(refs, r)
+	}
+	rows.Close()
+
+	today := time.Now().Format("2006-01-02")
+	until := time.Now().AddDate(0, 0, paymentReminderDays()).Format("2006-01-02")
+	sent := 0
+	for _, r := range refs {
+		insts, err := loadInstallments(r.id)
+		if err != nil {
+			log.Printf("Invoyis %d grafigini yuklash xatosi: %v", r.id, err)
+			continue
+		}
+		for _, inst := range insts {
+			if inst.Status != "pending" && inst.Status != "partially_paid" {
+				continue
+			}
+			if inst.DueDate < today || inst.DueDate > until {
+				continue
+			}
+			err := notifyStudent(notifyPaymentDue, fmt.Sprintf("due:%d:%d:%s", r.id, inst.Seq, inst.DueDate), r.jshshir,
+				map[string]string{
+					"Number":  r.number,
+					"Amount":  formatMoney(inst.Amount - inst.PaidAmount),
+					"DueDate": inst.DueDate,
+				})
+			if err != nil {
+				return sent, err
+			}
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Периодическая задача payments.remind.
+func paymentRemindersJob(ctx context.Context, j *Job) error {
+	n, err := sendPaymentReminders()
+	if n > 0 {
+		log.Printf("⏰ %d ta to'lov eslatmasi navbatga qo'yildi", n)
+	}
+	return err
+}
+
+/* ---------- API ---------- */
+
+// GET /api/notifications?status=&student=&template=
+func notificationsList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rows, err := db.Query(`
+		SELECT id, channel, template, COALESCE(ref, ''), COALESCE(student_jshshir, ''), recipient, body,
+			status, COALESCE(provider, ''), COALESCE(provider_id, ''), COALESCE(error, ''),
+			to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'), COALESCE(to_char(sent_at, 'YYYY-MM-DD HH24:MI:SS'), '')
+		FROM notifications
+		WHERE ($1 = '' OR status = $1)
+			AND ($2 = '' OR student_jshshir = $2)
+			AND ($3 = '' OR template = $3)
+		ORDER BY id DESC
+		LIMIT 500`,
+		q.Get("status"), q.Get("student"), q.Get("template"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Channel, &n.Template, &n.Ref, &n.StudentJSHSHIR, &n.Recipient, &n.Body,
+			&n.Status, &n.Provider, &n.ProviderID, &n.Error, &n.CreatedAt, &n.SentAt); err != nil {
+			log.Printf("Error scanning notification: %v", err)
+			continue
+		}
+		list = append(list, n)
+	}
+	respondJSON(w, list)
+}
+
+func notificationTemplatesList(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, notificationTemplates)
+}
+
+// POST /api/notifications/test — {"phone": "...", "text": "..."}: проверка шлюза.
+func notificationTest(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone string `json:"phone"`
+		Text  string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	phone, err := normalizePhone(input.Phone)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if strings.TrimSpace(input.Text) == "" {
+		input.Text = "Test xabar: " + loadOrganization().Name
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	id, err := smsSender.Send(ctx, "", phone, input.Text)
+	if err != nil {
+		http.Error(w, err.Error(), 502)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "sent", "provider": smsSender.Name(), "provider_id": id})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* =========================
+   INVOICE NUMBERING
+========================= */
+
+// Номер счёта: <префикс>-<год>-<порядковый номер>, например INV-2026-000123.
+// Нумерация начинается заново с каждого финансового года.
+//
+//	INVOICE_NUMBER_PREFIX — префикс (по умолчанию INV);
+//	INVOICE_NUMBER_DIGITS — ширина номера с ведущими нулями (по умолчанию 6).
+type invoiceNumbering struct {
+	Prefix string
+	Digits int
+}
+
+func loadInvoiceNumbering() invoiceNumbering {
+	n := invoiceNumbering{Prefix: "INV", Digits: 6}
+	if v := strings.ToUpper(strings.TrimSpace(os.Getenv("INVOICE_NUMBER_PREFIX"))); v != "" {
+		n.Prefix = v
+	}
+	if v := strings.TrimSpace(os.Getenv("INVOICE_NUMBER_DIGITS")); v != "" {
+		if d, err := strconv.Atoi(v); err == nil && d >= 1 && d <= 12 {
+			n.Digits = d
+		} else {
+			log.Printf("INVOICE_NUMBER_DIGITS=%q noto'g'ri, %d ishlatiladi", v, n.Digits)
+		}
+	}
+	return n
+}
+
+func (n invoiceNumbering) format(year, seq int) string {
+	return fmt.Sprintf("%s-%d-%0*d", n.Prefix, year, n.Digits, seq)
+}
+
+// Выделяет следующий номер счёта внутри транзакции создания счёта.
+// Счётчик хранится построчно на префикс и год; UPSERT блокирует строку
+// до конца транзакции, поэтому параллельные счета получают разные
+// номера, а при откате номер возвращается. Счёт с номером не удаляется,
+// а только отменяется (см. invoiceDelete), поэтому пропусков не бывает.
+func nextInvoiceNumber(tx *sql.Tx, issued time.Time) (string, error) {
+	n := loadInvoiceNumbering()
+	year := issued.Year()
+
+	var seq int
+	err := tx.QueryRow(`
+		INSERT INTO invoice_number_sequences (prefix, year, last_value)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (prefix, year)
+		DO UPDATE SET last_value = invoice_number_sequences.last_value + 1
+		RETURNING last_value`,
+		n.Prefix, year,
+	).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return n.format(year, seq), nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/* =========================
+   PAYME MERCHANT API
+========================= */
+
+// Коды ошибок протокола Payme.
+const (
+	paymeErrParse         = -32700
+	paymeErrMethod        = -32601
+	paymeErrAuth          = -32504
+	paymeErrSystem        = -32400
+	paymeErrAmount        = -31001
+	paymeErrTxNotFound    = -31003
+	paymeErrCannotCancel  = -31007
+	paymeErrCannotPerform = -31008
+	paymeErrAccount       = -31050
+	paymeErrAccountBusy   = -31051
+)
+
+// Причина отмены при истечении срока транзакции.
+const paymeReasonTimeout = 4
+
+type paymeRequest struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type paymeParams struct {
+	ID      string                 `json:"id"`
+	Time    int64                  `json:"time"`
+	Amount  int64                  `json:"amount"` // в тийинах
+	Account map[string]interface{} `json:"account"`
+	Reason  int                    `json:"reason"`
+	From    int64                  `json:"from"`
+	To      int64                  `json:"to"`
+}
+
+type paymeError struct {
+	Code    int               `json:"code"`
+	Message map[string]string `json:"message"`
+	Data    string            `json:"data,omitempty"`
+}
+
+func newPaymeError(code int, uz, data string) *paymeError {
+	return &paymeError{Code: code, Message: map[string]string{"uz": uz, "ru": uz, "en": uz}, Data: data}
+}
+
+func paymeKey() string {
+	return strings.TrimSpace(os.Getenv("PAYME_KEY"))
+}
+
+// Payme передаёт ключ кассы в Basic-авторизации с логином «Paycom».
+func paymeAuthorized(r *http.Request) bool {
+	key := paymeKey()
+	if key == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(raw, []byte("Paycom:"+key)) == 1
+}
+
+func paymeInvoiceRef(account map[string]interface{}) string {
+	for _, key := range []string{"invoice_id", "invoice_number"} {
+		if v, ok := account[key]; ok && v != nil {
+			if f, ok := v.(float64); ok {
+				return fmt.Sprintf("%.0f", f)
+			}
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+func paymeMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	var req paymeRequest
+	reply := func(result interface{}, perr *paymeError) {
+		resp := map[string]interface{}{"id": req.ID}
+		if perr != nil {
+			resp["error"] = perr
+		} else {
+			resp["result"] = result
+		}
+		respondJSON(w, resp)
+	}
+
+	if !paymeAuthorized(r) {
+		reply(nil, newPaymeError(paymeErrAuth, "Ruxsat yo'q", ""))
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reply(nil, newPaymeError(paymeErrParse, "JSON xato", ""))
+		return
+	}
+	var p paymeParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			reply(nil, newPaymeError(paymeErrParse, "Parametrlar xato", ""))
+			return
+		}
+	}
+
+	var result interface{}
+	var perr *paymeError
+	switch req.Method {
+	case "CheckPerformTransaction":
+		result, perr = paymeCheckPerform(p)
+	case "CreateTransaction":
+		result, perr = paymeCreate(p)
+	case "PerformTransaction":
+		result, perr = paymePerform(p)
+	case "CancelTransaction":
+		result, perr = paymeCancel(p)
+	case "CheckTransaction":
+		result, perr = paymeCheck(p)
+	case "GetStatement":
+		result, perr = paymeStatement(p)
+	default:
+		perr = newPaymeError(paymeErrMethod, "Metod topilmadi", req.Method)
+	}
+	if perr != nil {
+		log.Printf("Payme %s: %d %s", req.Method, perr.Code, perr.Message["uz"])
+	}
+	reply(result, perr)
+}
+
+// Проверка счёта и суммы — ошибки Payme для неверного счёта и суммы.
+func paymeValidate(invoiceID int, err error) *paymeError {
+	switch {
+	case err == nil:
+		return nil
+	case err == errInvoiceNotFound:
+		return newPaymeError(paymeErrAccount, "Invoyis topilmadi", "invoice_id")
+	case err == errMerchantInvoicePaid:
+		return newPaymeError(paymeErrAccount, err.Error(), "invoice_id")
+	case err == errMerchantAmount:
+		return newPaymeError(paymeErrAmount, err.Error(), "amount")
+	}
+	log.Printf("Payme: invoyis %d tekshiruv xatosi: %v", invoiceID, err)
+	return newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+}
+
+func paymeCheckPerform(p paymeParams) (interface{}, *paymeError) {
+	invoiceID, err := resolveMerchantInvoice(paymeInvoiceRef(p.Account))
+	if err != nil {
+		return nil, paymeValidate(0, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer tx.Rollback()
+
+	if perr := paymeValidate(invoiceID, checkMerchantInvoice(tx, invoiceID, Money(p.Amount))); perr != nil {
+		return nil, perr
+	}
+	return map[string]bool{"allow": true}, nil
+}
+
+func paymeCreate(p paymeParams) (interface{}, *paymeError) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTx(tx, "payme", p.ID)
+	switch {
+	case err == nil:
+		// Повторный вызов: возвращаем уже созданную транзакцию
+		if t.State != merchantStateCreated {
+			return nil, newPaymeError(paymeErrCannotPerform, "Tranzaksiya holati noto'g'ri", "")
+		}
+		if t.expired() {
+			if err := cancelMerchantTx(tx, &t, paymeReasonTimeout); err == nil {
+				tx.Commit()
+			}
+			return nil, newPaymeError(paymeErrCannotPerform, "Tranzaksiya muddati o'tgan", "")
+		}
+	case err == errMerchantTxNotFound:
+		invoiceID, err := resolveMerchantInvoice(paymeInvoiceRef(p.Account))
+		if err != nil {
+			return nil, paymeValidate(0, err)
+		}
+		if perr := paymeValidate(invoiceID, checkMerchantInvoice(tx, invoiceID, Money(p.Amount))); perr != nil {
+			return nil, perr
+		}
+		busy, err := hasPendingMerchantTx(tx, "payme", invoiceID, p.ID)
+		if err != nil {
+			return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+		}
+		if busy {
+			return nil, newPaymeError(paymeErrAccountBusy, "Invoyis bo'yicha boshqa tranzaksiya kutilmoqda", "invoice_id")
+		}
+		t = MerchantTransaction{
+			Provider:     "payme",
+			ExternalID:   p.ID,
+			InvoiceID:    invoiceID,
+			Amount:       Money(p.Amount),
+			ProviderTime: p.Time,
+		}
+		if err := insertMerchantTx(tx, &t); err != nil {
+			log.Printf("Payme tranzaksiya yaratish xatosi: %v", err)
+			return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+		}
+	default:
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	return map[string]interface{}{
+		"create_time": t.CreateTime,
+		"transaction": fmt.Sprint(t.ID),
+		"state":       t.State,
+	}, nil
+}
+
+func paymePerform(p paymeParams) (interface{}, *paymeError) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTx(tx, "payme", p.ID)
+	if err == errMerchantTxNotFound {
+		return nil, newPaymeError(paymeErrTxNotFound, err.Error(), "")
+	} else if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+
+	switch t.State {
+	case merchantStateCreated:
+		if t.expired() {
+			if err := cancelMerchantTx(tx, &t, paymeReasonTimeout); err == nil {
+				tx.Commit()
+			}
+			return nil, newPaymeError(paymeErrCannotPerform, "Tranzaksiya muddati o'tgan", "")
+		}
+		if err := performMerchantTx(tx, &t); err != nil {
+			log.Printf("Payme tranzaksiyani o'tkazish xatosi: %v", err)
+			return nil, newPaymeError(paymeErrCannotPerform, err.Error(), "")
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+		}
+		log.Printf("Payme to'lovi o'tkazildi: invoyis %d, summa %s", t.InvoiceID, t.Amount)
+	case merchantStatePerformed:
+		// Повторный вызов ничего не меняет
+	default:
+		return nil, newPaymeError(paymeErrCannotPerform, "Tranzaksiya bekor qilingan", "")
+	}
+
+	return map[string]interface{}{
+		"transaction":  fmt.Sprint(t.ID),
+		"perform_time": t.PerformTime,
+		"state":        t.State,
+	}, nil
+}
+
+func paymeCancel(p paymeParams) (interface{}, *paymeError) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTx(tx, "payme", p.ID)
+	if err == errMerchantTxNotFound {
+		return nil, newPaymeError(paymeErrTxNotFound, err.Error(), "")
+	} else if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+
+	if err := cancelMerchantTx(tx, &t, p.Reason); err != nil {
+		log.Printf("Payme tranzaksiyani bekor qilish xatosi: %v", err)
+		return nil, newPaymeError(paymeErrCannotCancel, "Bekor qilib bo'lmaydi", "")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+
+	return map[string]interface{}{
+		"transaction": fmt.Sprint(t.ID),
+		"cancel_time": t.CancelTime,
+		"state":       t.State,
+	}, nil
+}
+
+func paymeTxResult(t MerchantTransaction) map[string]interface{} {
+	var reason interface{}
+	if t.Reason.Valid {
+		reason = t.Reason.Int64
+	}
+	return map[string]interface{}{
+		"id":           t.ExternalID,
+		"time":         t.ProviderTime,
+		"amount":       int64(t.Amount),
+		"account":      map[string]string{"invoice_id": fmt.Sprint(t.InvoiceID)},
+		"create_time":  t.CreateTime,
+		"perform_time": t.PerformTime,
+		"cancel_time":  t.CancelTime,
+		"transaction":  fmt.Sprint(t.ID),
+		"state":        t.State,
+		"reason":       reason,
+	}
+}
+
+func paymeCheck(p paymeParams) (interface{}, *paymeError) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer tx.Rollback()
+
+	t, err := loadMerchantTx(tx, "payme", p.ID)
+	if err == errMerchantTxNotFound {
+		return nil, newPaymeError(paymeErrTxNotFound, err.Error(), "")
+	} else if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+
+	result := paymeTxResult(t)
+	delete(result, "id")
+	delete(result, "time")
+	delete(result, "amount")
+	delete(result, "account")
+	return result, nil
+}
+
+func paymeStatement(p paymeParams) (interface{}, *paymeError) {
+	rows, err := db.Query(`SELECT `+merchantTxColumns+` FROM merchant_transactions
+		WHERE provider='payme' AND provider_time BETWEEN $1 AND $2
+		ORDER BY provider_time`, p.From, p.To)
+	if err != nil {
+		return nil, newPaymeError(paymeErrSystem, "Tizim xatosi", "")
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var t MerchantTransaction
+		if err := scanMerchantTx(rows, &t); err != nil {
+			log.Printf("Error scanning payme transaction: %v", err)
+			continue
+		}
+		list = append(list, paymeTxResult(t))
+	}
+	return map[string]interface{}{"transactions": list}, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* =========================
+   PAYMENTS
+========================= */
+
+// Статусы счёта хранятся так, как их показывает интерфейс.
+const (
+	invoiceStatusPending   = "To'lov kutilmoqda"
+	invoiceStatusPartial   = "Qisman to'landi"
+	invoiceStatusPaid      = "To'landi"
+	invoiceStatusCancelled = "Bekor qilindi"
+)
+
+var invoiceStatusCodes = map[string]string{
+	invoiceStatusPending:   "pending",
+	invoiceStatusPartial:   "partially_paid",
+	invoiceStatusPaid:      "paid",
+	invoiceStatusCancelled: "cancelled",
+}
+
+var paymentMethods = []string{"cash", "card", "bank_transfer", "payme", "click"}
+
+type Payment struct {
+	ID            int    `json:"id"`
+	InvoiceID     int    `json:"invoice_id"`
+	InvoiceNumber string `json:"invoice_number,omitempty"`
+	Amount        Money  `json:"amount"`
+	Method        string `json:"method"`
+	ReceivedBy    string `json:"received_by"`
+	PaidAt        string `json:"paid_at"`
+	CreatedAt     string `json:"created_at"`
+}
+
+var errInvoiceNotFound = errors.New("Invoyis topilmadi")
+
+func invoiceStatusCode(status string) string {
+	if code, ok := invoiceStatusCodes[status]; ok {
+		return code
+	}
+	return "pending"
+}
+
+func isValidPaymentMethod(m string) bool {
+	for _, pm := range paymentMethods {
+		if pm == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Пересчитывает статус и дату оплаты счёта по его платежам и
+// кредит-нотам. Отменённый счёт остаётся отменённым, полностью
+// возвращённый — отменяется.
+func recalcInvoice(tx *sql.Tx, invoiceID int) error {
+	var status, prev string
+	var amount, paid, credit Money
+	err := tx.QueryRow(`
+		SELECT i.status, i.amount,
+			COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+			`+invoiceCreditSQL+`
+		FROM invoices i WHERE i.id=$1
+		FOR UPDATE OF i`, invoiceID,
+	).Scan(&status, &amount, &paid, &credit)
+	if err == sql.ErrNoRows {
+		return errInvoiceNotFound
+	} else if err != nil {
+		return err
+	}
+	if status == invoiceStatusCancelled {
+		return nil
+	}
+	prev = status
+	if credit > 0 && credit >= amount {
+		_, err = tx.Exec(`UPDATE invoices SET status=$1, overdue=FALSE, cancelled_at=CURRENT_DATE WHERE id=$2`,
+			invoiceStatusCancelled, invoiceID)
+		return err
+	}
+
+	switch {
+	case paid-credit <= 0:
+		status = invoiceStatusPending
+	case paid < amount:
+		status = invoiceStatusPartial
+	default:
+		status = invoiceStatusPaid
+	}
+
+	_, err = tx.Exec(`
+		UPDATE invoices
+		SET status=$1,
+			payment_date=CASE WHEN $1=$2 THEN (SELECT MAX(paid_at) FROM payments WHERE invoice_id=$3) END
+		WHERE id=$3`,
+		status, invoiceStatusPaid, invoiceID,
+	)
+	if err != nil {
+		return err
+	}
+	if status == invoiceStatusPaid && prev != invoiceStatusPaid {
+		if err := emitWebhook(tx, webhookInvoicePaid, invoiceID, ""); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE invoices i SET overdue = `+invoiceOverdueCondition+` WHERE i.id=$3`,
+		invoiceStatusPending, invoiceStatusPartial, invoiceID)
+	return err
+}
+
+// Записывает платёж по счёту. Сумма не может превышать остаток долга.
+func addPayment(tx *sql.Tx, p *Payment) error {
+	var status string
+	var amount, paid Money
+	err := tx.QueryRow(`
+		SELECT i.status, i.amount,
+			COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0)
+		FROM invoices i WHERE i.id=$1
+		FOR UPDATE OF i`, p.InvoiceID,
+	).Scan(&status, &amount, &paid)
+	if err == sql.ErrNoRows {
+		return errInvoiceNotFound
+	} else if err != nil {
+		return err
+	}
+	if status == invoiceStatusCancelled {
+		return errors.New("Bekor qilingan invoyisga to'lov qabul qilinmaydi")
+	}
+	if p.Amount > amount-paid {
+		return errors.New("To'lov summasi qoldiqdan oshib ketdi")
+	}
+
+	if p.PaidAt == "" {
+		p.PaidAt = time.Now().Format("2006-01-02")
+	}
+	err = tx.QueryRow(`
+		INSERT INTO payments (invoice_id, amount, method, received_by, paid_at)
+		VALUES ($1, $2, $3, $4, $5::date)
+		RETURNING id, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+		p.InvoiceID, p.Amount, p.Method, p.ReceivedBy, p.PaidAt,
+	).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := enqueueJob(tx, jobNotifyPayment, paymentNotifyJob{PaymentID: p.ID}); err != nil {
+		return err
+	}
+
+	return recalcInvoice(tx, p.InvoiceID)
+}
+
+func loadInvoicePayments(invoiceID int) ([]Payment, error) {
+	rows, err := db.Query(`
+		SELECT id, invoice_id, amount, method, received_by,
+			to_char(paid_at, 'YYYY-MM-DD'), to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM payments WHERE invoice_id=$1
+		ORDER BY paid_at, id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Payment{}
+	for rows.Next() {
+		var p Payment
+		if err := rows.Scan(&p.ID, &p.InvoiceID, &p.Amount, &p.Method, &p.ReceivedBy, &p.PaidAt, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func invoicePaymentsList(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", 400)
+		return
+	}
+
+	list, err := loadInvoicePayments(invoiceID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, list)
+}
+
+func paymentCreate(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", 400)
+		return
+	}
+
+	var p Payment
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	p.InvoiceID = invoiceID
+	p.ReceivedBy = strings.TrimSpace(p.ReceivedBy)
+	if p.Amount <= 0 {
+		http.Error(w, "To'lov summasi musbat bo'lishi kerak", 400)
+		return
+	}
+	if !isValidPaymentMethod(p.Method) {
+		http.Error(w, "To'lov usuli noto'g'ri ("+strings.Join(paymentMethods, ", ")+")", 400)
+		return
+	}
+	if p.ReceivedBy == "" {
+		http.Error(w, "To'lovni qabul qilgan shaxs ko'rsatilmagan", 400)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := addPayment(tx, &p); err != nil {
+		if err == errInvoiceNotFound {
+			http.Error(w, err.Error(), 404)
+		} else {
+			log.Printf("To'lovni yozish xatosi: %v", err)
+			http.Error(w, err.Error(), 400)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Printf("To'lov qabul qilindi: invoyis %d, summa %s, usul %s", p.InvoiceID, p.Amount, p.Method)
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, p)
+}
+
+// Удаление ошибочно введённого платежа; статус счёта пересчитывается.
+func paymentDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid payment ID", 400)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	var refunded bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM credit_notes WHERE payment_id=$1)`, id).Scan(&refunded); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if refunded {
+		http.Error(w, "To'lov bo'yicha qaytarish rasmiylashtirilgan, uni o'chirib bo'lmaydi", 409)
+		return
+	}
+
+	// Платёж Payme/Click подтверждён платёжной системой: удалить его
+	// у себя — значит разойтись с ней. Возврат — только кредит-нотой.
+	var merchant bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM merchant_transactions WHERE payment_id=$1)`, id).Scan(&merchant); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if merchant {
+		http.Error(w, "To'lov to'lov tizimi orqali o'tgan, uni o'chirib bo'lmaydi", 409)
+		return
+	}
+
+	var invoiceID int
+	err = tx.QueryRow(`DELETE FROM payments WHERE id=$1 RETURNING invoice_id`, id).Scan(&invoiceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "To'lov topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if err := recalcInvoice(tx, invoiceID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
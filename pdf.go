@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+)
+
+/* =========================
+   PDF WRITER
+========================= */
+
+// Минимальный генератор PDF для печатных форм: страницы A4, стандартные
+// шрифты Helvetica (WinAnsi), текст, линии и JPEG-изображения. Внешние
+// библиотеки не нужны, поэтому формы получаются одинаковыми на любом сервере.
+
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+type pdfDoc struct {
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	images []pdfImage
+}
+
+type pdfImage struct {
+	data          []byte // JPEG
+	width, height int
+}
+
+func newPDF() *pdfDoc {
+	d := &pdfDoc{}
+	d.AddPage()
+	return d
+}
+
+func (d *pdfDoc) AddPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+}
+
+// Text выводит строку; y отсчитывается от верхнего края страницы.
+func (d *pdfDoc) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, size, x, pdfPageHeight-y, pdfEscape(s))
+}
+
+// TextRight выравнивает строку по правому краю x.
+func (d *pdfDoc) TextRight(x, y, size float64, bold bool, s string) {
+	d.Text(x-pdfTextWidth(s, size), y, size, bold, s)
+}
+
+func (d *pdfDoc) TextCenter(y, size float64, bold bool, s string) {
+	d.Text((pdfPageWidth-pdfTextWidth(s, size))/2, y, size, bold, s)
+}
+
+func (d *pdfDoc) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+func (d *pdfDoc) Rect(x, y, w, h float64) {
+	fmt.Fprintf(d.page, "0.5 w %.2f %.2f %.2f %.2f re S\n",
+		x, pdfPageHeight-y-h, w, h)
+}
+
+// Image выводит картинку в прямоугольник w×h; y — верхний край.
+// Картинка встраивается как JPEG (DCTDecode) в цвете RGB.
+func (d *pdfDoc) Image(x, y, w, h float64, img image.Image) error {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	d.images = append(d.images, pdfImage{data: buf.Bytes(), width: b.Dx(), height: b.Dy()})
+	fmt.Fprintf(d.page, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n",
+		w, h, x, pdfPageHeight-y-h, len(d.images))
+	return nil
+}
+
+// Bytes собирает документ: каталог, дерево страниц, два шрифта,
+// изображения, затем по паре объектов (страница, поток содержимого)
+// на каждую страницу.
+func (d *pdfDoc) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	first := 5 + len(d.images) // номер объекта первой страницы
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", first+i*2)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	xobjects := ""
+	for i, img := range d.images {
+		obj(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n%s\nendstream",
+			img.width, img.height, len(img.data), img.data))
+		xobjects += fmt.Sprintf(" /Im%d %d 0 R", i+1, 5+i)
+	}
+	if xobjects != "" {
+		xobjects = " /XObject <<" + xobjects + " >>"
+	}
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >>%s >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, xobjects, first+1+i*2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// Кириллица в стандартных шрифтах PDF недоступна, поэтому имена,
+// записанные кириллицей, выводятся латиницей.
+var pdfTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "j",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "x", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sh", 'ъ': "'", 'ы': "i", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ў': "o'", 'қ': "q", 'ғ': "g'", 'ҳ': "h",
+}
+
+// pdfEncode приводит строку к WinAnsi: узбекские апострофы — к «'»,
+// кириллица — к латинице, прочие символы вне Latin-1 — к «?».
+func pdfEncode(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		switch {
+		case r == 'ʻ' || r == 'ʼ' || r == '‘' || r == '’' || r == '`':
+			b = append(b, '\'')
+		case r == '«' || r == '»' || r == '“' || r == '”':
+			b = append(b, '"')
+		case r == '№':
+			b = append(b, 'N', 'o')
+		case r == '–' || r == '—':
+			b = append(b, '-')
+		case r < 0x100:
+			b = append(b, byte(r))
+		default:
+			lower := []rune(strings.ToLower(string(r)))[0]
+			t, ok := pdfTranslit[lower]
+			if !ok {
+				b = append(b, '?')
+				continue
+			}
+			if lower != r && t != "" {
+				t = strings.ToUpper(t[:1]) + t[1:]
+			}
+			b = append(b, t...)
+		}
+	}
+	return b
+}
+
+func pdfEscape(s string) string {
+	var out strings.Builder
+	for _, c := range pdfEncode(s) {
+		switch c {
+		case '\\', '(', ')':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// Приблизительная ширина строки Helvetica: точные метрики только для
+// цифр и разделителей, которых достаточно для выравнивания сумм.
+func pdfTextWidth(s string, size float64) float64 {
+	var units float64
+	for _, c := range pdfEncode(s) {
+		switch {
+		case c >= '0' && c <= '9':
+			units += 556
+		case c == ' ' || c == ',' || c == '.':
+			units += 278
+		case c >= 'A' && c <= 'Z':
+			units += 667
+		default:
+			units += 500
+		}
+	}
+	return units * size / 1000
+}
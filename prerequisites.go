@@ -0,0 +1,415 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+/* =========================
+   PREREQUISITES
+========================= */
+
+// Допуск к обучению и к выдаче свидетельства. Требования задаются
+// в справочнике категорий (min_age, medical_required), а справки
+// студента хранятся записями student_prerequisites. Возраст считается
+// по students.birth_date на дату проверки.
+
+const prerequisiteMedical = "medical"
+
+var prerequisiteKinds = []string{prerequisiteMedical}
+
+type StudentPrerequisite struct {
+	ID             int    `json:"id"`
+	StudentJSHSHIR string `json:"student_jshshir"`
+	Kind           string `json:"kind"`
+	Number         string `json:"number"`
+	IssuedBy       string `json:"issued_by"`
+	IssuedAt       string `json:"issued_at,omitempty"`
+	ExpiresAt      string `json:"expires_at"`
+	AttachmentID   *int   `json:"attachment_id,omitempty"`
+	Note           string `json:"note,omitempty"`
+	CreatedBy      string `json:"created_by,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	Valid          bool   `json:"valid"`
+}
+
+type CategoryClearance struct {
+	Code     string   `json:"code"`
+	MinAge   *int     `json:"min_age"`
+	Medical  bool     `json:"medical_required"`
+	Eligible bool     `json:"eligible"`
+	Problems []string `json:"problems"`
+}
+
+type Clearance struct {
+	StudentJSHSHIR string               `json:"student_jshshir"`
+	BirthDate      string               `json:"birth_date"`
+	Age            *int                 `json:"age"`
+	Date           string               `json:"date"`
+	Medical        *StudentPrerequisite `json:"medical"`
+	Eligible       bool                 `json:"eligible"`
+	Problems       []string             `json:"problems"`
+	Categories     []CategoryClearance  `json:"categories"`
+}
+
+const prerequisiteColumns = `
+	id, student_jshshir, kind, number, issued_by,
+	COALESCE(to_char(issued_at, 'YYYY-MM-DD'), ''), to_char(expires_at, 'YYYY-MM-DD'),
+	attachment_id, note, COALESCE(created_by, ''),
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'), expires_at >= CURRENT_DATE`
+
+func scanPrerequisite(row interface{ Scan(...interface{}) error }, p *StudentPrerequisite) error {
+	return row.Scan(&p.ID, &p.StudentJSHSHIR, &p.Kind, &p.Number, &p.IssuedBy,
+		&p.IssuedAt, &p.ExpiresAt, &p.AttachmentID, &p.Note, &p.CreatedBy,
+		&p.CreatedAt, &p.Valid)
+}
+
+func currentDate() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Полных лет на дату on. Дата рождения в базе встречается как
+// YYYY-MM-DD и как DD.MM.YYYY; пустая или нераспознанная — ok=false.
+func studentAge(birth string, on time.Time) (int, bool) {
+	birth = strings.TrimSpace(birth)
+	if len(birth) > 10 {
+		birth = birth[:10]
+	}
+	var b time.Time
+	var err error
+	for _, layout := range []string{"2006-01-02", "02.01.2006"} {
+		if b, err = time.Parse(layout, birth); err == nil {
+			break
+		}
+	}
+	if err != nil || b.After(on) {
+		return 0, false
+	}
+	age := on.Year() - b.Year()
+	if on.Month() < b.Month() || (on.Month() == b.Month() && on.Day() < b.Day()) {
+		age--
+	}
+	return age, true
+}
+
+// Проверяет студента по требованиям категорий на дату on. Справка
+// берётся самая «длинная» из выданных к этой дате.
+func evaluateClearance(jshshir string, codes CategoryList, on time.Time) (Clearance, error) {
+	c := Clearance{
+		StudentJSHSHIR: jshshir,
+		Date:           on.Format("2006-01-02"),
+		Problems:       []string{},
+		Categories:     []CategoryClearance{},
+	}
+
+	err := db.QueryRow(`SELECT COALESCE(birth_date::text, '') FROM students WHERE jshshir=$1`,
+		jshshir).Scan(&c.BirthDate)
+	if err == sql.ErrNoRows {
+		return c, errStudentNotFound
+	} else if err != nil {
+		return c, err
+	}
+	if age, ok := studentAge(c.BirthDate, on); ok {
+		c.Age = &age
+	}
+
+	var medical StudentPrerequisite
+	err = scanPrerequisite(db.QueryRow(`
+		SELECT `+prerequisiteColumns+`
+		FROM student_prerequisites
+		WHERE student_jshshir=$1 AND kind=$2 AND (issued_at IS NULL OR issued_at <= $3)
+		ORDER BY expires_at DESC, id DESC LIMIT 1`,
+		jshshir, prerequisiteMedical, c.Date), &medical)
+	if err == nil {
+		medical.Valid = medical.ExpiresAt >= c.Date
+		c.Medical = &medical
+	} else if err != sql.ErrNoRows {
+		return c, err
+	}
+
+	rows, err := db.Query(`
+		SELECT code, min_age, medical_required FROM machine_categories
+		WHERE code = ANY($1)`, pq.Array([]string(codes)))
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	rules := map[string]CategoryClearance{}
+	for rows.Next() {
+		var cc CategoryClearance
+		if err := rows.Scan(&cc.Code, &cc.MinAge, &cc.Medical); err != nil {
+			return c, err
+		}
+		rules[cc.Code] = cc
+	}
+	if err := rows.Err(); err != nil {
+		return c, err
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		cc, ok := rules[code]
+		if !ok {
+			cc = CategoryClearance{Code: code}
+			cc.Problems = append(cc.Problems, fmt.Sprintf("Noma'lum toifa: %s", code))
+		}
+		if cc.MinAge != nil {
+			switch {
+			case c.Age == nil:
+				cc.Problems = append(cc.Problems, "Tug'ilgan sana ko'rsatilmagan, yoshni tekshirib bo'lmaydi")
+			case *c.Age < *cc.MinAge:
+				cc.Problems = append(cc.Problems, fmt.Sprintf(
+					"%s toifasi uchun kamida %d yosh kerak (talaba %d yoshda)", code, *cc.MinAge, *c.Age))
+			}
+		}
+		if cc.Medical {
+			switch {
+			case c.Medical == nil:
+				cc.Problems = append(cc.Problems, "Tibbiy ma'lumotnoma kiritilmagan")
+			case !c.Medical.Valid:
+				cc.Problems = append(cc.Problems, fmt.Sprintf(
+					"Tibbiy ma'lumotnoma muddati o'tgan (%s)", c.Medical.ExpiresAt))
+			}
+		}
+		cc.Eligible = len(cc.Problems) == 0
+		if cc.Problems == nil {
+			cc.Problems = []string{}
+		}
+		for _, p := range cc.Problems {
+			if !seen[p] {
+				seen[p] = true
+				c.Problems = append(c.Problems, p)
+			}
+		}
+		c.Categories = append(c.Categories, cc)
+	}
+	c.Eligible = len(c.Problems) == 0
+	return c, nil
+}
+
+// Проверка допуска при зачислении в группу и выдаче свидетельства.
+// Возвращает HTTP-код и сообщение для клиента.
+func checkPrerequisites(jshshir string, codes CategoryList, on time.Time) (int, error) {
+	if len(codes) == 0 {
+		return 0, nil
+	}
+
+	if jshshir == "" {
+		var hasRules bool
+		err := db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM machine_categories
+			              WHERE code = ANY($1) AND (min_age IS NOT NULL OR medical_required))`,
+			pq.Array([]string(codes))).Scan(&hasRules)
+		if err != nil {
+			return 500, errors.New("Baza xatosi")
+		}
+		if hasRules {
+			return 400, errors.New("Toifa talablarini tekshirish uchun talaba JShShIR ko'rsatilishi kerak")
+		}
+		return 0, nil
+	}
+
+	c, err := evaluateClearance(jshshir, codes, on)
+	if err == errStudentNotFound {
+		return 404, err
+	} else if err != nil {
+		log.Printf("Talaba talablarini tekshirish xatosi: %v", err)
+		return 500, errors.New("Baza xatosi")
+	}
+	if !c.Eligible {
+		return 409, errors.New("Talaba toifa talablariga javob bermaydi: " + strings.Join(c.Problems, "; "))
+	}
+	return 0, nil
+}
+
+// GET /api/students/{jshshir}/clearance?categories=A,B&date=YYYY-MM-DD
+// Без categories проверяются все активные категории.
+func studentClearance(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	on := currentDate()
+	if d := r.URL.Query().Get("date"); d != "" {
+		t, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			http.Error(w, errBadDate.Error(), 400)
+			return
+		}
+		on = t
+	}
+
+	codes := normalizeCategoryCodes(strings.Split(r.URL.Query().Get("categories"), ","))
+	if len(codes) == 0 {
+		rows, err := db.Query(`SELECT code FROM machine_categories WHERE active ORDER BY code`)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			codes = append(codes, code)
+		}
+	}
+
+	c, err := evaluateClearance(jshshir, codes, on)
+	if err == errStudentNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	respondJSON(w, c)
+}
+
+func studentPrerequisitesList(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	rows, err := db.Query(`
+		SELECT `+prerequisiteColumns+`
+		FROM student_prerequisites
+		WHERE student_jshshir=$1
+		ORDER BY kind, expires_at DESC, id DESC`, jshshir)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []StudentPrerequisite{}
+	for rows.Next() {
+		var p StudentPrerequisite
+		if err := scanPrerequisite(rows, &p); err != nil {
+			log.Printf("Error scanning prerequisite: %v", err)
+			continue
+		}
+		list = append(list, p)
+	}
+
+	respondJSON(w, list)
+}
+
+// POST /api/students/{jshshir}/prerequisites
+// Продлённая справка добавляется новой записью, старая остаётся в истории.
+func studentPrerequisiteCreate(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	var input StudentPrerequisite
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	input.Kind = strings.TrimSpace(input.Kind)
+	if input.Kind == "" {
+		input.Kind = prerequisiteMedical
+	}
+	known := false
+	for _, k := range prerequisiteKinds {
+		known = known || k == input.Kind
+	}
+	if !known {
+		http.Error(w, "Ma'lumotnoma turi noto'g'ri ("+strings.Join(prerequisiteKinds, ", ")+")", 400)
+		return
+	}
+	input.Number = strings.TrimSpace(input.Number)
+	if input.Number == "" {
+		http.Error(w, "Ma'lumotnoma raqami kiritilmagan", 400)
+		return
+	}
+	expires, err := time.Parse("2006-01-02", strings.TrimSpace(input.ExpiresAt))
+	if err != nil {
+		http.Error(w, "Amal qilish muddati noto'g'ri (YYYY-MM-DD)", 400)
+		return
+	}
+	if input.IssuedAt = strings.TrimSpace(input.IssuedAt); input.IssuedAt != "" {
+		issued, err := time.Parse("2006-01-02", input.IssuedAt)
+		if err != nil {
+			http.Error(w, "Berilgan sana noto'g'ri (YYYY-MM-DD)", 400)
+			return
+		}
+		if issued.After(expires) {
+			http.Error(w, "Berilgan sana amal qilish muddatidan keyin bo'lishi mumkin emas", 400)
+			return
+		}
+	}
+
+	var studentExists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, jshshir).Scan(&studentExists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !studentExists {
+		http.Error(w, "Talaba topilmadi", 404)
+		return
+	}
+
+	// Скан справки — вложение этого же студента
+	if input.AttachmentID != nil {
+		var ok bool
+		err := db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM attachments WHERE id=$1 AND owner_type=$2 AND owner_ref=$3)`,
+			*input.AttachmentID, attachmentOwnerStudent, jshshir).Scan(&ok)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Fayl topilmadi", 404)
+			return
+		}
+	}
+
+	var p StudentPrerequisite
+	err = scanPrerequisite(db.QueryRow(`
+		INSERT INTO student_prerequisites
+			(student_jshshir, kind, number, issued_by, issued_at, expires_at, attachment_id, note, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::date, $6, $7, $8, $9)
+		RETURNING `+prerequisiteColumns,
+		jshshir, input.Kind, input.Number, strings.TrimSpace(input.IssuedBy), input.IssuedAt,
+		expires.Format("2006-01-02"), input.AttachmentID, strings.TrimSpace(input.Note),
+		nullIfEmpty(input.CreatedBy),
+	), &p)
+	if err != nil {
+		log.Printf("Ma'lumotnoma saqlash xatosi: %v", err)
+		http.Error(w, "Ma'lumotnoma saqlashda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, p)
+}
+
+func studentPrerequisiteDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM student_prerequisites WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Ma'lumotnoma topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/* =========================
+   PRICE LIST & DISCOUNTS
+========================= */
+
+var serviceKinds = []string{"training", "retake_exam", "certificate_duplicate", "other"}
+
+type Service struct {
+	ID           int    `json:"id"`
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`
+	CategoryCode string `json:"category_code,omitempty"`
+	Price        Money  `json:"price"`
+	Active       bool   `json:"active"`
+	CreatedAt    string `json:"created_at,omitempty"`
+}
+
+// Скидка: процент от суммы или фиксированная сумма. Основание обязательно.
+// Процент записывается так же, как сумма, с точностью до сотых: 12.5.
+type Discount struct {
+	Type   string `json:"type"` // percent | fixed
+	Value  Money  `json:"value"`
+	Reason string `json:"reason"`
+}
+
+type InvoiceItem struct {
+	ID             int       `json:"id,omitempty"`
+	ServiceID      int       `json:"service_id"`
+	Name           string    `json:"name"`
+	Quantity       int       `json:"quantity"`
+	UnitPrice      Money     `json:"unit_price"`
+	Discount       *Discount `json:"discount,omitempty"`
+	DiscountAmount Money     `json:"discount_amount"`
+	Total          Money     `json:"total"`
+}
+
+// Итоги счёта, посчитанные на сервере.
+type InvoiceTotals struct {
+	Items          []InvoiceItem `json:"items"`
+	Subtotal       Money         `json:"subtotal"`
+	Discount       *Discount     `json:"discount,omitempty"`
+	DiscountAmount Money         `json:"discount_amount"`
+	Total          Money         `json:"total"`
+}
+
+func isValidServiceKind(k string) bool {
+	for _, sk := range serviceKinds {
+		if sk == k {
+			return true
+		}
+	}
+	return false
+}
+
+func validateDiscount(d *Discount) error {
+	if d == nil {
+		return nil
+	}
+	d.Reason = strings.TrimSpace(d.Reason)
+	switch d.Type {
+	case "percent":
+		if d.Value <= 0 || d.Value > soums(100) {
+			return errors.New("Chegirma foizi 0 dan 100 gacha bo'lishi kerak")
+		}
+	case "fixed":
+		if d.Value <= 0 {
+			return errors.New("Chegirma summasi musbat bo'lishi kerak")
+		}
+	default:
+		return errors.New("Chegirma turi noto'g'ri (percent, fixed)")
+	}
+	if d.Reason == "" {
+		return errors.New("Chegirma asosi ko'rsatilmagan")
+	}
+	return nil
+}
+
+// Сумма скидки от base, не больше самой base.
+func (d *Discount) amount(base Money) Money {
+	if d == nil {
+		return 0
+	}
+	v := d.Value
+	if d.Type == "percent" {
+		v = base.percent(d.Value)
+	}
+	return minMoney(v, base)
+}
+
+// Подставляет цены из прейскуранта и считает итоги: скидка строки
+// применяется к её сумме, скидка счёта — к сумме строк.
+func priceInvoice(items []InvoiceItem, discount *Discount) (InvoiceTotals, error) {
+	totals := InvoiceTotals{Discount: discount}
+	if len(items) == 0 {
+		return totals, errors.New("Invoyisda kamida bitta xizmat bo'lishi kerak")
+	}
+	if err := validateDiscount(discount); err != nil {
+		return totals, err
+	}
+
+	for _, item := range items {
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		if item.Quantity < 0 {
+			return totals, errors.New("Xizmat soni musbat bo'lishi kerak")
+		}
+		if err := validateDiscount(item.Discount); err != nil {
+			return totals, err
+		}
+
+		var active bool
+		err := db.QueryRow(`SELECT name, price, active FROM services WHERE id=$1`, item.ServiceID).
+			Scan(&item.Name, &item.UnitPrice, &active)
+		if err == sql.ErrNoRows || (err == nil && !active) {
+			return totals, fmt.Errorf("Xizmat topilmadi: %d", item.ServiceID)
+		} else if err != nil {
+			return totals, err
+		}
+
+		gross := item.UnitPrice * Money(item.Quantity)
+		item.DiscountAmount = item.Discount.amount(gross)
+		item.Total = gross - item.DiscountAmount
+		totals.Subtotal += item.Total
+		totals.Items = append(totals.Items, item)
+	}
+
+	totals.DiscountAmount = discount.amount(totals.Subtotal)
+	totals.Total = totals.Subtotal - totals.DiscountAmount
+	if totals.Total <= 0 {
+		return totals, errors.New("Chegirmadan keyin invoyis summasi musbat bo'lishi kerak")
+	}
+	return totals, nil
+}
+
+func discountColumns(d *Discount) (interface{}, interface{}, interface{}) {
+	if d == nil {
+		return nil, nil, nil
+	}
+	return d.Type, d.Value, d.Reason
+}
+
+func insertInvoiceItems(tx *sql.Tx, invoiceID int, items []InvoiceItem) error {
+	for _, item := range items {
+		dType, dValue, dReason := discountColumns(item.Discount)
+		_, err := tx.Exec(`
+			INSERT INTO invoice_items (invoice_id, service_id, name, quantity, unit_price,
+				discount_type, discount_value, discount_reason, discount_amount, total)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			invoiceID, item.ServiceID, item.Name, item.Quantity, item.UnitPrice,
+			dType, dValue, dReason, item.DiscountAmount, item.Total,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanDiscount(dType sql.NullString, dValue Money, dReason sql.NullString) *Discount {
+	if !dType.Valid {
+		return nil
+	}
+	return &Discount{Type: dType.String, Value: dValue, Reason: dReason.String}
+}
+
+func loadInvoiceItems(invoiceID int) ([]InvoiceItem, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(service_id, 0), name, quantity, unit_price,
+			discount_type, discount_value, discount_reason, discount_amount, total
+		FROM invoice_items WHERE invoice_id=$1
+		ORDER BY id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []InvoiceItem{}
+	for rows.Next() {
+		var item InvoiceItem
+		var dType, dReason sql.NullString
+		var dValue Money
+		if err := rows.Scan(&item.ID, &item.ServiceID, &item.Name, &item.Quantity, &item.UnitPrice,
+			&dType, &dValue, &dReason, &item.DiscountAmount, &item.Total); err != nil {
+			return nil, err
+		}
+		item.Discount = scanDiscount(dType, dValue, dReason)
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
+
+// Скидка на весь счёт, если была.
+func loadInvoiceDiscount(invoiceID int) (*Discount, Money, error) {
+	var dType, dReason sql.NullString
+	var dValue, amount Money
+	err := db.QueryRow(`
+		SELECT discount_type, discount_value, discount_reason, discount_amount
+		FROM invoices WHERE id=$1`, invoiceID,
+	).Scan(&dType, &dValue, &dReason, &amount)
+	if err != nil {
+		return nil, 0, err
+	}
+	return scanDiscount(dType, dValue, dReason), amount, nil
+}
+
+// Предварительный расчёт без сохранения — для формы счёта.
+func invoiceQuote(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Items    []InvoiceItem `json:"items"`
+		Discount *Discount     `json:"discount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+
+	totals, err := priceInvoice(input.Items, input.Discount)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	respondJSON(w, totals)
+}
+
+const serviceColumns = `id, code, name, kind, COALESCE(category_code, ''), price, active,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanService(row interface{ Scan(...interface{}) error }, s *Service) error {
+	return row.Scan(&s.ID, &s.Code, &s.Name, &s.Kind, &s.CategoryCode, &s.Price, &s.Active, &s.CreatedAt)
+}
+
+func servicesList(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + serviceColumns + ` FROM services`
+	if r.URL.Query().Get("all") == "" {
+		query += ` WHERE active`
+	}
+	query += ` ORDER BY kind, code`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []Service{}
+	for rows.Next() {
+		var s Service
+		if err := scanService(rows, &s); err != nil {
+			log.Printf("Error scanning service: %v", err)
+			continue
+		}
+		list = append(list, s)
+	}
+
+	respondJSON(w, list)
+}
+
+func serviceGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri xizmat ID", 400)
+		return
+	}
+
+	var s Service
+	if err := scanService(db.QueryRow(`SELECT `+serviceColumns+` FROM services WHERE id=$1`, id), &s); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Xizmat topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	respondJSON(w, s)
+}
+
+func decodeService(r *http.Request) (Service, error) {
+	input := Service{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return input, errors.New("Noto'g'ri ma'lumot")
+	}
+	input.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+	input.Name = strings.TrimSpace(input.Name)
+	input.CategoryCode = strings.ToUpper(strings.TrimSpace(input.CategoryCode))
+
+	if input.Code == "" || input.Name == "" {
+		return input, errors.New("Xizmat kodi va nomi kiritilishi shart")
+	}
+	if !isValidServiceKind(input.Kind) {
+		return input, fmt.Errorf("Xizmat turi noto'g'ri (%s)", strings.Join(serviceKinds, ", "))
+	}
+	if input.Kind == "training" && input.CategoryCode == "" {
+		return input, errors.New("O'qitish xizmati uchun toifa ko'rsatilishi kerak")
+	}
+	if input.CategoryCode != "" {
+		if err := checkCategoryCodes(CategoryList{input.CategoryCode}); err != nil {
+			return input, err
+		}
+	}
+	if input.Price <= 0 {
+		return input, errors.New("Narx musbat bo'lishi kerak")
+	}
+	return input, nil
+}
+
+func serviceCreate(w http.ResponseWriter, r *http.Request) {
+	input, err := decodeService(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO services (code, name, kind, category_code, price, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		input.Code, input.Name, input.Kind, nullIfEmpty(input.CategoryCode), input.Price, input.Active,
+	).Scan(&input.ID)
+	if err != nil {
+		log.Printf("Xizmat yaratish xatosi: %v", err)
+		http.Error(w, "Xizmat yaratishda xatolik: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, input)
+}
+
+// Изменение цены не трогает уже выставленные счета: цена копируется
+// в строку счёта при его создании.
+func serviceUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri xizmat ID", 400)
+		return
+	}
+
+	input, err := decodeService(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE services
+		SET code=$1, name=$2, kind=$3, category_code=$4, price=$5, active=$6
+		WHERE id=$7`,
+		input.Code, input.Name, input.Kind, nullIfEmpty(input.CategoryCode), input.Price, input.Active, id,
+	)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Xizmat topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "updated"})
+}
+
+func serviceDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri xizmat ID", 400)
+		return
+	}
+
+	var used bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM invoice_items WHERE service_id=$1)`, id).Scan(&used); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if used {
+		http.Error(w, "Xizmat invoyislarda ishlatilgan, uni faqat nofaol qilish mumkin", 409)
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM services WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Xizmat topilmadi", 404)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
@@ -172,19 +172,9 @@ async function addDocument(event) {
     const jshshir = document.getElementById('jshshir').value.trim();
     const studentName = document.getElementById('studentName').value.trim();
     
-    // Получаем номер комиссии (из HTML поле с id="commissionNumber")
-    const commissionNumberInput = document.getElementById('commissionNumber');
-    const commissionNumber = commissionNumberInput ? commissionNumberInput.value.trim() : '';
-    
-    // Получаем имя директора (из двух полей в HTML)
-    const directorNameInput = document.getElementById('directorName');
-    const directorSurnameInput = document.getElementById('directorSurname');
-    
-    let directorName = '';
-    if (directorNameInput && directorSurnameInput) {
-        // Объединяем имя и фамилию директора
-        directorName = `${directorNameInput.value.trim()} ${directorSurnameInput.value.trim()}`.trim();
-    }
+    // Комиссия и сессия — из записей; номер приказа и директора подставит сервер
+    const commissionId = parseInt(document.getElementById('commissionId')?.value) || 0;
+    const sessionId = parseInt(document.getElementById('sessionId')?.value) || 0;
     
     // Проверки полей
     if (!jshshir || jshshir.length !== 14) {
@@ -197,8 +187,8 @@ async function addDocument(event) {
         return;
     }
     
-    if (!commissionNumber) {
-        showError('Imtihon komissiyasi raqamini kiriting!');
+    if (!commissionId) {
+        showError('Imtihon komissiyasini tanlang!');
         return;
     }
     
@@ -241,9 +231,8 @@ async function addDocument(event) {
         grade2: parseInt(grade2),
         certificate_number: "",
         status: "active",
-        // ИСПРАВЛЕНО: берем данные из формы
-        commission_number: commissionNumber,  // Из поля с id="commissionNumber"
-        director_name: directorName || 'Y. Usmonova'  // Объединенное имя и фамилия
+        commission_id: commissionId,
+        session_id: sessionId
     };
     
     console.log('Sending document data:', documentData);
@@ -418,6 +407,100 @@ function updateDocumentCount(documents) {
 }
 
 
+// Выбор комиссии и сессии в формах добавления и редактирования.
+// Директор показывается из комиссии и не редактируется; если выбрана
+// сессия, дату экзамена и оценки сервер берёт из её результатов.
+// selected: { commissionId, sessionId, orderNumber } — для старых
+// документов без commission_id комиссия ищется по номеру приказа.
+async function setupCommissionPicker(ids, selected = {}) {
+    const commissionSelect = document.getElementById(ids.commission);
+    const sessionSelect = document.getElementById(ids.session);
+    if (!commissionSelect) return;
+
+    try {
+        const response = await fetch('/api/commissions');
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        const commissions = await response.json();
+
+        let selectedId = selected.commissionId || 0;
+        if (!selectedId && selected.orderNumber) {
+            const match = commissions.find(c => c.order_number === selected.orderNumber);
+            if (match) selectedId = match.id;
+        }
+
+        commissionSelect.innerHTML = '<option value="">Komissiyani tanlang...</option>';
+        commissions
+            .filter(c => c.active || c.id === selectedId)
+            .forEach(c => {
+                const option = document.createElement('option');
+                option.value = c.id;
+                option.textContent = `№ ${c.order_number}` + (c.order_date ? ` (${c.order_date})` : '');
+                option.dataset.director = c.director_name || '';
+                commissionSelect.appendChild(option);
+            });
+        if (selectedId) commissionSelect.value = selectedId;
+    } catch (error) {
+        console.error('Commissions load error:', error);
+        showError('Komissiyalar ro\'yxatini yuklashda xatolik: ' + error.message);
+    }
+
+    const fillDirector = () => {
+        const parts = (commissionSelect.selectedOptions[0]?.dataset.director || '').split(' ');
+        const nameInput = document.getElementById(ids.directorName);
+        const surnameInput = document.getElementById(ids.directorSurname);
+        if (nameInput) {
+            nameInput.value = parts[0] || '';
+            nameInput.readOnly = true;
+        }
+        if (surnameInput) {
+            surnameInput.value = parts.slice(1).join(' ');
+            surnameInput.readOnly = true;
+        }
+    };
+
+    const loadSessions = async () => {
+        if (!sessionSelect) return;
+        sessionSelect.innerHTML = '<option value="">Sessiyasiz</option>';
+        if (!commissionSelect.value) return;
+        try {
+            const response = await fetch(`/api/exam-sessions?commission_id=${commissionSelect.value}`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}`);
+            }
+            const sessions = await response.json();
+            sessions.forEach(session => {
+                const option = document.createElement('option');
+                option.value = session.id;
+                option.textContent = session.exam_date + (session.location ? ` — ${session.location}` : '');
+                option.dataset.examDate = session.exam_date;
+                sessionSelect.appendChild(option);
+            });
+            if (selected.sessionId) sessionSelect.value = selected.sessionId;
+        } catch (error) {
+            console.error('Sessions load error:', error);
+        }
+    };
+
+    commissionSelect.addEventListener('change', () => {
+        selected.sessionId = 0;
+        fillDirector();
+        loadSessions();
+    });
+    if (sessionSelect) {
+        sessionSelect.addEventListener('change', () => {
+            const examDate = sessionSelect.selectedOptions[0]?.dataset.examDate;
+            const examInput = document.getElementById(ids.examDate);
+            if (examDate && examInput) examInput.value = examDate;
+        });
+    }
+
+    fillDirector();
+    await loadSessions();
+}
+
+
 // Инициализация
 document.addEventListener('DOMContentLoaded', function() {
     // Загрузка документов для списка
@@ -429,6 +512,13 @@ document.addEventListener('DOMContentLoaded', function() {
     const addForm = document.getElementById('addCertificateForm');
     if (addForm) {
         addForm.addEventListener('submit', addDocument);
+        setupCommissionPicker({
+            commission: 'commissionId',
+            session: 'sessionId',
+            examDate: 'examDate',
+            directorName: 'directorName',
+            directorSurname: 'directorSurname'
+        });
     }
 });
 
@@ -440,6 +530,7 @@ window.viewCertificate = viewCertificate;
 window.editCertificate = editCertificate;
 window.printCertificate = printCertificate;
 window.showQR = showQR;
+window.setupCommissionPicker = setupCommissionPicker;
 
 
 
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   REFUNDS & CREDIT NOTES
+========================= */
+
+// Роли сотрудников, которые могут подтверждать операции.
+const (
+	roleDirector   = "director"
+	roleAccountant = "accountant"
+	roleCashier    = "cashier"
+)
+
+// Возврат денег оформляет только директор или бухгалтер.
+var refundRoles = []string{roleDirector, roleAccountant}
+
+// Кредит-нота оформляет возврат по конкретному платежу: уменьшает
+// и начисление по счёту, и оплаченную сумму, поэтому долг по счёту
+// не меняется, а в выписке студента видно, что деньги возвращены.
+type CreditNote struct {
+	ID             int    `json:"id"`
+	Number         string `json:"number"`
+	InvoiceID      int    `json:"invoice_id"`
+	InvoiceNumber  string `json:"invoice_number,omitempty"`
+	PaymentID      int    `json:"payment_id"`
+	StudentJSHSHIR string `json:"student_jshshir,omitempty"`
+	StudentName    string `json:"student_name,omitempty"`
+	Amount         Money  `json:"amount"`
+	Reason         string `json:"reason"`
+	IssuedBy       string `json:"issued_by"`
+	Role           string `json:"role"`
+	IssuedAt       string `json:"issued_at"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type StudentBalance struct {
+	StudentJSHSHIR string `json:"student_jshshir"`
+	Charged        Money  `json:"charged"`
+	Credited       Money  `json:"credited"`
+	Paid           Money  `json:"paid"`
+	Refunded       Money  `json:"refunded"`
+	Balance        Money  `json:"balance"` // > 0 — студент должен
+}
+
+// Сумма кредит-нот по счёту i.
+const invoiceCreditSQL = `COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn WHERE cn.invoice_id = i.id), 0)`
+
+func hasRole(role string, allowed []string) bool {
+	for _, r := range allowed {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRole(role string, allowed []string) error {
+	if !hasRole(role, allowed) {
+		return fmt.Errorf("Bu amal uchun ruxsat yo'q (kerakli rol: %s)", strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// Кредит-нота уменьшает последние части графика: именно они
+// ещё не наступили или были оплачены последними.
+func applyCredit(list []Installment, credit Money) {
+	for i := len(list) - 1; i >= 0 && credit > 0; i-- {
+		cut := minMoney(list[i].Amount, credit)
+		list[i].Amount -= cut
+		credit -= cut
+	}
+}
+
+func creditNoteNumber(id int) string {
+	return fmt.Sprintf("CN-%06d", id)
+}
+
+const creditNoteColumns = `
+	cn.id, cn.invoice_id, COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')),
+	cn.payment_id, i.student_jshshir, COALESCE(i.student_name, ''),
+	cn.amount, cn.reason, cn.issued_by, cn.role,
+	to_char(cn.issued_at, 'YYYY-MM-DD'), to_char(cn.created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+const creditNoteFrom = ` FROM credit_notes cn JOIN invoices i ON i.id = cn.invoice_id`
+
+func scanCreditNote(row interface{ Scan(...interface{}) error }, c *CreditNote) error {
+	err := row.Scan(&c.ID, &c.InvoiceID, &c.InvoiceNumber, &c.PaymentID, &c.StudentJSHSHIR,
+		&c.StudentName, &c.Amount, &c.Reason, &c.IssuedBy, &c.Role, &c.IssuedAt, &c.CreatedAt)
+	c.Number = creditNoteNumber(c.ID)
+	return err
+}
+
+func queryCreditNotes(where string, args ...interface{}) ([]CreditNote, error) {
+	rows, err := db.Query(`SELECT `+creditNoteColumns+creditNoteFrom+` WHERE `+where+
+		` ORDER BY cn.issued_at, cn.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []CreditNote{}
+	for rows.Next() {
+		var c CreditNote
+		if err := scanCreditNote(rows, &c); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// POST /api/payments/{id}/refund
+func refundCreate(w http.ResponseWriter, r *http.Request) {
+	paymentID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid payment ID", 400)
+		return
+	}
+
+	var c CreditNote
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", 400)
+		return
+	}
+	c.Reason = strings.TrimSpace(c.Reason)
+	c.IssuedBy = strings.TrimSpace(c.IssuedBy)
+	if c.Amount <= 0 {
+		http.Error(w, "Qaytarish summasi musbat bo'lishi kerak", 400)
+		return
+	}
+	if c.Reason == "" {
+		http.Error(w, "Qaytarish sababi ko'rsatilmagan", 400)
+		return
+	}
+	if c.IssuedBy == "" {
+		http.Error(w, "Qaytarishni rasmiylashtirgan shaxs ko'rsatilmagan", 400)
+		return
+	}
+	if err := checkRole(c.Role, refundRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := addCreditNote(tx, paymentID, &c); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "To'lov topilmadi", 404)
+		} else {
+			http.Error(w, err.Error(), 400)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Printf("Qaytarish rasmiylashtirildi: %s, to'lov %d, summa %s, %s (%s)",
+		c.Number, paymentID, c.Amount, c.IssuedBy, c.Role)
+
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, c)
+}
+
+// Записывает кредит-ноту; сумма не больше невозвращённой части платежа.
+func addCreditNote(tx *sql.Tx, paymentID int, c *CreditNote) error {
+	var paid, refunded Money
+	err := tx.QueryRow(`
+		SELECT p.invoice_id, p.amount,
+			COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn WHERE cn.payment_id = p.id), 0)
+		FROM payments p WHERE p.id=$1
+		FOR UPDATE OF p`, paymentID,
+	).Scan(&c.InvoiceID, &paid, &refunded)
+	if err != nil {
+		return err
+	}
+	if c.Amount > paid-refunded {
+		return fmt.Errorf("Qaytarish summasi to'lovdan oshib ketdi (qolgan: %s)", paid-refunded)
+	}
+
+	c.PaymentID = paymentID
+	err = tx.QueryRow(`
+		INSERT INTO credit_notes (invoice_id, payment_id, amount, reason, issued_by, role)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, to_char(issued_at, 'YYYY-MM-DD'), to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+		c.InvoiceID, c.PaymentID, c.Amount, c.Reason, c.IssuedBy, c.Role,
+	).Scan(&c.ID, &c.IssuedAt, &c.CreatedAt)
+	if err != nil {
+		return err
+	}
+	c.Number = creditNoteNumber(c.ID)
+
+	return recalcInvoice(tx, c.InvoiceID)
+}
+
+// GET /api/credit-notes?from=&to=
+func creditNotesList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	list, err := queryCreditNotes(`($1::date IS NULL OR cn.issued_at >= $1::date)
+		AND ($2::date IS NULL OR cn.issued_at <= $2::date)`,
+		nullIfEmpty(q.Get("from")), nullIfEmpty(q.Get("to")))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, list)
+}
+
+func invoiceCreditNotesList(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", 400)
+		return
+	}
+
+	list, err := queryCreditNotes(`cn.invoice_id = $1`, invoiceID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, list)
+}
+
+var errStudentNotFound = errors.New("Talaba topilmadi")
+
+// Баланс студента по всем его счетам.
+func loadStudentBalance(jshshir string) (StudentBalance, error) {
+	b := StudentBalance{StudentJSHSHIR: jshshir}
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM students WHERE jshshir=$1)`, jshshir).Scan(&exists); err != nil {
+		return b, err
+	}
+	if !exists {
+		return b, errStudentNotFound
+	}
+
+	// По отменённому счёту начислением считается только оплаченное,
+	// поэтому он не создаёт долга.
+	err := db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN i.status = $2 THEN x.paid ELSE i.amount END), 0),
+			COALESCE(SUM(x.credit), 0),
+			COALESCE(SUM(x.paid), 0)
+		FROM invoices i,
+		LATERAL (SELECT
+			COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) AS paid,
+			`+invoiceCreditSQL+` AS credit) x
+		WHERE i.student_jshshir = $1`,
+		jshshir, invoiceStatusCancelled,
+	).Scan(&b.Charged, &b.Credited, &b.Paid)
+	if err != nil {
+		return b, err
+	}
+	b.Refunded = b.Credited
+	b.Balance = b.Charged - b.Credited - (b.Paid - b.Refunded)
+	return b, nil
+}
+
+func studentBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+
+	b, err := loadStudentBalance(jshshir)
+	if err == errStudentNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, b)
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   REGISTRY EXPORT
+========================= */
+
+// Выгрузка выданных свидетельств за период для инспекции (ежеквартальный
+// отчёт). Файл (XML или JSON) строится по схеме полей — какие данные
+// документа и под каким именем попадают в запись. Схемы хранятся
+// в registry_mappings; если схемы нет, используется defaultRegistryMapping.
+//
+// Каждая выгрузка сохраняется целиком в registry_exports: файл можно
+// скачать повторно байт в байт. Для файла считается SHA-256 и подпись
+// Ed25519; инспекция проверяет её открытым ключом из
+// GET /api/registry/public-key (или go run ./cmd/registrykey -verify).
+//
+//	REGISTRY_SIGNING_KEY — закрытый ключ Ed25519 (base64, seed 32 байта
+//	                       или ключ 64 байта); создать: go run ./cmd/registrykey.
+
+const (
+	registryFormatXML  = "xml"
+	registryFormatJSON = "json"
+)
+
+var errRegistryKeyMissing = errors.New("REGISTRY_SIGNING_KEY sozlanmagan: kalitni go run ./cmd/registrykey bilan yarating")
+
+// Поля документа, доступные для схемы.
+var registrySources = []struct {
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}{
+	{"certificate_number", "Guvohnoma raqami"},
+	{"jshshir", "JShShIR"},
+	{"student_name", "F.I.Sh."},
+	{"birth_date", "Tug'ilgan sana"},
+	{"categories", "Toifalar (vergul bilan)"},
+	{"course_hours", "O'quv soatlari"},
+	{"course_start", "O'qish boshlangan sana"},
+	{"course_end", "O'qish tugagan sana"},
+	{"commission_number", "Komissiya bayonnomasi raqami"},
+	{"exam_date", "Imtihon sanasi"},
+	{"final_score", "Yakuniy baho"},
+	{"issue_date", "Berilgan sana"},
+	{"expires_at", "Amal qilish muddati"},
+	{"director_name", "Direktor"},
+	{"status", "Holati: active, expired, revoked"},
+}
+
+type RegistryField struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+type RegistryMapping struct {
+	Name      string          `json:"name"`
+	Root      string          `json:"root"`
+	Record    string          `json:"record"`
+	Fields    []RegistryField `json:"fields"`
+	UpdatedBy string          `json:"updated_by,omitempty"`
+	UpdatedAt string          `json:"updated_at,omitempty"`
+}
+
+var defaultRegistryMapping = RegistryMapping{
+	Name:   "default",
+	Root:   "CertificateRegistry",
+	Record: "Certificate",
+	Fields: []RegistryField{
+		{"CertificateNumber", "certificate_number"},
+		{"PINFL", "jshshir"},
+		{"FullName", "student_name"},
+		{"BirthDate", "birth_date"},
+		{"Categories", "categories"},
+		{"TrainingHours", "course_hours"},
+		{"CommissionNumber", "commission_number"},
+		{"ExamDate", "exam_date"},
+		{"IssueDate", "issue_date"},
+		{"Status", "status"},
+	},
+}
+
+// Имя годится и для XML-элемента, и для ключа JSON.
+var registryNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]{0,63}$`)
+
+func isRegistrySource(s string) bool {
+	for _, src := range registrySources {
+		if src.Source == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRegistryMapping(m *RegistryMapping) error {
+	m.Name = strings.TrimSpace(m.Name)
+	if !registryNameRe.MatchString(m.Name) {
+		return errors.New("Sxema nomi noto'g'ri (lotin harflari, raqamlar, _ . -)")
+	}
+	if !registryNameRe.MatchString(m.Root) || !registryNameRe.MatchString(m.Record) {
+		return errors.New("root va record nomlari noto'g'ri (lotin harflari, raqamlar, _ . -)")
+	}
+	if len(m.Fields) == 0 {
+		return errors.New("Sxemada kamida bitta maydon bo'lishi kerak")
+	}
+	seen := map[string]bool{}
+	for _, f := range m.Fields {
+		if !registryNameRe.MatchString(f.Name) {
+			return fmt.Errorf("Maydon nomi noto'g'ri: %q", f.Name)
+		}
+		if !isRegistrySource(f.Source) {
+			return fmt.Errorf("Noma'lum manba maydoni: %q", f.Source)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("Maydon nomi takrorlangan: %q", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+func loadRegistryMapping(name string) (RegistryMapping, error) {
+	if name == "" {
+		name = defaultRegistryMapping.Name
+	}
+	var m RegistryMapping
+	var fields string
+	err := db.QueryRow(`
+		SELECT name, root, record, fields::text, COALESCE(updated_by, ''),
+			to_char(updated_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM registry_mappings WHERE name=$1`, name,
+	).Scan(&m.Name, &m.Root, &m.Record, &fields, &m.UpdatedBy, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		if name == defaultRegistryMapping.Name {
+			return defaultRegistryMapping, nil
+		}
+		return m, fmt.Errorf("Sxema topilmadi: %s", name)
+	} else if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal([]byte(fields), &m.Fields)
+	return m, err
+}
+
+/* ---------- подпись ---------- */
+
+func registrySigningKey() (ed25519.PrivateKey, error) {
+	raw := strings.TrimSpace(os.Getenv("REGISTRY_SIGNING_KEY"))
+	if raw == "" {
+		return nil, errRegistryKeyMissing
+	}
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("REGISTRY_SIGNING_KEY base64 emas: %v", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("REGISTRY_SIGNING_KEY uzunligi noto'g'ri: %d bayt", len(b))
+}
+
+// Отпечаток ключа: первые 8 байт SHA-256 открытого ключа.
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+/* ---------- записи ---------- */
+
+type registryRecord map[string]interface{}
+
+func loadRegistryRecords(from, to string) ([]registryRecord, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(d.certificate_number, ''), COALESCE(d.student_jshshir, ''),
+			COALESCE(d.student_name, ''), COALESCE(s.birth_date::text, ''),
+			COALESCE(array_to_string(d.category_codes, ','), d.categories, ''),
+			COALESCE(d.course_hours, 0), COALESCE(d.course_start::text, ''), COALESCE(d.course_end::text, ''),
+			COALESCE(d.commission_number, ''), COALESCE(d.exam_date::text, ''),
+			COALESCE(d.final_score, 0), to_char(d.created_at, 'YYYY-MM-DD'),
+			COALESCE(to_char(d.expires_at, 'YYYY-MM-DD'), ''), COALESCE(d.director_name, ''),
+			CASE WHEN d.revoked_at IS NOT NULL THEN 'revoked'
+				WHEN d.expired THEN 'expired' ELSE 'active' END
+		FROM documents d
+		LEFT JOIN students s ON s.jshshir = d.student_jshshir
+		WHERE d.created_at::date BETWEEN $1 AND $2
+		ORDER BY d.created_at, d.id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []registryRecord{}
+	for rows.Next() {
+		var certNo, jshshir, name, birth, categories, start, end, commission, exam string
+		var issued, expires, director, status string
+		var hours int
+		var score float64
+		if err := rows.Scan(&certNo, &jshshir, &name, &birth, &categories, &hours, &start, &end,
+			&commission, &exam, &score, &issued, &expires, &director, &status); err != nil {
+			return nil, err
+		}
+		list = append(list, registryRecord{
+			"certificate_number": certNo,
+			"jshshir":            jshshir,
+			"student_name":       name,
+			"birth_date":         birth,
+			"categories":         categories,
+			"course_hours":       hours,
+			"course_start":       start,
+			"course_end":         end,
+			"commission_number":  commission,
+			"exam_date":          exam,
+			"final_score":        score,
+			"issue_date":         issued,
+			"expires_at":         expires,
+			"director_name":      director,
+			"status":             status,
+		})
+	}
+	return list, rows.Err()
+}
+
+type registryHeader struct {
+	BatchNumber  string
+	Organization string
+	INN          string
+	PeriodFrom   string
+	PeriodTo     string
+	GeneratedAt  string
+	RecordCount  int
+}
+
+func registryHeaderFields(h registryHeader) [][2]string {
+	return [][2]string{
+		{"BatchNumber", h.BatchNumber},
+		{"Organization", h.Organization},
+		{"INN", h.INN},
+		{"PeriodFrom", h.PeriodFrom},
+		{"PeriodTo", h.PeriodTo},
+		{"GeneratedAt", h.GeneratedAt},
+		{"RecordCount", fmt.Sprint(h.RecordCount)},
+	}
+}
+
+func renderRegistryXML(m RegistryMapping, h registryHeader, records []registryRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	start := func(name string) error { return enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}}) }
+	end := func(name string) error { return enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}}) }
+	leaf := func(name, value string) error {
+		return enc.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: name}})
+	}
+
+	if err := start(m.Root); err != nil {
+		return nil, err
+	}
+	start("Header")
+	for _, f := range registryHeaderFields(h) {
+		leaf(f[0], f[1])
+	}
+	end("Header")
+	start("Records")
+	for _, rec := range records {
+		start(m.Record)
+		for _, f := range m.Fields {
+			leaf(f.Name, fmt.Sprint(rec[f.Source]))
+		}
+		end(m.Record)
+	}
+	end("Records")
+	if err := end(m.Root); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// JSON с полями в порядке схемы (map в encoding/json сортируется).
+func renderRegistryJSON(m RegistryMapping, h registryHeader, records []registryRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	writeObject := func(indent string, pairs [][2]interface{}) error {
+		buf.WriteString("{\n")
+		for i, p := range pairs {
+			k, _ := json.Marshal(p[0])
+			v, err := json.Marshal(p[1])
+			if err != nil {
+				return err
+			}
+			buf.WriteString(indent + "  ")
+			buf.Write(k)
+			buf.WriteString(": ")
+			buf.Write(v)
+			if i < len(pairs)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+		return nil
+	}
+
+	root, _ := json.Marshal(m.Root)
+	buf.WriteString("{\n  ")
+	buf.Write(root)
+	buf.WriteString(": {\n    \"Header\": ")
+	var header [][2]interface{}
+	for _, f := range registryHeaderFields(h) {
+		header = append(header, [2]interface{}{f[0], f[1]})
+	}
+	header[len(header)-1][1] = h.RecordCount
+	if err := writeObject("    ", header); err != nil {
+		return nil, err
+	}
+	buf.WriteString(",\n    \"Records\": [")
+	for i, rec := range records {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("\n      ")
+		var pairs [][2]interface{}
+		for _, f := range m.Fields {
+			pairs = append(pairs, [2]interface{}{f.Name, rec[f.Source]})
+		}
+		if err := writeObject("      ", pairs); err != nil {
+			return nil, err
+		}
+	}
+	if len(records) > 0 {
+		buf.WriteString("\n    ")
+	}
+	buf.WriteString("]\n  }\n}\n")
+	return buf.Bytes(), nil
+}
+
+/* ---------- выгрузки ---------- */
+
+type RegistryExport struct {
+	ID             int    `json:"id"`
+	BatchNumber    string `json:"batch_number"`
+	PeriodFrom     string `json:"period_from"`
+	PeriodTo       string `json:"period_to"`
+	Format         string `json:"format"`
+	Mapping        string `json:"mapping"`
+	RecordCount    int    `json:"record_count"`
+	Size           int    `json:"size"`
+	SHA256         string `json:"sha256"`
+	Signature      string `json:"signature"`
+	KeyFingerprint string `json:"key_fingerprint"`
+	CreatedBy      string `json:"created_by,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func registryBatchNumber(id int, created time.Time) string {
+	return fmt.Sprintf("REG-%d-%05d", created.Year(), id)
+}
+
+func registryFilename(e RegistryExport) string {
+	return fmt.Sprintf("%s_%s_%s.%s", e.BatchNumber, e.PeriodFrom, e.PeriodTo, e.Format)
+}
+
+const registryExportColumns = `
+	id, batch_number, period_from::text, period_to::text, format, mapping, record_count,
+	size, sha256, signature, key_fingerprint, COALESCE(created_by, ''),
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func scanRegistryExport(row interface{ Scan(...interface{}) error }, e *RegistryExport) error {
+	return row.Scan(&e.ID, &e.BatchNumber, &e.PeriodFrom, &e.PeriodTo, &e.Format, &e.Mapping,
+		&e.RecordCount, &e.Size, &e.SHA256, &e.Signature, &e.KeyFingerprint, &e.CreatedBy, &e.CreatedAt)
+}
+
+type RegistryExportRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Format    string `json:"format"`
+	Mapping   string `json:"mapping"`
+	CreatedBy string `json:"created_by"`
+}
+
+// POST /api/registry/exports {from, to, format, mapping, created_by}
+func registryExportCreate(w http.ResponseWriter, r *http.Request) {
+	var req RegistryExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	f, err1 := time.Parse("2006-01-02", req.From)
+	t, err2 := time.Parse("2006-01-02", req.To)
+	if err1 != nil || err2 != nil {
+		http.Error(w, errBadDate.Error(), 400)
+		return
+	}
+	if t.Before(f) {
+		http.Error(w, errBadPeriod.Error(), 400)
+		return
+	}
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if req.Format == "" {
+		req.Format = registryFormatXML
+	}
+	if req.Format != registryFormatXML && req.Format != registryFormatJSON {
+		http.Error(w, "Format: xml yoki json", 400)
+		return
+	}
+	mapping, err := loadRegistryMapping(strings.TrimSpace(req.Mapping))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	key, err := registrySigningKey()
+	if err != nil {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+
+	records, err := loadRegistryRecords(req.From, req.To)
+	if err != nil {
+		log.Printf("Reyestr yozuvlarini olish xatosi: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	// id нужен для номера пакета, который записывается в сам файл
+	now := time.Now()
+	var id int
+	if err := tx.QueryRow(`SELECT nextval(pg_get_serial_sequence('registry_exports', 'id'))`).Scan(&id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	org := loadOrganization()
+	header := registryHeader{
+		BatchNumber:  registryBatchNumber(id, now),
+		Organization: org.Name,
+		INN:          org.INN,
+		PeriodFrom:   req.From,
+		PeriodTo:     req.To,
+		GeneratedAt:  now.Format(time.RFC3339),
+		RecordCount:  len(records),
+	}
+	var file []byte
+	if req.Format == registryFormatXML {
+		file, err = renderRegistryXML(mapping, header, records)
+	} else {
+		file, err = renderRegistryJSON(mapping, header, records)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	sum := sha256.Sum256(file)
+	e := RegistryExport{
+		ID:             id,
+		BatchNumber:    header.BatchNumber,
+		PeriodFrom:     req.From,
+		PeriodTo:       req.To,
+		Format:         req.Format,
+		Mapping:        mapping.Name,
+		RecordCount:    len(records),
+		Size:           len(file),
+		SHA256:         hex.EncodeToString(sum[:]),
+		Signature:      base64.StdEncoding.EncodeToString(ed25519.Sign(key, file)),
+		KeyFingerprint: keyFingerprint(key.Public().(ed25519.PublicKey)),
+		CreatedBy:      strings.TrimSpace(req.CreatedBy),
+	}
+	err = tx.QueryRow(`
+		INSERT INTO registry_exports
+			(id, batch_number, period_from, period_to, format, mapping, record_count,
+			 size, sha256, signature, key_fingerprint, content, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+		e.ID, e.BatchNumber, e.PeriodFrom, e.PeriodTo, e.Format, e.Mapping, e.RecordCount,
+		e.Size, e.SHA256, e.Signature, e.KeyFingerprint, file, nullIfEmpty(e.CreatedBy), now,
+	).Scan(&e.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Printf("📤 Reyestr %s: %s — %s, %d ta guvohnoma (%s)", e.BatchNumber, e.PeriodFrom, e.PeriodTo, e.RecordCount, e.Format)
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, e)
+}
+
+// GET /api/registry/exports
+func registryExportsList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT ` + registryExportColumns + ` FROM registry_exports ORDER BY id DESC`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []RegistryExport{}
+	for rows.Next() {
+		var e RegistryExport
+		if err := scanRegistryExport(rows, &e); err != nil {
+			log.Printf("Error scanning registry export: %v", err)
+			continue
+		}
+		list = append(list, e)
+	}
+	respondJSON(w, list)
+}
+
+func loadRegistryExport(r *http.Request) (RegistryExport, int, error) {
+	var e RegistryExport
+	id, err := pathID(r, "id")
+	if err != nil {
+		return e, 400, errors.New("Noto'g'ri ID")
+	}
+	err = scanRegistryExport(db.QueryRow(`SELECT `+registryExportColumns+` FROM registry_exports WHERE id=$1`, id), &e)
+	if err == sql.ErrNoRows {
+		return e, 404, errors.New("Eksport topilmadi")
+	} else if err != nil {
+		return e, 500, err
+	}
+	return e, 200, nil
+}
+
+// GET /api/registry/exports/{id} — паспорт выгрузки: контрольная сумма и подпись.
+func registryExportGet(w http.ResponseWriter, r *http.Request) {
+	e, code, err := loadRegistryExport(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	respondJSON(w, e)
+}
+
+// GET /api/registry/exports/{id}/file — файл выгрузки; сумма и подпись
+// также в заголовках.
+func registryExportFile(w http.ResponseWriter, r *http.Request) {
+	e, code, err := loadRegistryExport(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	var content []byte
+	if err := db.QueryRow(`SELECT content FROM registry_exports WHERE id=$1`, e.ID).Scan(&content); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ct := "application/xml; charset=utf-8"
+	if e.Format == registryFormatJSON {
+		ct = "application/json; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, registryFilename(e)))
+	w.Header().Set("X-Registry-Checksum", "sha256="+e.SHA256)
+	w.Header().Set("X-Registry-Signature", "ed25519="+e.Signature)
+	w.Header().Set("X-Registry-Key", e.KeyFingerprint)
+	w.Write(content)
+}
+
+// GET /api/registry/public-key — открытый ключ для проверки подписи.
+func registryPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, err := registrySigningKey()
+	if err != nil {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	respondJSON(w, map[string]string{
+		"algorithm":   "ed25519",
+		"public_key":  base64.StdEncoding.EncodeToString(pub),
+		"fingerprint": keyFingerprint(pub),
+	})
+}
+
+/* ---------- схемы полей ---------- */
+
+// GET /api/registry/fields — поля документа для схемы.
+func registryFieldsList(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, registrySources)
+}
+
+// GET /api/registry/mappings — сохранённые схемы; default есть всегда.
+func registryMappingsList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT name FROM registry_mappings ORDER BY name`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	list := []RegistryMapping{}
+	hasDefault := false
+	for _, name := range names {
+		m, err := loadRegistryMapping(name)
+		if err != nil {
+			log.Printf("Reyestr sxemasi %s: %v", name, err)
+			continue
+		}
+		hasDefault = hasDefault || name == defaultRegistryMapping.Name
+		list = append(list, m)
+	}
+	if !hasDefault {
+		list = append([]RegistryMapping{defaultRegistryMapping}, list...)
+	}
+	respondJSON(w, list)
+}
+
+// PUT /api/registry/mappings/{name} {root, record, fields, updated_by, role}
+func registryMappingSave(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RegistryMapping
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(input.Role, []string{roleDirector}); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	m := input.RegistryMapping
+	m.Name = mux.Vars(r)["name"]
+	if err := validateRegistryMapping(&m); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	fields, _ := json.Marshal(m.Fields)
+	_, err := db.Exec(`
+		INSERT INTO registry_mappings (name, root, record, fields, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET root=EXCLUDED.root, record=EXCLUDED.record, fields=EXCLUDED.fields,
+			updated_by=EXCLUDED.updated_by, updated_at=NOW()`,
+		m.Name, m.Root, m.Record, string(fields), nullIfEmpty(strings.TrimSpace(m.UpdatedBy)))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	saved, err := loadRegistryMapping(m.Name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, saved)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/* =========================
+   CERTIFICATE REVOCATION
+========================= */
+
+var errDocumentRevoked = errors.New("Guvohnoma bekor qilingan")
+
+// Статус отзыва для проверок перед изменением или отправкой документа.
+func documentRevoked(id int) (bool, error) {
+	var revoked bool
+	err := db.QueryRow(`SELECT revoked_at IS NOT NULL FROM documents WHERE id=$1`, id).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return false, errDocumentNotFound
+	}
+	return revoked, err
+}
+
+// Отзыв предназначен директору. Роль, как и в возвратах, сообщает
+// клиент в теле запроса и сервер её не проверяет: это защита от ошибки
+// в интерфейсе, а не контроль доступа. Ограничивать доступ к этому
+// маршруту нужно на уровне шлюза или авторизации перед API.
+var revokeRoles = []string{roleDirector}
+
+// POST /api/documents/{id}/revoke {reason, revoked_by, role} — свидетельство
+// остаётся в базе, но при проверке показывается как недействительное.
+func documentRevoke(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri guvohnoma ID", 400)
+		return
+	}
+	var input struct {
+		Reason    string `json:"reason"`
+		RevokedBy string `json:"revoked_by"`
+		Role      string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(input.Role, revokeRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		http.Error(w, "Bekor qilish sababi ko'rsatilmagan", 400)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	var revoked bool
+	err = tx.QueryRow(`SELECT revoked_at IS NOT NULL FROM documents WHERE id=$1 FOR UPDATE`, id).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Guvohnoma topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if revoked {
+		http.Error(w, "Guvohnoma allaqachon bekor qilingan", 409)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE documents SET revoked_at=NOW(), revoke_reason=$2, revoked_by=$3 WHERE id=$1`,
+		id, input.Reason, nullIfEmpty(strings.TrimSpace(input.RevokedBy)))
+	if err == nil {
+		err = emitWebhook(tx, webhookDocumentRevoked, id, "")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Guvohnomani bekor qilish xatosi: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	jobs.notify()
+	log.Printf("Guvohnoma ID %d bekor qilindi: %s", id, input.Reason)
+
+	doc, err := loadDocument(id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, doc)
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+/* =========================
+   SCHEMA
+========================= */
+
+// Таблицы students, documents, invoices и users создаются вне приложения.
+// Здесь только то, что добавлялось позже: все запросы идемпотентны и
+// выполняются при каждом старте.
+var migrations = []string{
+	// Экзаменационные комиссии
+	`CREATE TABLE IF NOT EXISTS commissions (
+		id            SERIAL PRIMARY KEY,
+		order_number  TEXT NOT NULL,
+		order_date    DATE,
+		chairperson   TEXT NOT NULL DEFAULT '',
+		director_name TEXT NOT NULL DEFAULT '',
+		active        BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS commission_members (
+		id            SERIAL PRIMARY KEY,
+		commission_id INTEGER NOT NULL REFERENCES commissions(id) ON DELETE CASCADE,
+		full_name     TEXT NOT NULL,
+		position      TEXT NOT NULL DEFAULT '',
+		role          TEXT NOT NULL DEFAULT 'member'
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_sessions (
+		id            SERIAL PRIMARY KEY,
+		commission_id INTEGER NOT NULL REFERENCES commissions(id),
+		exam_date     DATE NOT NULL,
+		location      TEXT NOT NULL DEFAULT '',
+		created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_results (
+		session_id      INTEGER NOT NULL REFERENCES exam_sessions(id) ON DELETE CASCADE,
+		student_jshshir TEXT NOT NULL,
+		grade1          INTEGER,
+		grade2          INTEGER,
+		PRIMARY KEY (session_id, student_jshshir)
+	)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS commission_id INTEGER REFERENCES commissions(id)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES exam_sessions(id)`,
+
+	// Курсы и правила оценивания
+	`CREATE TABLE IF NOT EXISTS courses (
+		id            SERIAL PRIMARY KEY,
+		name          TEXT NOT NULL UNIQUE,
+		min_theory    INTEGER NOT NULL DEFAULT 3,
+		min_practice  INTEGER NOT NULL DEFAULT 3,
+		max_attempts  INTEGER NOT NULL DEFAULT 3,
+		theory_weight INTEGER NOT NULL DEFAULT 50,
+		min_final     NUMERIC(4,2) NOT NULL DEFAULT 3,
+		created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_attempts (
+		id              SERIAL PRIMARY KEY,
+		student_jshshir TEXT NOT NULL,
+		course_id       INTEGER NOT NULL REFERENCES courses(id),
+		session_id      INTEGER REFERENCES exam_sessions(id),
+		attempt_no      INTEGER NOT NULL,
+		grade1          INTEGER NOT NULL,
+		grade2          INTEGER NOT NULL,
+		final_score     NUMERIC(4,2) NOT NULL,
+		passed          BOOLEAN NOT NULL,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (session_id, student_jshshir)
+	)`,
+	`ALTER TABLE exam_sessions ADD COLUMN IF NOT EXISTS course_id INTEGER REFERENCES courses(id)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS course_id INTEGER REFERENCES courses(id)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS final_score NUMERIC(4,2)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS exam_result TEXT`,
+
+	// Справочник категорий техники; коды A–F — те, что уже есть в форме
+	`CREATE TABLE IF NOT EXISTS machine_categories (
+		code           TEXT PRIMARY KEY,
+		description_uz TEXT NOT NULL DEFAULT '',
+		description_ru TEXT NOT NULL DEFAULT '',
+		training_hours INTEGER NOT NULL DEFAULT 0,
+		active         BOOLEAN NOT NULL DEFAULT TRUE
+	)`,
+	`INSERT INTO machine_categories (code, description_uz, description_ru)
+	 VALUES ('A', 'A toifasi', 'Категория A'), ('B', 'B toifasi', 'Категория B'),
+	        ('C', 'C toifasi', 'Категория C'), ('D', 'D toifasi', 'Категория D'),
+	        ('E', 'E toifasi', 'Категория E'), ('F', 'F toifasi', 'Категория F')
+	 ON CONFLICT (code) DO NOTHING`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS category_codes TEXT[]`,
+	`UPDATE documents
+	 SET category_codes = ARRAY(
+		SELECT upper(trim(c))
+		FROM unnest(string_to_array(categories, ',')) WITH ORDINALITY AS t(c, n)
+		WHERE trim(c) <> ''
+		ORDER BY n)
+	 WHERE category_codes IS NULL AND categories IS NOT NULL`,
+
+	// Сроки действия сертификатов
+	`ALTER TABLE machine_categories ADD COLUMN IF NOT EXISTS validity_months INTEGER`,
+	`ALTER TABLE courses ADD COLUMN IF NOT EXISTS validity_months INTEGER`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS expires_at DATE`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS expired BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE INDEX IF NOT EXISTS documents_expires_at_idx ON documents (expires_at) WHERE expires_at IS NOT NULL`,
+
+	// Учебные группы и преподаватели
+	`CREATE TABLE IF NOT EXISTS study_groups (
+		id         SERIAL PRIMARY KEY,
+		name       TEXT NOT NULL,
+		course_id  INTEGER REFERENCES courses(id),
+		start_date DATE,
+		end_date   DATE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS group_students (
+		group_id        INTEGER NOT NULL REFERENCES study_groups(id) ON DELETE CASCADE,
+		student_jshshir TEXT NOT NULL,
+		PRIMARY KEY (group_id, student_jshshir)
+	)`,
+	`CREATE TABLE IF NOT EXISTS instructors (
+		id             SERIAL PRIMARY KEY,
+		full_name      TEXT NOT NULL,
+		kind           TEXT NOT NULL,
+		qualifications TEXT[] NOT NULL DEFAULT '{}',
+		phone          TEXT NOT NULL DEFAULT '',
+		email          TEXT NOT NULL DEFAULT '',
+		active         BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at     TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS group_instructors (
+		group_id      INTEGER NOT NULL REFERENCES study_groups(id) ON DELETE CASCADE,
+		instructor_id INTEGER NOT NULL REFERENCES instructors(id),
+		subject       TEXT NOT NULL DEFAULT '',
+		hours         INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (group_id, instructor_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_instructors (
+		session_id    INTEGER NOT NULL REFERENCES exam_sessions(id) ON DELETE CASCADE,
+		instructor_id INTEGER NOT NULL REFERENCES instructors(id),
+		PRIMARY KEY (session_id, instructor_id)
+	)`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS instructor_id INTEGER REFERENCES instructors(id)`,
+
+	// Платежи по счетам
+	`CREATE TABLE IF NOT EXISTS payments (
+		id          SERIAL PRIMARY KEY,
+		invoice_id  INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+		amount      NUMERIC(14,2) NOT NULL CHECK (amount > 0),
+		method      TEXT NOT NULL,
+		received_by TEXT NOT NULL DEFAULT '',
+		paid_at     DATE NOT NULL DEFAULT CURRENT_DATE,
+		created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS payments_invoice_id_idx ON payments (invoice_id)`,
+	// Уже оплаченные счета переносим в журнал одним платежом
+	`INSERT INTO payments (invoice_id, amount, method, received_by, paid_at)
+	 SELECT i.id, i.amount, 'cash', '', COALESCE(NULLIF(i.payment_date::text, '')::date, i.created_at::date)
+	 FROM invoices i
+	 WHERE i.status = 'To''landi' AND i.amount > 0
+	   AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.invoice_id = i.id)`,
+
+	// График оплаты частями
+	`CREATE TABLE IF NOT EXISTS invoice_installments (
+		id         SERIAL PRIMARY KEY,
+		invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+		seq        INTEGER NOT NULL,
+		amount     NUMERIC(14,2) NOT NULL CHECK (amount > 0),
+		due_date   DATE NOT NULL,
+		UNIQUE (invoice_id, seq)
+	)`,
+	`CREATE INDEX IF NOT EXISTS invoice_installments_due_date_idx ON invoice_installments (due_date)`,
+
+	// Прейскурант, строки счёта и скидки
+	`CREATE TABLE IF NOT EXISTS services (
+		id            SERIAL PRIMARY KEY,
+		code          TEXT NOT NULL UNIQUE,
+		name          TEXT NOT NULL,
+		kind          TEXT NOT NULL,
+		category_code TEXT REFERENCES machine_categories(code),
+		price         NUMERIC(14,2) NOT NULL CHECK (price > 0),
+		active        BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at    TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS invoice_items (
+		id              SERIAL PRIMARY KEY,
+		invoice_id      INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+		service_id      INTEGER REFERENCES services(id),
+		name            TEXT NOT NULL,
+		quantity        INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+		unit_price      NUMERIC(14,2) NOT NULL,
+		discount_type   TEXT,
+		discount_value  NUMERIC(14,2),
+		discount_reason TEXT,
+		discount_amount NUMERIC(14,2) NOT NULL DEFAULT 0,
+		total           NUMERIC(14,2) NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS invoice_items_invoice_id_idx ON invoice_items (invoice_id)`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subtotal NUMERIC(14,2)`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS discount_type TEXT`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS discount_value NUMERIC(14,2)`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS discount_reason TEXT`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(14,2) NOT NULL DEFAULT 0`,
+
+	// Признак просрочки, обновляется фоновой задачей
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS overdue BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	// Транзакции Payme и Click; время — в миллисекундах, как в протоколе Payme
+	`CREATE TABLE IF NOT EXISTS merchant_transactions (
+		id            SERIAL PRIMARY KEY,
+		provider      TEXT NOT NULL,
+		external_id   TEXT NOT NULL,
+		invoice_id    INTEGER NOT NULL REFERENCES invoices(id),
+		amount        NUMERIC(14,2) NOT NULL,
+		state         INTEGER NOT NULL,
+		provider_time BIGINT NOT NULL DEFAULT 0,
+		create_time   BIGINT NOT NULL,
+		perform_time  BIGINT NOT NULL DEFAULT 0,
+		cancel_time   BIGINT NOT NULL DEFAULT 0,
+		reason        INTEGER,
+		payment_id    INTEGER REFERENCES payments(id) ON DELETE SET NULL,
+		created_at    TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (provider, external_id)
+	)`,
+
+	// Кредит-ноты (возвраты по платежам)
+	`CREATE TABLE IF NOT EXISTS credit_notes (
+		id         SERIAL PRIMARY KEY,
+		invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+		payment_id INTEGER NOT NULL REFERENCES payments(id),
+		amount     NUMERIC(14,2) NOT NULL CHECK (amount > 0),
+		reason     TEXT NOT NULL,
+		issued_by  TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		issued_at  DATE NOT NULL DEFAULT CURRENT_DATE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS credit_notes_invoice_id_idx ON credit_notes (invoice_id)`,
+
+	// Дата отмены счёта — для выписки студента
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS cancelled_at DATE`,
+
+	// Индексы для финансовых отчётов по периодам
+	`CREATE INDEX IF NOT EXISTS payments_paid_at_idx ON payments (paid_at)`,
+	`CREATE INDEX IF NOT EXISTS credit_notes_issued_at_idx ON credit_notes (issued_at)`,
+	`CREATE INDEX IF NOT EXISTS invoices_cancelled_at_idx ON invoices (cancelled_at) WHERE cancelled_at IS NOT NULL`,
+
+	// Долг студента на момент выдачи свидетельства и разрешение директора
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS fee_outstanding NUMERIC(14,2) NOT NULL DEFAULT 0`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS fee_override_reason TEXT`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS fee_override_by TEXT`,
+
+	// Суммы счёта — точное десятичное с двумя знаками (тийины), как в
+	// остальных денежных таблицах. Тип меняется только один раз.
+	`DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'invoices' AND column_name = 'amount'
+				AND (data_type <> 'numeric' OR numeric_scale IS DISTINCT FROM 2)) THEN
+			ALTER TABLE invoices ALTER COLUMN amount TYPE NUMERIC(14,2) USING round(amount::numeric, 2);
+		END IF;
+	END $$`,
+	`ALTER TABLE invoices ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'UZS'`,
+
+	// Нумерация счетов по годам: счётчик на префикс и год
+	`CREATE TABLE IF NOT EXISTS invoice_number_sequences (
+		prefix     TEXT NOT NULL,
+		year       INTEGER NOT NULL,
+		last_value INTEGER NOT NULL DEFAULT 0 CHECK (last_value >= 0),
+		PRIMARY KEY (prefix, year)
+	)`,
+	// Счета, оставшиеся без номера после сбоя старой нумерации
+	`UPDATE invoices SET invoice_number = 'INV-' || LPAD(id::text, 6, '0')
+	 WHERE invoice_number IS NULL OR invoice_number = ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS invoices_invoice_number_key ON invoices (invoice_number)`,
+
+	// Журнал SMS-уведомлений; (channel, template, ref) не даёт отправить одно событие дважды
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id              SERIAL PRIMARY KEY,
+		channel         TEXT NOT NULL DEFAULT 'sms',
+		template        TEXT NOT NULL,
+		ref             TEXT,
+		student_jshshir TEXT,
+		recipient       TEXT NOT NULL,
+		body            TEXT NOT NULL,
+		status          TEXT NOT NULL DEFAULT 'pending',
+		provider        TEXT,
+		provider_id     TEXT,
+		error           TEXT,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+		sent_at         TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS notifications_ref_key ON notifications (channel, template, ref)`,
+	`CREATE INDEX IF NOT EXISTS notifications_student_idx ON notifications (student_jshshir)`,
+
+	// Чаты Telegram-бота; pending_jshshir — JShShIR, ожидающий подтверждения телефона
+	`CREATE TABLE IF NOT EXISTS telegram_chats (
+		chat_id         BIGINT PRIMARY KEY,
+		student_jshshir TEXT,
+		pending_jshshir TEXT,
+		phone           TEXT,
+		linked_at       TIMESTAMP,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS telegram_chats_student_idx ON telegram_chats (student_jshshir)`,
+
+	// Очередь писем и журнал попыток отправки
+	`CREATE TABLE IF NOT EXISTS email_messages (
+		id              SERIAL PRIMARY KEY,
+		kind            TEXT NOT NULL,
+		ref_id          INTEGER NOT NULL,
+		recipients      TEXT NOT NULL,
+		subject         TEXT NOT NULL,
+		body            TEXT NOT NULL,
+		status          TEXT NOT NULL DEFAULT 'pending',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		last_error      TEXT,
+		requested_by    TEXT,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+		sent_at         TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS email_messages_queue_idx ON email_messages (next_attempt_at) WHERE status = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS email_attempts (
+		id           SERIAL PRIMARY KEY,
+		message_id   INTEGER NOT NULL REFERENCES email_messages(id) ON DELETE CASCADE,
+		attempt      INTEGER NOT NULL,
+		attempted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		success      BOOLEAN NOT NULL,
+		error        TEXT,
+		duration_ms  BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS email_attempts_message_idx ON email_attempts (message_id)`,
+
+	// Очередь фоновых задач (outbox)
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id           BIGSERIAL PRIMARY KEY,
+		kind         TEXT NOT NULL,
+		payload      JSONB NOT NULL DEFAULT '{}',
+		status       TEXT NOT NULL DEFAULT 'queued',
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 5,
+		run_at       TIMESTAMP NOT NULL DEFAULT NOW(),
+		locked_at    TIMESTAMP,
+		locked_by    TEXT,
+		last_error   TEXT,
+		dedup_key    TEXT,
+		created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+		finished_at  TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS jobs_queue_idx ON jobs (run_at, id) WHERE status = 'queued'`,
+	`CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, kind)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS jobs_dedup_key ON jobs (dedup_key)`,
+	// Повторы писем теперь ведёт очередь задач
+	`ALTER TABLE email_messages DROP COLUMN IF EXISTS next_attempt_at`,
+
+	// Отзыв свидетельства
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS revoke_reason TEXT`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS revoked_by TEXT`,
+
+	// Исходящие webhooks
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id          SERIAL PRIMARY KEY,
+		url         TEXT NOT NULL,
+		secret      TEXT NOT NULL,
+		events      TEXT[] NOT NULL,
+		description TEXT,
+		active      BOOLEAN NOT NULL DEFAULT TRUE,
+		created_by  TEXT,
+		created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at  TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id               SERIAL PRIMARY KEY,
+		subscription_id  INTEGER NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+		event_id         TEXT NOT NULL,
+		event            TEXT NOT NULL,
+		payload          TEXT NOT NULL,
+		status           TEXT NOT NULL DEFAULT 'pending',
+		attempts         INTEGER NOT NULL DEFAULT 0,
+		last_status_code INTEGER,
+		last_error       TEXT,
+		created_at       TIMESTAMP NOT NULL DEFAULT NOW(),
+		delivered_at     TIMESTAMP,
+		UNIQUE (subscription_id, event_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_status_idx ON webhook_deliveries (subscription_id, status)`,
+	`CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id            SERIAL PRIMARY KEY,
+		delivery_id   INTEGER NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		attempt       INTEGER NOT NULL,
+		attempted_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+		status_code   INTEGER,
+		success       BOOLEAN NOT NULL,
+		error         TEXT,
+		response_body TEXT,
+		duration_ms   BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_idx ON webhook_attempts (delivery_id)`,
+
+	// Выгрузка реестра свидетельств для инспекции
+	`CREATE TABLE IF NOT EXISTS registry_mappings (
+		name       TEXT PRIMARY KEY,
+		root       TEXT NOT NULL,
+		record     TEXT NOT NULL,
+		fields     JSONB NOT NULL,
+		updated_by TEXT,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS registry_exports (
+		id              SERIAL PRIMARY KEY,
+		batch_number    TEXT NOT NULL UNIQUE,
+		period_from     DATE NOT NULL,
+		period_to       DATE NOT NULL,
+		format          TEXT NOT NULL,
+		mapping         TEXT NOT NULL,
+		record_count    INTEGER NOT NULL,
+		size            INTEGER NOT NULL,
+		sha256          TEXT NOT NULL,
+		signature       TEXT NOT NULL,
+		key_fingerprint TEXT NOT NULL,
+		content         BYTEA NOT NULL,
+		created_by      TEXT,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+
+	// Вложения студентов и документов
+	`CREATE TABLE IF NOT EXISTS attachments (
+		id           SERIAL PRIMARY KEY,
+		owner_type   TEXT NOT NULL,
+		owner_ref    TEXT NOT NULL,
+		kind         TEXT NOT NULL,
+		filename     TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size         INTEGER NOT NULL,
+		sha256       TEXT NOT NULL,
+		width        INTEGER,
+		height       INTEGER,
+		storage_key  TEXT NOT NULL,
+		thumb_key    TEXT,
+		uploaded_by  TEXT,
+		created_at   TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS attachments_owner_idx ON attachments (owner_type, owner_ref, kind)`,
+
+	// Допуск к обучению: требования категорий и справки студентов
+	`ALTER TABLE machine_categories ADD COLUMN IF NOT EXISTS min_age INTEGER`,
+	`ALTER TABLE machine_categories ADD COLUMN IF NOT EXISTS medical_required BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE study_groups ADD COLUMN IF NOT EXISTS category_codes TEXT[]`,
+	`CREATE TABLE IF NOT EXISTS student_prerequisites (
+		id              SERIAL PRIMARY KEY,
+		student_jshshir TEXT NOT NULL,
+		kind            TEXT NOT NULL,
+		number          TEXT NOT NULL,
+		issued_by       TEXT NOT NULL DEFAULT '',
+		issued_at       DATE,
+		expires_at      DATE NOT NULL,
+		attachment_id   INTEGER REFERENCES attachments(id) ON DELETE SET NULL,
+		note            TEXT NOT NULL DEFAULT '',
+		created_by      TEXT,
+		created_at      TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS student_prerequisites_student_idx ON student_prerequisites (student_jshshir, kind, expires_at)`,
+
+	// Старые статусы документов (свободный текст) — к active/inactive/archived.
+	// Нераспознанные остаются как есть и проверяются только при изменении.
+	`UPDATE documents SET status = CASE
+		WHEN status IS NULL OR btrim(status) = '' THEN 'active'
+		WHEN lower(btrim(status)) IN ('active', 'faol', 'tasdiqlangan', 'berilgan') THEN 'active'
+		WHEN lower(btrim(status)) IN ('inactive', 'faol emas', 'nofaol') THEN 'inactive'
+		WHEN lower(btrim(status)) IN ('archived', 'arxiv', 'arxivlangan') THEN 'archived'
+		ELSE status END
+	 WHERE status IS NULL OR status NOT IN ('active', 'inactive', 'archived')`,
+
+	// Комиссии старых документов: по одной на пару номер приказа + директор,
+	// нерабочие (для новых документов не предлагаются). Документ привязывается
+	// к своей, поэтому правка не меняет напечатанного директора.
+	`INSERT INTO commissions (order_number, director_name, active)
+	 SELECT DISTINCT btrim(d.commission_number), COALESCE(btrim(d.director_name), ''), FALSE
+	 FROM documents d
+	 WHERE d.commission_id IS NULL AND btrim(COALESCE(d.commission_number, '')) <> ''
+	   AND NOT EXISTS (SELECT 1 FROM commissions c
+	       WHERE c.order_number = btrim(d.commission_number)
+	         AND c.director_name = COALESCE(btrim(d.director_name), ''))`,
+	`UPDATE documents d SET commission_id = (
+		SELECT c.id FROM commissions c
+		WHERE c.order_number = btrim(d.commission_number)
+		  AND c.director_name = COALESCE(btrim(d.director_name), '')
+		ORDER BY c.active DESC, c.id LIMIT 1)
+	 WHERE d.commission_id IS NULL AND btrim(COALESCE(d.commission_number, '')) <> ''`,
+
+	// Приглашённые на сессию студенты: связь хранится данными,
+	// а не выводится из журнала SMS. Старые приглашения — из журнала.
+	`CREATE TABLE IF NOT EXISTS session_students (
+		session_id      INTEGER NOT NULL REFERENCES exam_sessions(id) ON DELETE CASCADE,
+		student_jshshir TEXT NOT NULL,
+		invited_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (session_id, student_jshshir)
+	)`,
+	`CREATE INDEX IF NOT EXISTS session_students_student_idx ON session_students (student_jshshir)`,
+	`INSERT INTO session_students (session_id, student_jshshir)
+	 SELECT DISTINCT s.id, n.student_jshshir
+	 FROM notifications n
+	 JOIN exam_sessions s ON n.ref LIKE 'exam:' || s.id || ':%'
+	 WHERE n.template = 'exam_scheduled' AND COALESCE(n.student_jshshir, '') <> ''
+	 ON CONFLICT DO NOTHING`,
+}
+
+func migrate() error {
+	for i, q := range migrations {
+		if _, err := db.Exec(q); err != nil {
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+	}
+	log.Printf("✅ Migratsiyalar bajarildi (%d ta)", len(migrations))
+	return nil
+}
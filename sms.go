@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* =========================
+   SMS GATEWAYS
+========================= */
+
+// Шлюз отправки SMS. Send возвращает идентификатор сообщения у провайдера.
+// key — постоянный ключ сообщения (номер уведомления): повтор отправки
+// с тем же ключом шлюз может отбросить как дубль. Пустой — разовая отправка.
+type SMSSender interface {
+	Name() string
+	Send(ctx context.Context, key, phone, text string) (string, error)
+}
+
+var smsHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+func envDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// Провайдер выбирается переменной SMS_PROVIDER:
+//
+//	eskiz      — notify.eskiz.uz (ESKIZ_EMAIL, ESKIZ_PASSWORD, ESKIZ_FROM);
+//	playmobile — Playmobile broker API (PLAYMOBILE_LOGIN, PLAYMOBILE_PASSWORD, PLAYMOBILE_ORIGINATOR);
+//	file       — запись в файл SMS_FAKE_FILE для локальной проверки;
+//	console    — только в лог (по умолчанию).
+func newSMSSender() (SMSSender, error) {
+	switch p := strings.ToLower(envDefault("SMS_PROVIDER", "console")); p {
+	case "eskiz":
+		s := &eskizSender{
+			baseURL:  strings.TrimRight(envDefault("ESKIZ_URL", "https://notify.eskiz.uz/api"), "/"),
+			email:    os.Getenv("ESKIZ_EMAIL"),
+			password: os.Getenv("ESKIZ_PASSWORD"),
+			from:     envDefault("ESKIZ_FROM", "4546"),
+		}
+		if s.email == "" || s.password == "" {
+			return nil, errors.New("ESKIZ_EMAIL va ESKIZ_PASSWORD ko'rsatilmagan")
+		}
+		return s, nil
+	case "playmobile":
+		s := &playmobileSender{
+			url:        envDefault("PLAYMOBILE_URL", "https://send.smsxabar.uz/broker-api/send"),
+			login:      os.Getenv("PLAYMOBILE_LOGIN"),
+			password:   os.Getenv("PLAYMOBILE_PASSWORD"),
+			originator: envDefault("PLAYMOBILE_ORIGINATOR", "3700"),
+		}
+		if s.login == "" || s.password == "" {
+			return nil, errors.New("PLAYMOBILE_LOGIN va PLAYMOBILE_PASSWORD ko'rsatilmagan")
+		}
+		return s, nil
+	case "file":
+		return &fileSender{path: envDefault("SMS_FAKE_FILE", "sms-outbox.log")}, nil
+	case "console":
+		return consoleSender{}, nil
+	default:
+		return nil, fmt.Errorf("SMS_PROVIDER=%q noma'lum (eskiz, playmobile, file, console)", p)
+	}
+}
+
+// Номер в международном формате без плюса: 998901234567.
+// Девятизначный местный номер дополняется кодом страны.
+func normalizePhone(phone string) (string, error) {
+	var digits strings.Builder
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			digits.WriteRune(c)
+		}
+	}
+	d := digits.String()
+	if len(d) == 9 {
+		d = "998" + d
+	}
+	if len(d) != 12 || !strings.HasPrefix(d, "998") {
+		return "", fmt.Errorf("Telefon raqami noto'g'ri: %q", phone)
+	}
+	return d, nil
+}
+
+/* ---------- Eskiz ---------- */
+
+type eskizSender struct {
+	baseURL, email, password, from string
+
+	mu    sync.Mutex
+	token string
+}
+
+func (s *eskizSender) Name() string { return "eskiz" }
+
+func eskizForm(fields map[string]string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	w.Close()
+	return &body, w.FormDataContentType()
+}
+
+// Токен Eskiz живёт 30 дней; получаем его при первой отправке
+// и заново, если API ответило 401.
+func (s *eskizSender) login(ctx context.Context) (string, error) {
+	body, contentType := eskizForm(map[string]string{"email": s.email, "password": s.password})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/auth/login", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := smsHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Message string `json:"message"`
+		Data    struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("eskiz login: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || out.Data.Token == "" {
+		return "", fmt.Errorf("eskiz login: %s %s", resp.Status, out.Message)
+	}
+	return out.Data.Token, nil
+}
+
+func (s *eskizSender) Send(ctx context.Context, key, phone, text string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.token == "" {
+			token, err := s.login(ctx)
+			if err != nil {
+				return "", err
+			}
+			s.token = token
+		}
+
+		body, contentType := eskizForm(map[string]string{
+			"mobile_phone": phone,
+			"message":      text,
+			"from":         s.from,
+		})
+		req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/message/sms/send", body)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+s.token)
+
+		resp, err := smsHTTPClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized {
+			s.token = ""
+			continue
+		}
+		var out struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		}
+		json.Unmarshal(data, &out)
+		if resp.StatusCode != http.StatusOK || out.Status == "error" {
+			return "", fmt.Errorf("eskiz: %s %s", resp.Status, strings.TrimSpace(out.Message+" "+string(data)))
+		}
+		return out.ID, nil
+	}
+	return "", errors.New("eskiz: avtorizatsiya xatosi")
+}
+
+/* ---------- Playmobile ---------- */
+
+type playmobileSender struct {
+	url, login, password, originator string
+}
+
+func (s *playmobileSender) Name() string { return "playmobile" }
+
+func (s *playmobileSender) Send(ctx context.Context, key, phone, text string) (string, error) {
+	// message-id: до 40 латинских букв и цифр, уникален в пределах аккаунта.
+	// Берётся из ключа, чтобы повтор после таймаута шлюз отбросил как дубль.
+	id := "trk" + key
+	if key == "" {
+		id = fmt.Sprintf("trk%d", time.Now().UnixNano())
+	}
+	payload := map[string]interface{}{
+		"messages": []map[string]interface{}{{
+			"recipient":  phone,
+			"message-id": id,
+			"sms": map[string]interface{}{
+				"originator": s.originator,
+				"content":    map[string]string{"text": text},
+			},
+		}},
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(s.login, s.password)
+
+	resp, err := smsHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("playmobile: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return id, nil
+}
+
+/* ---------- локальные заглушки ---------- */
+
+// fileSender дописывает сообщения в файл построчно в JSON — удобно
+// проверять тексты шаблонов без реальной отправки.
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSender) Name() string { return "file" }
+
+func (s *fileSender) Send(ctx context.Context, key, phone, text string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	id := fmt.Sprintf("file-%d", time.Now().UnixNano())
+	line, _ := json.Marshal(map[string]string{
+		"id":    id,
+		"time":  time.Now().Format(time.RFC3339),
+		"phone": phone,
+		"text":  text,
+	})
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+type consoleSender struct{}
+
+func (consoleSender) Name() string { return "console" }
+
+func (consoleSender) Send(ctx context.Context, key, phone, text string) (string, error) {
+	log.Printf("📱 SMS %s: %s", phone, text)
+	return fmt.Sprintf("console-%d", time.Now().UnixNano()), nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   STUDENT STATEMENT
+========================= */
+
+// Строка выписки. Debit увеличивает долг студента (начисление,
+// возврат денег), Credit уменьшает (оплата, кредит-нота, отмена счёта).
+type StatementLine struct {
+	Date        string `json:"date"`
+	Kind        string `json:"kind"` // invoice | payment | credit_note | refund | cancellation
+	Reference   string `json:"reference"`
+	Description string `json:"description"`
+	Debit       Money  `json:"debit"`
+	Credit      Money  `json:"credit"`
+	Balance     Money  `json:"balance"`
+}
+
+type Statement struct {
+	StudentJSHSHIR string          `json:"student_jshshir"`
+	StudentName    string          `json:"student_name"`
+	StudentPhone   string          `json:"student_phone"`
+	From           string          `json:"from,omitempty"`
+	To             string          `json:"to,omitempty"`
+	OpeningBalance Money           `json:"opening_balance"`
+	Lines          []StatementLine `json:"lines"`
+	TotalDebit     Money           `json:"total_debit"`
+	TotalCredit    Money           `json:"total_credit"`
+	ClosingBalance Money           `json:"closing_balance"`
+}
+
+var statementKindNames = map[string]string{
+	"invoice":      "Hisob-faktura",
+	"payment":      "To'lov",
+	"credit_note":  "Kredit-nota",
+	"refund":       "Pul qaytarildi",
+	"cancellation": "Bekor qilindi",
+}
+
+// Все движения студента по порядку. Кредит-нота даёт две строки —
+// уменьшение начисления и выплату денег, поэтому баланс она не меняет.
+// По отменённому счёту снимается неоплаченная часть, как в loadStudentBalance.
+const statementQuery = `
+	SELECT d, kind, ref, descr, debit, credit FROM (
+		SELECT COALESCE(NULLIF(i.issue_date::text, ''), to_char(i.created_at, 'YYYY-MM-DD')) AS d,
+			1 AS ord, i.id AS oid, 'invoice' AS kind,
+			COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')) AS ref,
+			COALESCE(i.description, '') AS descr, i.amount AS debit, 0::numeric AS credit
+		FROM invoices i WHERE i.student_jshshir = $1
+
+		UNION ALL
+		SELECT to_char(p.paid_at, 'YYYY-MM-DD'), 2, p.id, 'payment',
+			'KV-' || LPAD(p.id::text, 6, '0'),
+			COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')) || ', ' || p.method,
+			0, p.amount
+		FROM payments p JOIN invoices i ON i.id = p.invoice_id
+		WHERE i.student_jshshir = $1
+
+		UNION ALL
+		SELECT to_char(cn.issued_at, 'YYYY-MM-DD'), 3, cn.id, 'credit_note',
+			'CN-' || LPAD(cn.id::text, 6, '0'), cn.reason, 0, cn.amount
+		FROM credit_notes cn JOIN invoices i ON i.id = cn.invoice_id
+		WHERE i.student_jshshir = $1
+
+		UNION ALL
+		SELECT to_char(cn.issued_at, 'YYYY-MM-DD'), 4, cn.id, 'refund',
+			'CN-' || LPAD(cn.id::text, 6, '0'), 'KV-' || LPAD(cn.payment_id::text, 6, '0'), cn.amount, 0
+		FROM credit_notes cn JOIN invoices i ON i.id = cn.invoice_id
+		WHERE i.student_jshshir = $1
+
+		UNION ALL
+		SELECT COALESCE(to_char(i.cancelled_at, 'YYYY-MM-DD'),
+				NULLIF(i.issue_date::text, ''), to_char(i.created_at, 'YYYY-MM-DD')),
+			5, i.id, 'cancellation',
+			COALESCE(i.invoice_number, 'INV-' || LPAD(i.id::text, 6, '0')), '',
+			0, i.amount - x.paid
+		FROM invoices i,
+		LATERAL (SELECT COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0) AS paid) x
+		WHERE i.student_jshshir = $1 AND i.status = $2 AND i.amount > x.paid
+	) lines
+	ORDER BY d, ord, oid`
+
+func loadStatement(jshshir, from, to string) (Statement, error) {
+	st := Statement{StudentJSHSHIR: jshshir, From: from, To: to, Lines: []StatementLine{}}
+	err := db.QueryRow(`SELECT full_name, COALESCE(phone, '') FROM students WHERE jshshir=$1`, jshshir).
+		Scan(&st.StudentName, &st.StudentPhone)
+	if err == sql.ErrNoRows {
+		return st, errStudentNotFound
+	} else if err != nil {
+		return st, err
+	}
+
+	rows, err := db.Query(statementQuery, jshshir, invoiceStatusCancelled)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+
+	var balance Money
+	for rows.Next() {
+		var l StatementLine
+		if err := rows.Scan(&l.Date, &l.Kind, &l.Reference, &l.Description, &l.Debit, &l.Credit); err != nil {
+			return st, err
+		}
+		balance += l.Debit - l.Credit
+		l.Balance = balance
+
+		switch {
+		case from != "" && l.Date < from:
+			st.OpeningBalance = balance
+		case to != "" && l.Date > to:
+			continue
+		default:
+			st.Lines = append(st.Lines, l)
+			st.TotalDebit += l.Debit
+			st.TotalCredit += l.Credit
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return st, err
+	}
+
+	st.ClosingBalance = st.OpeningBalance + st.TotalDebit - st.TotalCredit
+	return st, nil
+}
+
+// GET /api/students/{jshshir}/statement?from=&to=&format=pdf
+func studentStatement(w http.ResponseWriter, r *http.Request) {
+	jshshir := mux.Vars(r)["jshshir"]
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	for _, d := range []string{from, to} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			http.Error(w, "Noto'g'ri sana (YYYY-MM-DD)", 400)
+			return
+		}
+	}
+
+	st, err := loadStatement(jshshir, from, to)
+	if err == errStudentNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if q.Get("format") == "pdf" {
+		writePDF(w, "statement-"+jshshir+".pdf", renderStatementPDF(st))
+		return
+	}
+	respondJSON(w, st)
+}
+
+func renderStatementPDF(st Statement) []byte {
+	org := loadOrganization()
+	d := newPDF()
+	y := pdfHeader(d, org, "TALABA HISOB KO'CHIRMASI")
+
+	period := "barcha davr"
+	if st.From != "" || st.To != "" {
+		period = fmt.Sprintf("%s - %s", st.From, st.To)
+	}
+	y = pdfKeyValue(d, y, "Talaba:", st.StudentName)
+	y = pdfKeyValue(d, y, "JShShIR:", st.StudentJSHSHIR)
+	if st.StudentPhone != "" {
+		y = pdfKeyValue(d, y, "Telefon:", st.StudentPhone)
+	}
+	y = pdfKeyValue(d, y, "Davr:", period)
+	y += 15
+
+	right := pdfPageWidth - pdfMargin
+	// Sana | Hujjat | Izoh | Debet | Kredit | Qoldiq
+	cols := []float64{pdfMargin, pdfMargin + 62, pdfMargin + 150, pdfMargin + 305, pdfMargin + 375, pdfMargin + 445, right}
+	header := func() {
+		d.Line(cols[0], y-12, right, y-12)
+		d.Text(cols[0]+2, y, 9, true, "Sana")
+		d.Text(cols[1]+2, y, 9, true, "Hujjat")
+		d.Text(cols[2]+2, y, 9, true, "Izoh")
+		d.TextRight(cols[4]-2, y, 9, true, "Debet")
+		d.TextRight(cols[5]-2, y, 9, true, "Kredit")
+		d.TextRight(cols[6]-2, y, 9, true, "Qoldiq")
+		y += 6
+		d.Line(cols[0], y, right, y)
+		y += 14
+	}
+	header()
+
+	d.Text(cols[2]+2, y, 9, false, "Davr boshidagi qoldiq")
+	d.TextRight(cols[6]-2, y, 9, true, formatMoney(st.OpeningBalance))
+	y += 14
+
+	money := func(v Money) string {
+		if v == 0 {
+			return ""
+		}
+		return formatMoney(v)
+	}
+	for _, l := range st.Lines {
+		if y > pdfPageHeight-pdfMargin-60 {
+			d.AddPage()
+			y = pdfMargin + 12
+			header()
+		}
+		descr := statementKindNames[l.Kind]
+		if l.Description != "" {
+			descr += ": " + l.Description
+		}
+		if r := []rune(descr); len(r) > 34 {
+			descr = string(r[:31]) + "..."
+		}
+		d.Text(cols[0]+2, y, 8, false, l.Date)
+		d.Text(cols[1]+2, y, 8, false, l.Reference)
+		d.Text(cols[2]+2, y, 8, false, descr)
+		d.TextRight(cols[4]-2, y, 8, false, money(l.Debit))
+		d.TextRight(cols[5]-2, y, 8, false, money(l.Credit))
+		d.TextRight(cols[6]-2, y, 8, false, formatMoney(l.Balance))
+		y += 13
+	}
+
+	d.Line(cols[0], y-8, right, y-8)
+	y += 6
+	d.Text(cols[2]+2, y, 9, true, "Davr aylanmasi")
+	d.TextRight(cols[4]-2, y, 9, true, formatMoney(st.TotalDebit))
+	d.TextRight(cols[5]-2, y, 9, true, formatMoney(st.TotalCredit))
+	y += 14
+	d.Text(cols[2]+2, y, 9, true, "Davr oxiridagi qoldiq")
+	d.TextRight(cols[6]-2, y, 9, true, formatMoney(st.ClosingBalance))
+	y += 30
+
+	if y > pdfPageHeight-pdfMargin-80 {
+		d.AddPage()
+		y = pdfMargin + 12
+	}
+	d.Text(pdfMargin, y, 8, false, "Chop etildi: "+time.Now().Format("2006-01-02 15:04"))
+	y += 30
+	pdfSignatures(d, y, []string{"Bosh hisobchi: " + org.Accountant})
+
+	return d.Bytes()
+}
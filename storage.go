@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* =========================
+   FILE STORAGE
+========================= */
+
+// Хранилище файлов вложений. Ключ — относительный путь вида
+// students/<jshshir>/<имя>; его выбирает приложение, не пользователь.
+type FileStorage interface {
+	Name() string
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var errFileNotFound = errors.New("Fayl omborda topilmadi")
+
+// Хранилище выбирается переменной STORAGE_DRIVER:
+//
+//	local — каталог STORAGE_DIR (по умолчанию uploads);
+//	s3    — S3-совместимое хранилище (AWS, MinIO, Yandex Object Storage):
+//	        S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY, S3_SECRET_KEY,
+//	        S3_PATH_STYLE (true по умолчанию, для MinIO).
+func newFileStorage() (FileStorage, error) {
+	switch d := strings.ToLower(envDefault("STORAGE_DRIVER", "local")); d {
+	case "local":
+		return &localStorage{dir: envDefault("STORAGE_DIR", "uploads")}, nil
+	case "s3":
+		s := &s3Storage{
+			endpoint:  strings.TrimRight(envDefault("S3_ENDPOINT", "https://s3.amazonaws.com"), "/"),
+			region:    envDefault("S3_REGION", "us-east-1"),
+			bucket:    os.Getenv("S3_BUCKET"),
+			accessKey: os.Getenv("S3_ACCESS_KEY"),
+			secretKey: os.Getenv("S3_SECRET_KEY"),
+			pathStyle: envDefault("S3_PATH_STYLE", "true") != "false",
+		}
+		if s.bucket == "" || s.accessKey == "" || s.secretKey == "" {
+			return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY va S3_SECRET_KEY ko'rsatilmagan")
+		}
+		if _, err := url.Parse(s.endpoint); err != nil {
+			return nil, fmt.Errorf("S3_ENDPOINT noto'g'ri: %v", err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("STORAGE_DRIVER=%q noma'lum (local, s3)", d)
+	}
+}
+
+/* ---------- локальный диск ---------- */
+
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) Name() string { return "local:" + s.dir }
+
+func (s *localStorage) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+		return "", fmt.Errorf("noto'g'ri kalit: %q", key)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Через временный файл, чтобы при сбое не остался обрезанный файл
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, errFileNotFound
+	}
+	return data, err
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/* ---------- S3 ---------- */
+
+// Запросы к S3 подписываются AWS Signature V4 вручную — SDK не нужен.
+type s3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+}
+
+var s3HTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+func (s *s3Storage) Name() string { return "s3:" + s.bucket }
+
+func (s *s3Storage) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u, nil
+}
+
+// URI-кодирование по правилам SigV4: всё, кроме A-Z a-z 0-9 - _ . ~ и «/».
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, signature))
+}
+
+func (s *s3Storage) do(ctx context.Context, method, key, contentType string, body []byte) ([]byte, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := s3HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errFileNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := string(data)
+		if len(msg) > 300 {
+			msg = msg[:300]
+		}
+		return nil, fmt.Errorf("S3 %s %s: HTTP %d: %s", method, key, resp.StatusCode, msg)
+	}
+	return data, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	_, err := s.do(ctx, "PUT", key, contentType, data)
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, "GET", key, "", nil)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DELETE", key, "", nil)
+	if err == errFileNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/* =========================
+   TELEGRAM BOT
+========================= */
+
+// Бот для студентов включается переменной TELEGRAM_BOT_TOKEN.
+// Обновления забираются long polling'ом (getUpdates), поэтому
+// публичный адрес и вебхук не нужны.
+//
+//	TELEGRAM_API_URL      — адрес Bot API (по умолчанию https://api.telegram.org;
+//	                        для локальной проверки — go run ./cmd/tgfake);
+//	TELEGRAM_POLL_TIMEOUT — таймаут long polling в секундах (по умолчанию 25).
+//
+// Чат привязывается к студенту в два шага: студент присылает JShShIR,
+// затем делится своим контактом; номер должен совпасть с телефоном
+// в карточке студента.
+
+// Кнопки меню; те же действия доступны командами.
+const (
+	tgButtonBalance     = "💰 Balansim"
+	tgButtonExam        = "📅 Imtihon sanam"
+	tgButtonCertificate = "📄 Guvohnomam"
+	tgButtonContact     = "📱 Telefon raqamni yuborish"
+)
+
+// Сколько последних свидетельств отправлять по запросу.
+const tgCertificateLimit = 3
+
+type telegramBot struct {
+	api, token  string
+	pollTimeout int
+	client      *http.Client
+}
+
+// nil, если бот не настроен.
+var tgBot *telegramBot
+
+type tgUpdate struct {
+	UpdateID int64      `json:"update_id"`
+	Message  *tgMessage `json:"message"`
+}
+
+type tgMessage struct {
+	MessageID int64      `json:"message_id"`
+	From      *tgUser    `json:"from"`
+	Chat      tgChat     `json:"chat"`
+	Text      string     `json:"text"`
+	Contact   *tgContact `json:"contact"`
+}
+
+type tgUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+}
+
+type tgChat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type tgContact struct {
+	PhoneNumber string `json:"phone_number"`
+	UserID      int64  `json:"user_id"`
+}
+
+type tgKeyboardButton struct {
+	Text           string `json:"text"`
+	RequestContact bool   `json:"request_contact,omitempty"`
+}
+
+type tgReplyKeyboard struct {
+	Keyboard        [][]tgKeyboardButton `json:"keyboard"`
+	ResizeKeyboard  bool                 `json:"resize_keyboard"`
+	OneTimeKeyboard bool                 `json:"one_time_keyboard,omitempty"`
+}
+
+type tgRemoveKeyboard struct {
+	RemoveKeyboard bool `json:"remove_keyboard"`
+}
+
+var tgMenu = tgReplyKeyboard{
+	Keyboard: [][]tgKeyboardButton{
+		{{Text: tgButtonBalance}, {Text: tgButtonExam}},
+		{{Text: tgButtonCertificate}},
+	},
+	ResizeKeyboard: true,
+}
+
+var tgContactKeyboard = tgReplyKeyboard{
+	Keyboard:        [][]tgKeyboardButton{{{Text: tgButtonContact, RequestContact: true}}},
+	ResizeKeyboard:  true,
+	OneTimeKeyboard: true,
+}
+
+func initTelegramBot() {
+	token := strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	if token == "" {
+		log.Printf("Telegram bot o'chirilgan (TELEGRAM_BOT_TOKEN ko'rsatilmagan)")
+		return
+	}
+	timeout, err := strconv.Atoi(envDefault("TELEGRAM_POLL_TIMEOUT", "25"))
+	if err != nil || timeout < 0 {
+		timeout = 25
+	}
+	tgBot = &telegramBot{
+		api:         strings.TrimRight(envDefault("TELEGRAM_API_URL", "https://api.telegram.org"), "/"),
+		token:       token,
+		pollTimeout: timeout,
+		client:      &http.Client{Timeout: time.Duration(timeout+15) * time.Second},
+	}
+	go tgBot.run()
+	log.Printf("🤖 Telegram bot ishga tushdi: %s", tgBot.api)
+}
+
+/* ---------- Bot API ---------- */
+
+func (b *telegramBot) methodURL(method string) string {
+	return b.api + "/bot" + b.token + "/" + method
+}
+
+func (b *telegramBot) decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	var res struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("telegram: %s", resp.Status)
+	}
+	if !res.OK {
+		return fmt.Errorf("telegram: %s", res.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
+
+func (b *telegramBot) call(ctx context.Context, method string, params, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.methodURL(method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return b.decode(resp, out)
+}
+
+func (b *telegramBot) sendMessage(chatID int64, text string, markup interface{}) error {
+	params := map[string]interface{}{"chat_id": chatID, "text": text}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+	return b.call(context.Background(), "sendMessage", params, nil)
+}
+
+func (b *telegramBot) sendDocument(chatID int64, filename string, data []byte, caption string) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	if caption != "" {
+		w.WriteField("caption", caption)
+	}
+	part, err := w.CreateFormFile("document", filename)
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	w.Close()
+
+	req, err := http.NewRequest("POST", b.methodURL("sendDocument"), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return b.decode(resp, nil)
+}
+
+func (b *telegramBot) run() {
+	var offset int64
+	for {
+		var updates []tgUpdate
+		err := b.call(context.Background(), "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         b.pollTimeout,
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if err != nil {
+			log.Printf("Telegram getUpdates xatosi: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handleMessage(u.Message)
+			}
+		}
+	}
+}
+
+/* ---------- диалог ---------- */
+
+type tgChatLink struct {
+	StudentJSHSHIR string
+	PendingJSHSHIR string
+}
+
+func loadChatLink(chatID int64) (tgChatLink, error) {
+	var l tgChatLink
+	err := db.QueryRow(`
+		SELECT COALESCE(student_jshshir, ''), COALESCE(pending_jshshir, '')
+		FROM telegram_chats WHERE chat_id=$1`, chatID,
+	).Scan(&l.StudentJSHSHIR, &l.PendingJSHSHIR)
+	if err == sql.ErrNoRows {
+		return l, nil
+	}
+	return l, err
+}
+
+func isJSHSHIR(s string) bool {
+	if len(s) != 14 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *telegramBot) reply(chatID int64, text string, markup interface{}) {
+	if err := b.sendMessage(chatID, text, markup); err != nil {
+		log.Printf("Telegram javob xatosi (chat %d): %v", chatID, err)
+	}
+}
+
+func (b *telegramBot) handleMessage(m *tgMessage) {
+	if m.Chat.Type != "" && m.Chat.Type != "private" {
+		return
+	}
+	chatID := m.Chat.ID
+	link, err := loadChatLink(chatID)
+	if err != nil {
+		log.Printf("Telegram chat %d: %v", chatID, err)
+		b.reply(chatID, "Tizim xatosi, keyinroq urinib ko'ring.", nil)
+		return
+	}
+
+	text := strings.TrimSpace(m.Text)
+	if i := strings.IndexByte(text, '@'); strings.HasPrefix(text, "/") && i > 0 {
+		text = text[:i] // /balance@bot_name
+	}
+
+	if link.StudentJSHSHIR == "" {
+		b.handleLinking(m, link, text)
+		return
+	}
+
+	switch text {
+	case "/balance", tgButtonBalance:
+		b.reply(chatID, tgBalanceText(link.StudentJSHSHIR), tgMenu)
+	case "/exam", tgButtonExam:
+		b.reply(chatID, tgExamText(link.StudentJSHSHIR), tgMenu)
+	case "/certificate", tgButtonCertificate:
+		b.sendCertificates(chatID, link.StudentJSHSHIR)
+	case "/stop":
+		if _, err := db.Exec(`DELETE FROM telegram_chats WHERE chat_id=$1`, chatID); err != nil {
+			log.Printf("Telegram chat %d ni uzish xatosi: %v", chatID, err)
+		}
+		b.reply(chatID, "Chat talaba profilidan uzildi. Qayta ulash uchun /start yuboring.", tgRemoveKeyboard{true})
+	default:
+		b.reply(chatID, "Quyidagi tugmalardan birini tanlang:\n"+
+			"/balance — balans\n/exam — imtihon sanasi\n/certificate — guvohnoma\n/stop — chatni uzish", tgMenu)
+	}
+}
+
+// Привязка чата: JShShIR → контакт → сверка телефона.
+func (b *telegramBot) handleLinking(m *tgMessage, link tgChatLink, text string) {
+	chatID := m.Chat.ID
+
+	if m.Contact != nil {
+		if link.PendingJSHSHIR == "" {
+			b.reply(chatID, "Avval JShShIR raqamingizni yuboring (14 ta raqam).", tgRemoveKeyboard{true})
+			return
+		}
+		// Пересланная карточка чужого контакта не подтверждает номер
+		if m.From == nil || m.Contact.UserID != m.From.ID {
+			b.reply(chatID, "Faqat o'zingizning telefon raqamingizni yuboring.", tgContactKeyboard)
+			return
+		}
+		b.confirmPhone(chatID, link.PendingJSHSHIR, m.Contact.PhoneNumber)
+		return
+	}
+
+	if isJSHSHIR(text) {
+		var name string
+		err := db.QueryRow(`SELECT full_name FROM students WHERE jshshir=$1`, text).Scan(&name)
+		if err == sql.ErrNoRows {
+			b.reply(chatID, "Bu JShShIR bo'yicha talaba topilmadi. Raqamni tekshirib qayta yuboring.", nil)
+			return
+		} else if err != nil {
+			log.Printf("Telegram: talaba %s: %v", text, err)
+			b.reply(chatID, "Tizim xatosi, keyinroq urinib ko'ring.", nil)
+			return
+		}
+		_, err = db.Exec(`
+			INSERT INTO telegram_chats (chat_id, pending_jshshir)
+			VALUES ($1, $2)
+			ON CONFLICT (chat_id) DO UPDATE SET pending_jshshir = EXCLUDED.pending_jshshir`,
+			chatID, text)
+		if err != nil {
+			log.Printf("Telegram chat %d: %v", chatID, err)
+			b.reply(chatID, "Tizim xatosi, keyinroq urinib ko'ring.", nil)
+			return
+		}
+		b.reply(chatID, "Tasdiqlash uchun telefon raqamingizni yuboring — pastdagi tugmani bosing.", tgContactKeyboard)
+		return
+	}
+
+	b.reply(chatID, "Assalomu alaykum! "+loadOrganization().Name+" botiga xush kelibsiz.\n"+
+		"Profilingizni ulash uchun JShShIR raqamingizni yuboring (14 ta raqam).", tgRemoveKeyboard{true})
+}
+
+func (b *telegramBot) confirmPhone(chatID int64, jshshir, contactPhone string) {
+	var name, studentPhone string
+	err := db.QueryRow(`SELECT full_name, COALESCE(phone, '') FROM students WHERE jshshir=$1`, jshshir).
+		Scan(&name, &studentPhone)
+	if err != nil {
+		log.Printf("Telegram: talaba %s: %v", jshshir, err)
+		b.reply(chatID, "Talaba topilmadi. JShShIR raqamini qayta yuboring.", tgRemoveKeyboard{true})
+		return
+	}
+
+	got, err1 := normalizePhone(contactPhone)
+	want, err2 := normalizePhone(studentPhone)
+	if err1 != nil || err2 != nil || got != want {
+		db.Exec(`UPDATE telegram_chats SET pending_jshshir=NULL WHERE chat_id=$1`, chatID)
+		log.Printf("Telegram: chat %d uchun %s telefon raqami mos kelmadi", chatID, jshshir)
+		b.reply(chatID, "Telefon raqami talaba ma'lumotlariga mos kelmadi. "+
+			"Raqamingizni yangilash uchun o'quv markaziga murojaat qiling.", tgRemoveKeyboard{true})
+		return
+	}
+
+	_, err = db.Exec(`
+		UPDATE telegram_chats
+		SET student_jshshir=$2, pending_jshshir=NULL, phone=$3, linked_at=NOW()
+		WHERE chat_id=$1`, chatID, jshshir, got)
+	if err != nil {
+		log.Printf("Telegram chat %d ni ulash xatosi: %v", chatID, err)
+		b.reply(chatID, "Tizim xatosi, keyinroq urinib ko'ring.", nil)
+		return
+	}
+	log.Printf("Telegram chat %d talaba %s ga ulandi", chatID, jshshir)
+	b.reply(chatID, "Rahmat, "+name+"! Profilingiz ulandi. Endi balans, imtihon sanasi va "+
+		"guvohnoma haqida ma'lumot olishingiz hamda eslatmalarni shu yerda qabul qilishingiz mumkin.", tgMenu)
+}
+
+/* ---------- ответы ---------- */
+
+func tgBalanceText(jshshir string) string {
+	bal, err := loadStudentBalance(jshshir)
+	if err != nil {
+		log.Printf("Telegram: talaba %s balansi: %v", jshshir, err)
+		return "Balansni olishda xatolik yuz berdi."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "💰 Balans\nHisoblangan: %s so'm\nTo'langan: %s so'm\n",
+		formatMoney(bal.Charged-bal.Credited), formatMoney(bal.Paid-bal.Refunded))
+	if bal.Balance > 0 {
+		fmt.Fprintf(&b, "Qarz: %s so'm", formatMoney(bal.Balance))
+	} else if bal.Balance < 0 {
+		fmt.Fprintf(&b, "Ortiqcha to'lov: %s so'm", formatMoney(-bal.Balance))
+	} else {
+		b.WriteString("Qarzdorlik yo'q ✅")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, COALESCE(invoice_number, '')
+		FROM invoices
+		WHERE student_jshshir=$1 AND status IN ($2, $3)
+		ORDER BY id`,
+		jshshir, invoiceStatusPending, invoiceStatusPartial)
+	if err != nil {
+		log.Printf("Telegram: talaba %s invoyislari: %v", jshshir, err)
+		return b.String()
+	}
+	type open struct {
+		id     int
+		number string
+	}
+	var list []open
+	for rows.Next() {
+		var o open
+		if rows.Scan(&o.id, &o.number) == nil {
+			list = append(list, o)
+		}
+	}
+	rows.Close()
+
+	for _, o := range list {
+		insts, err := loadInstallments(o.id)
+		if err != nil {
+			continue
+		}
+		for _, inst := range insts {
+			if inst.Status == "paid" || inst.Status == "cancelled" {
+				continue
+			}
+			fmt.Fprintf(&b, "\n\n%s: %s so'm, muddat %s", o.number, formatMoney(inst.Amount-inst.PaidAmount), inst.DueDate)
+			if inst.Status == "overdue" {
+				b.WriteString(" ⚠️ muddati o'tgan")
+			}
+			break
+		}
+	}
+	return b.String()
+}
+
+// Ближайшая сессия студента: по внесённым результатам или по
+// приглашению на сессию (session_students).
+func tgExamText(jshshir string) string {
+	var date, location, course string
+	err := db.QueryRow(`
+		SELECT to_char(s.exam_date, 'YYYY-MM-DD'), s.location, COALESCE(c.name, '')
+		FROM exam_sessions s
+		LEFT JOIN courses c ON c.id = s.course_id
+		WHERE s.exam_date >= CURRENT_DATE AND (
+			EXISTS (SELECT 1 FROM exam_results er WHERE er.session_id = s.id AND er.student_jshshir = $1)
+			OR EXISTS (SELECT 1 FROM session_students ss WHERE ss.session_id = s.id AND ss.student_jshshir = $1))
+		ORDER BY s.exam_date, s.id
+		LIMIT 1`, jshshir,
+	).Scan(&date, &location, &course)
+	if err == sql.ErrNoRows {
+		return "Yaqin kunlarda imtihon belgilanmagan."
+	} else if err != nil {
+		log.Printf("Telegram: talaba %s imtihoni: %v", jshshir, err)
+		return "Imtihon ma'lumotini olishda xatolik yuz berdi."
+	}
+
+	text := "📅 Imtihon sanasi: " + date
+	if course != "" {
+		text += "\nKurs: " + course
+	}
+	if location != "" {
+		text += "\nManzil: " + location
+	}
+	return text
+}
+
+func studentDocumentIDs(jshshir string, limit int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT id FROM documents
+		WHERE student_jshshir=$1 AND COALESCE(certificate_number, '') <> ''
+		  AND revoked_at IS NULL
+		ORDER BY id DESC LIMIT $2`, jshshir, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func certificateCaption(doc DocumentOutput) string {
+	caption := "Guvohnoma № " + doc.CertificateNo
+	if doc.Categories != "" {
+		caption += "\nToifalar: " + doc.Categories
+	}
+	if doc.ExpiresAt != "" {
+		caption += "\nAmal qilish muddati: " + doc.ExpiresAt
+	}
+	if doc.Expired {
+		caption += "\n⚠️ Muddati o'tgan"
+	}
+	return caption
+}
+
+func (b *telegramBot) sendCertificates(chatID int64, jshshir string) {
+	ids, err := studentDocumentIDs(jshshir, tgCertificateLimit)
+	if err != nil {
+		log.Printf("Telegram: talaba %s guvohnomalari: %v", jshshir, err)
+		b.reply(chatID, "Guvohnomani olishda xatolik yuz berdi.", tgMenu)
+		return
+	}
+	if len(ids) == 0 {
+		b.reply(chatID, "Guvohnoma hali tayyor emas.", tgMenu)
+		return
+	}
+	for _, id := range ids {
+		doc, err := loadDocument(id)
+		if err != nil {
+			log.Printf("Telegram: guvohnoma %d: %v", id, err)
+			continue
+		}
+		if err := b.sendDocument(chatID, certificateFilename(doc), renderCertificatePDF(doc), certificateCaption(doc)); err != nil {
+			log.Printf("Telegram: guvohnoma %d yuborilmadi: %v", id, err)
+			b.reply(chatID, "Guvohnomani yuborib bo'lmadi, keyinroq urinib ko'ring.", tgMenu)
+			return
+		}
+	}
+}
+
+/* ---------- уведомления ---------- */
+
+// Дублирует уведомление во все чаты, привязанные к студенту; отправка
+// идёт задачей telegram.deliver в той же транзакции.
+func notifyTelegram(tx *sql.Tx, code, ref, jshshir, text string) error {
+	if tgBot == nil {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT chat_id FROM telegram_chats WHERE student_jshshir=$1`, jshshir)
+	if err != nil {
+		return err
+	}
+	var chats []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		chats = append(chats, id)
+	}
+	rows.Close()
+
+	for _, chatID := range chats {
+		chatRef := ref
+		if chatRef != "" {
+			chatRef = fmt.Sprintf("%s:%d", ref, chatID)
+		}
+		var id int
+		err := tx.QueryRow(`
+			INSERT INTO notifications (channel, template, ref, student_jshshir, recipient, body, status, provider)
+			VALUES ('telegram', $1, $2, $3, $4, $5, 'pending', 'telegram')
+			ON CONFLICT DO NOTHING
+			RETURNING id`,
+			code, nullIfEmpty(chatRef), jshshir, strconv.FormatInt(chatID, 10), text,
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := enqueueJob(tx, jobTelegramDeliver, notificationJob{NotificationID: id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Задача telegram.deliver. О готовом свидетельстве приходит сразу PDF
+// с текстом уведомления в подписи.
+func deliverTelegramJob(ctx context.Context, j *Job) error {
+	var p notificationJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var code, ref, jshshir, recipient, text, status string
+	err := db.QueryRow(`
+		SELECT template, COALESCE(ref, ''), COALESCE(student_jshshir, ''), recipient, body, status
+		FROM notifications WHERE id=$1`, p.NotificationID,
+	).Scan(&code, &ref, &jshshir, &recipient, &text, &status)
+	if err == sql.ErrNoRows || (err == nil && status != "pending") {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if tgBot == nil {
+		return finishNotification(j, p.NotificationID, "", errors.New("Telegram bot o'chirilgan"))
+	}
+	chatID, err := strconv.ParseInt(recipient, 10, 64)
+	if err != nil {
+		return finishNotification(j, p.NotificationID, "", err)
+	}
+
+	if code == notifyCertificateReady {
+		certificateNo := strings.TrimSuffix(strings.TrimPrefix(ref, "certificate:"), ":"+recipient)
+		var docID int
+		err := db.QueryRow(`
+			SELECT id FROM documents WHERE student_jshshir=$1 AND certificate_number=$2
+			ORDER BY id DESC LIMIT 1`, jshshir, certificateNo,
+		).Scan(&docID)
+		if err == nil {
+			doc, err := loadDocument(docID)
+			if err != nil {
+				return err
+			}
+			// Отозванное свидетельство студенту не отправляем и не повторяем попытки
+			if doc.Revoked {
+				_, err := db.Exec(`UPDATE notifications SET status='failed', error=$2 WHERE id=$1`,
+					p.NotificationID, errDocumentRevoked.Error())
+				return err
+			}
+			err = tgBot.sendDocument(chatID, certificateFilename(doc), renderCertificatePDF(doc), text)
+			return finishNotification(j, p.NotificationID, "", err)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return finishNotification(j, p.NotificationID, "", tgBot.sendMessage(chatID, text, nil))
+}
+
+/* ---------- API ---------- */
+
+type TelegramChat struct {
+	ChatID         int64  `json:"chat_id"`
+	StudentJSHSHIR string `json:"student_jshshir,omitempty"`
+	StudentName    string `json:"student_name,omitempty"`
+	Phone          string `json:"phone,omitempty"`
+	LinkedAt       string `json:"linked_at,omitempty"`
+}
+
+// GET /api/telegram/chats?student= — привязанные чаты.
+func telegramChatsList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT t.chat_id, t.student_jshshir, COALESCE(s.full_name, ''), COALESCE(t.phone, ''),
+			to_char(t.linked_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM telegram_chats t
+		LEFT JOIN students s ON s.jshshir = t.student_jshshir
+		WHERE t.student_jshshir IS NOT NULL AND ($1 = '' OR t.student_jshshir = $1)
+		ORDER BY t.linked_at DESC`, r.URL.Query().Get("student"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []TelegramChat{}
+	for rows.Next() {
+		var c TelegramChat
+		if err := rows.Scan(&c.ChatID, &c.StudentJSHSHIR, &c.StudentName, &c.Phone, &c.LinkedAt); err != nil {
+			log.Printf("Error scanning telegram chat: %v", err)
+			continue
+		}
+		list = append(list, c)
+	}
+	respondJSON(w, list)
+}
+
+// DELETE /api/telegram/chats/{chatId} — отвязать чат (например, при смене телефона).
+func telegramChatDelete(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(strings.TrimSpace(mux.Vars(r)["chatId"]), 10, 64)
+	if err != nil {
+		http.Error(w, "Noto'g'ri chat ID", 400)
+		return
+	}
+	res, err := db.Exec(`DELETE FROM telegram_chats WHERE chat_id=$1`, chatID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Chat topilmadi", 404)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/* =========================
+   CERTIFICATE VALIDITY
+========================= */
+
+const expiryCheckInterval = time.Hour
+
+type ExpiringDocument struct {
+	ID             int    `json:"id"`
+	CertificateNo  string `json:"certificate_number"`
+	StudentJSHSHIR string `json:"student_jshshir"`
+	StudentName    string `json:"student_name"`
+	StudentPhone   string `json:"student_phone"`
+	Categories     string `json:"categories"`
+	ExamDate       string `json:"exam_date"`
+	ExpiresAt      string `json:"expires_at"`
+	DaysLeft       int    `json:"days_left"`
+	Expired        bool   `json:"expired"`
+}
+
+// Срок действия документа — самый короткий из сроков его категорий и курса,
+// отсчитывается от даты экзамена. Если ни у одной нет срока, документ бессрочный.
+func computeExpiresAt(input *DocumentInput) error {
+	input.ExpiresAt = ""
+
+	var months pq.Int64Array
+	err := db.QueryRow(`
+		SELECT COALESCE(array_agg(validity_months), '{}') FROM (
+			SELECT validity_months FROM machine_categories
+			WHERE code = ANY($1) AND validity_months IS NOT NULL
+			UNION ALL
+			SELECT validity_months FROM courses
+			WHERE id = $2 AND validity_months IS NOT NULL
+		) v`,
+		pq.Array([]string(input.Categories)), input.CourseID,
+	).Scan(&months)
+	if err != nil {
+		return err
+	}
+	if len(months) == 0 {
+		return nil
+	}
+
+	shortest := months[0]
+	for _, m := range months[1:] {
+		if m < shortest {
+			shortest = m
+		}
+	}
+
+	// Срок без даты экзамена не придумываем
+	from, err := time.Parse("2006-01-02", strings.TrimSpace(input.ExamDate))
+	if err != nil {
+		return errBadDate
+	}
+	input.ExpiresAt = from.AddDate(0, int(shortest), 0).Format("2006-01-02")
+	return nil
+}
+
+// Разбирает период вида "30d", "4w", "6m" или просто число дней.
+func parseWithin(s string) (int, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return 30, nil
+	}
+
+	unit := 1
+	switch s[len(s)-1] {
+	case 'd':
+		s = s[:len(s)-1]
+	case 'w':
+		unit, s = 7, s[:len(s)-1]
+	case 'm':
+		unit, s = 30, s[:len(s)-1]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid period")
+	}
+	return n * unit, nil
+}
+
+// GET /api/documents/expiring?within=30d — действующие документы, срок
+// которых истекает в течение периода. С expired=1 в список попадают и
+// уже просроченные.
+func documentsExpiring(w http.ResponseWriter, r *http.Request) {
+	days, err := parseWithin(r.URL.Query().Get("within"))
+	if err != nil {
+		http.Error(w, "Noto'g'ri davr (masalan: 30d, 4w, 6m)", 400)
+		return
+	}
+
+	query := `
+		SELECT d.id, COALESCE(d.certificate_number, ''), COALESCE(d.student_jshshir, ''),
+			COALESCE(d.student_name, ''), COALESCE(s.phone, ''), COALESCE(d.categories, ''),
+			COALESCE(d.exam_date::text, ''), to_char(d.expires_at, 'YYYY-MM-DD'),
+			d.expires_at - CURRENT_DATE, d.expired
+		FROM documents d
+		LEFT JOIN students s ON s.jshshir = d.student_jshshir
+		WHERE d.expires_at IS NOT NULL AND d.revoked_at IS NULL
+		  AND d.expires_at <= CURRENT_DATE + $1::int`
+	if r.URL.Query().Get("expired") == "" {
+		query += ` AND d.expires_at >= CURRENT_DATE`
+	}
+	query += ` ORDER BY d.expires_at`
+
+	rows, err := db.Query(query, days)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []ExpiringDocument{}
+	for rows.Next() {
+		var d ExpiringDocument
+		err := rows.Scan(&d.ID, &d.CertificateNo, &d.StudentJSHSHIR, &d.StudentName,
+			&d.StudentPhone, &d.Categories, &d.ExamDate, &d.ExpiresAt, &d.DaysLeft, &d.Expired)
+		if err != nil {
+			log.Printf("Error scanning expiring document: %v", err)
+			continue
+		}
+		list = append(list, d)
+	}
+
+	respondJSON(w, list)
+}
+
+// Помечает просроченные документы. Вызывается периодической задачей.
+func markExpiredDocuments() (int64, error) {
+	result, err := db.Exec(`
+		UPDATE documents SET expired = TRUE
+		WHERE expires_at < CURRENT_DATE AND NOT expired`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Периодическая задача documents.mark_expired.
+func markExpiredJob(ctx context.Context, j *Job) error {
+	n, err := markExpiredDocuments()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("⏰ %d ta guvohnoma muddati o'tgan deb belgilandi", n)
+	}
+	return nil
+}
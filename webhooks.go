@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/* =========================
+   WEBHOOKS
+========================= */
+
+// Исходящие уведомления партнёрам (инспекция и т.п.). Событие ставится
+// задачей webhook.dispatch в той же транзакции, что и изменение данных;
+// задача снимает снимок данных и создаёт по доставке на каждую активную
+// подписку, а каждую доставку отправляет отдельная задача webhook.deliver
+// с повторами. Все попытки пишутся в webhook_attempts.
+//
+// Запрос партнёру — POST с JSON-телом {"id","event","occurred_at","data"}
+// и заголовками:
+//
+//	X-Webhook-Id        — id события (одинаковый при повторах, для дедупликации);
+//	X-Webhook-Event     — тип события;
+//	X-Webhook-Timestamp — время отправки, unix-секунды;
+//	X-Webhook-Signature — sha256=<hex HMAC-SHA256(secret, timestamp + "." + body)>.
+//
+// Доставка успешна при ответе 2xx.
+//
+//	WEBHOOK_MAX_ATTEMPTS — попыток доставки (по умолчанию 8);
+//	WEBHOOK_TIMEOUT      — таймаут запроса в секундах (10).
+
+const (
+	webhookDocumentCreated = "document.created"
+	webhookDocumentRevoked = "document.revoked"
+	webhookInvoicePaid     = "invoice.paid"
+	webhookStudentCreated  = "student.created"
+	webhookPing            = "ping"
+)
+
+var webhookEvents = []struct {
+	Event       string `json:"event"`
+	Description string `json:"description"`
+}{
+	{webhookDocumentCreated, "Guvohnoma berildi"},
+	{webhookDocumentRevoked, "Guvohnoma bekor qilindi"},
+	{webhookInvoicePaid, "Invoyis to'liq to'landi"},
+	{webhookStudentCreated, "Yangi talaba qo'shildi"},
+}
+
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusDelivered = "delivered"
+	webhookStatusFailed    = "failed"
+)
+
+// Подписки меняет только директор: в них секреты партнёров.
+var webhookRoles = []string{roleDirector}
+
+var errWebhookNotFound = errors.New("Webhook obunasi topilmadi")
+
+func webhookMaxAttempts() int {
+	if n, err := strconv.Atoi(envDefault("WEBHOOK_MAX_ATTEMPTS", "8")); err == nil && n > 0 {
+		return n
+	}
+	return 8
+}
+
+func webhookTimeout() time.Duration {
+	if n, err := strconv.Atoi(envDefault("WEBHOOK_TIMEOUT", "10")); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/* ---------- события ---------- */
+
+type webhookEventJob struct {
+	ID         string `json:"id"`
+	Event      string `json:"event"`
+	RefID      int    `json:"ref_id,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+// Ставит событие в очередь, если на него кто-то подписан. q — транзакция
+// изменения, чтобы событие не ушло при откате.
+func emitWebhook(q jobQueryer, event string, refID int, ref string) error {
+	var subscribed bool
+	err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM webhook_subscriptions WHERE active AND $1 = ANY(events))`, event,
+	).Scan(&subscribed)
+	if err != nil || !subscribed {
+		return err
+	}
+	_, err = enqueueJob(q, jobWebhookDispatch, webhookEventJob{
+		ID:         "evt_" + randomToken(12),
+		Event:      event,
+		RefID:      refID,
+		Ref:        ref,
+		OccurredAt: time.Now().Format(time.RFC3339),
+	})
+	return err
+}
+
+type webhookInvoice struct {
+	ID             int    `json:"id"`
+	InvoiceNumber  string `json:"invoice_number"`
+	StudentJSHSHIR string `json:"student_jshshir"`
+	StudentName    string `json:"student_name"`
+	Amount         Money  `json:"amount"`
+	PaidAmount     Money  `json:"paid_amount"`
+	Currency       string `json:"currency"`
+	Status         string `json:"status"`
+	PaymentDate    string `json:"payment_date,omitempty"`
+}
+
+// Снимок данных события на момент рассылки. Удалённая запись
+// отдаётся только идентификатором.
+func webhookEventData(e webhookEventJob) (interface{}, error) {
+	switch e.Event {
+	case webhookDocumentCreated, webhookDocumentRevoked:
+		doc, err := loadDocument(e.RefID)
+		if err == errDocumentNotFound {
+			return map[string]interface{}{"id": e.RefID, "deleted": true}, nil
+		}
+		return doc, err
+
+	case webhookInvoicePaid:
+		var inv webhookInvoice
+		err := db.QueryRow(`
+			SELECT i.id, COALESCE(i.invoice_number, ''), i.student_jshshir, COALESCE(i.student_name, ''),
+				i.amount, COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = i.id), 0),
+				COALESCE(i.currency, 'UZS'), i.status, COALESCE(i.payment_date::text, '')
+			FROM invoices i WHERE i.id=$1`, e.RefID,
+		).Scan(&inv.ID, &inv.InvoiceNumber, &inv.StudentJSHSHIR, &inv.StudentName,
+			&inv.Amount, &inv.PaidAmount, &inv.Currency, &inv.Status, &inv.PaymentDate)
+		if err == sql.ErrNoRows {
+			return map[string]interface{}{"id": e.RefID, "deleted": true}, nil
+		}
+		inv.Status = invoiceStatusCode(inv.Status)
+		return inv, err
+
+	case webhookStudentCreated:
+		var s Student
+		err := db.QueryRow(`
+			SELECT jshshir, full_name, COALESCE(birth_date::text, ''), COALESCE(phone, '')
+			FROM students WHERE jshshir=$1`, e.Ref,
+		).Scan(&s.JSHSHIR, &s.FullName, &s.BirthDate, &s.Phone)
+		if err == sql.ErrNoRows {
+			return map[string]interface{}{"jshshir": e.Ref, "deleted": true}, nil
+		}
+		return s, err
+	}
+	return nil, fmt.Errorf("Noma'lum webhook hodisasi: %s", e.Event)
+}
+
+func webhookBody(id, event, occurredAt string, data interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":          id,
+		"event":       event,
+		"occurred_at": occurredAt,
+		"data":        data,
+	})
+}
+
+// Задача webhook.dispatch: доставка на каждую подписку события.
+// Повтор задачи не создаёт дублей — (subscription_id, event_id) уникальны.
+func webhookDispatchJob(ctx context.Context, j *Job) error {
+	var e webhookEventJob
+	if err := j.decode(&e); err != nil {
+		return err
+	}
+	data, err := webhookEventData(e)
+	if err != nil {
+		return err
+	}
+	body, err := webhookBody(e.ID, e.Event, e.OccurredAt, data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id FROM webhook_subscriptions WHERE active AND $1 = ANY(events) ORDER BY id`, e.Event)
+	if err != nil {
+		return err
+	}
+	var subs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		subs = append(subs, id)
+	}
+	rows.Close()
+
+	for _, sub := range subs {
+		if _, err := insertWebhookDelivery(tx, sub, e.ID, e.Event, body); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	jobs.notify()
+	return nil
+}
+
+type webhookDeliveryJob struct {
+	DeliveryID int `json:"delivery_id"`
+}
+
+// Возвращает id доставки; 0 — событие уже доставляется этой подписке.
+func insertWebhookDelivery(tx *sql.Tx, subscriptionID int, eventID, event string, body []byte) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event, payload, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+		RETURNING id`,
+		subscriptionID, eventID, event, string(body), webhookStatusPending,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return id, enqueueWebhookDelivery(tx, id)
+}
+
+func enqueueWebhookDelivery(tx *sql.Tx, id int) error {
+	_, err := enqueueJobWith(tx, jobWebhookDeliver, webhookDeliveryJob{DeliveryID: id},
+		jobOptions{MaxAttempts: webhookMaxAttempts()})
+	return err
+}
+
+/* ---------- доставка ---------- */
+
+var webhookClient = &http.Client{
+	// Редирект — ошибка настройки у партнёра, подпись на другой адрес не переносим
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Одна попытка POST; возвращает код ответа и начало тела для журнала.
+func postWebhook(ctx context.Context, target, secret, eventID, event string, body []byte) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout())
+	defer cancel()
+
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "traktor-backend-webhooks/1")
+	req.Header.Set("X-Webhook-Id", eventID)
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", webhookSignature(secret, ts, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(snippet), fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(snippet), nil
+}
+
+// Задача webhook.deliver: одна попытка доставки с записью в журнал.
+func webhookDeliverJob(ctx context.Context, j *Job) error {
+	var p webhookDeliveryJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	id := p.DeliveryID
+
+	var target, secret, eventID, event, payload, status string
+	var active bool
+	err := db.QueryRow(`
+		SELECT s.url, s.secret, s.active, d.event_id, d.event, d.payload, d.status
+		FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.id=$1`, id,
+	).Scan(&target, &secret, &active, &eventID, &event, &payload, &status)
+	if err == sql.ErrNoRows || (err == nil && status != webhookStatusPending) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !active {
+		_, err = db.Exec(`UPDATE webhook_deliveries SET status=$2, last_error=$3 WHERE id=$1`,
+			id, webhookStatusFailed, "obuna o'chirilgan")
+		return err
+	}
+
+	started := time.Now()
+	code, response, sendErr := postWebhook(ctx, target, secret, eventID, event, []byte(payload))
+
+	errText := ""
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	var attempt int
+	err = db.QueryRow(`
+		UPDATE webhook_deliveries SET attempts = attempts + 1, last_status_code=$2
+		WHERE id=$1 RETURNING attempts`, id, nullIfZero(code),
+	).Scan(&attempt)
+	if err == nil {
+		_, err = db.Exec(`
+			INSERT INTO webhook_attempts (delivery_id, attempt, status_code, success, error, response_body, duration_ms)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			id, attempt, nullIfZero(code), sendErr == nil, nullIfEmpty(errText), nullIfEmpty(response),
+			time.Since(started).Milliseconds())
+	}
+	if err != nil {
+		log.Printf("Webhook #%d urinishini yozish xatosi: %v", id, err)
+	}
+
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries SET status=$2, last_error=NULL, delivered_at=NOW() WHERE id=$1`,
+			id, webhookStatusDelivered)
+		log.Printf("🔔 Webhook #%d (%s) yetkazildi: %s", id, event, target)
+	case j.lastAttempt():
+		_, err = db.Exec(`UPDATE webhook_deliveries SET status=$2, last_error=$3 WHERE id=$1`,
+			id, webhookStatusFailed, errText)
+	default:
+		_, err = db.Exec(`UPDATE webhook_deliveries SET last_error=$2 WHERE id=$1`, id, errText)
+	}
+	if err != nil {
+		log.Printf("Webhook #%d holatini yangilash xatosi: %v", id, err)
+	}
+	return sendErr
+}
+
+/* ---------- подписки ---------- */
+
+type WebhookSubscription struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+	Active      bool     `json:"active"`
+	// Секрет показывается только при создании и смене
+	Secret    string `json:"secret,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	CreatedAt string `json:"created_at"`
+	Pending   int    `json:"pending"`
+	Failed    int    `json:"failed"`
+}
+
+type WebhookInput struct {
+	URL          string   `json:"url"`
+	Events       []string `json:"events"`
+	Description  string   `json:"description"`
+	Active       *bool    `json:"active"`
+	Secret       string   `json:"secret"`
+	RotateSecret bool     `json:"rotate_secret"`
+	CreatedBy    string   `json:"created_by"`
+	Role         string   `json:"role"`
+}
+
+func validateWebhookInput(in *WebhookInput) error {
+	in.URL = strings.TrimSpace(in.URL)
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("URL noto'g'ri (http:// yoki https:// bilan boshlanishi kerak)")
+	}
+	if len(in.Events) == 0 {
+		return errors.New("Kamida bitta hodisa tanlang")
+	}
+	seen := map[string]bool{}
+	var events []string
+	for _, e := range in.Events {
+		e = strings.TrimSpace(e)
+		if !isWebhookEvent(e) {
+			return fmt.Errorf("Noma'lum hodisa: %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	in.Events = events
+	if in.Secret != "" && len(in.Secret) < 16 {
+		return errors.New("Maxfiy kalit kamida 16 belgidan iborat bo'lishi kerak")
+	}
+	return nil
+}
+
+const webhookColumns = `
+	s.id, s.url, s.events, COALESCE(s.description, ''), s.active, COALESCE(s.created_by, ''),
+	to_char(s.created_at, 'YYYY-MM-DD HH24:MI:SS'),
+	(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id = s.id AND d.status = 'pending'),
+	(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id = s.id AND d.status = 'failed')`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }, s *WebhookSubscription) error {
+	var events pq.StringArray
+	err := row.Scan(&s.ID, &s.URL, &events, &s.Description, &s.Active, &s.CreatedBy,
+		&s.CreatedAt, &s.Pending, &s.Failed)
+	s.Events = events
+	return err
+}
+
+func loadWebhook(id int) (WebhookSubscription, error) {
+	var s WebhookSubscription
+	err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhook_subscriptions s WHERE s.id=$1`, id), &s)
+	if err == sql.ErrNoRows {
+		return s, errWebhookNotFound
+	}
+	return s, err
+}
+
+// GET /api/webhooks/events
+func webhookEventsList(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, webhookEvents)
+}
+
+// GET /api/webhooks
+func webhooksList(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT ` + webhookColumns + ` FROM webhook_subscriptions s ORDER BY s.id`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []WebhookSubscription{}
+	for rows.Next() {
+		var s WebhookSubscription
+		if err := scanWebhook(rows, &s); err != nil {
+			log.Printf("Error scanning webhook: %v", err)
+			continue
+		}
+		list = append(list, s)
+	}
+	respondJSON(w, list)
+}
+
+func webhookGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	s, err := loadWebhook(id)
+	if err == errWebhookNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	respondJSON(w, s)
+}
+
+// POST /api/webhooks {url, events, description, secret?, created_by, role}
+func webhookCreate(w http.ResponseWriter, r *http.Request) {
+	var in WebhookInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(in.Role, webhookRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	if err := validateWebhookInput(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	secret := in.Secret
+	if secret == "" {
+		secret = "whsec_" + randomToken(24)
+	}
+	active := in.Active == nil || *in.Active
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO webhook_subscriptions (url, secret, events, description, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		in.URL, secret, pq.Array(in.Events), nullIfEmpty(in.Description), active, nullIfEmpty(in.CreatedBy),
+	).Scan(&id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s, err := loadWebhook(id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.Secret = secret
+	log.Printf("Webhook obunasi #%d: %s %v", id, in.URL, in.Events)
+	w.WriteHeader(http.StatusCreated)
+	respondJSON(w, s)
+}
+
+// PUT /api/webhooks/{id} {url, events, description, active, rotate_secret, role}
+func webhookUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	var in WebhookInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(in.Role, webhookRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	if err := validateWebhookInput(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	secret := in.Secret
+	if secret == "" && in.RotateSecret {
+		secret = "whsec_" + randomToken(24)
+	}
+
+	res, err := db.Exec(`
+		UPDATE webhook_subscriptions
+		SET url=$2, events=$3, description=$4, active=COALESCE($5, active),
+			secret=COALESCE($6, secret), updated_at=NOW()
+		WHERE id=$1`,
+		id, in.URL, pq.Array(in.Events), nullIfEmpty(in.Description), in.Active, nullIfEmpty(secret))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, errWebhookNotFound.Error(), 404)
+		return
+	}
+	s, err := loadWebhook(id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.Secret = secret
+	respondJSON(w, s)
+}
+
+// DELETE /api/webhooks/{id} {role} — вместе с историей доставок.
+func webhookDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	var in struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Noto'g'ri ma'lumot", 400)
+		return
+	}
+	if err := checkRole(in.Role, webhookRoles); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	res, err := db.Exec(`DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, errWebhookNotFound.Error(), 404)
+		return
+	}
+	respondJSON(w, map[string]string{"status": "deleted"})
+}
+
+// POST /api/webhooks/{id}/ping — пробное событие только этой подписке.
+func webhookPingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	if _, err := loadWebhook(id); err == errWebhookNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	eventID := "evt_" + randomToken(12)
+	body, _ := webhookBody(eventID, webhookPing, time.Now().Format(time.RFC3339),
+		map[string]int{"subscription_id": id})
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+	deliveryID, err := insertWebhookDelivery(tx, id, eventID, webhookPing, body)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	jobs.notify()
+	w.WriteHeader(http.StatusAccepted)
+	respondJSON(w, map[string]interface{}{"status": "queued", "delivery_id": deliveryID, "event_id": eventID})
+}
+
+/* ---------- история доставок ---------- */
+
+type WebhookDelivery struct {
+	ID             int                  `json:"id"`
+	SubscriptionID int                  `json:"subscription_id"`
+	EventID        string               `json:"event_id"`
+	Event          string               `json:"event"`
+	Payload        json.RawMessage      `json:"payload,omitempty"`
+	Status         string               `json:"status"`
+	Attempts       int                  `json:"attempts"`
+	LastStatusCode int                  `json:"last_status_code,omitempty"`
+	LastError      string               `json:"last_error,omitempty"`
+	CreatedAt      string               `json:"created_at"`
+	DeliveredAt    string               `json:"delivered_at,omitempty"`
+	Log            []WebhookAttemptInfo `json:"log,omitempty"`
+}
+
+type WebhookAttemptInfo struct {
+	Attempt     int    `json:"attempt"`
+	AttemptedAt string `json:"attempted_at"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+	Response    string `json:"response,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+}
+
+const webhookDeliveryColumns = `
+	id, subscription_id, event_id, event, status, attempts, COALESCE(last_status_code, 0),
+	COALESCE(last_error, ''), to_char(created_at, 'YYYY-MM-DD HH24:MI:SS'),
+	COALESCE(to_char(delivered_at, 'YYYY-MM-DD HH24:MI:SS'), '')`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, d *WebhookDelivery) error {
+	return row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.Event, &d.Status, &d.Attempts,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+}
+
+// GET /api/webhooks/{id}/deliveries?status=&event=
+func webhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	q := r.URL.Query()
+	rows, err := db.Query(`SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries
+		WHERE subscription_id=$1 AND ($2 = '' OR status = $2) AND ($3 = '' OR event = $3)
+		ORDER BY id DESC
+		LIMIT 500`, id, q.Get("status"), q.Get("event"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	list := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d); err != nil {
+			log.Printf("Error scanning webhook delivery: %v", err)
+			continue
+		}
+		list = append(list, d)
+	}
+	respondJSON(w, list)
+}
+
+// GET /api/webhooks/{id}/deliveries/{deliveryId} — доставка с телом и журналом попыток.
+func webhookDeliveryGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	deliveryID, err := pathID(r, "deliveryId")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	var d WebhookDelivery
+	err = scanWebhookDelivery(db.QueryRow(`SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries WHERE id=$1 AND subscription_id=$2`, deliveryID, id), &d)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Yetkazish topilmadi", 404)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var payload string
+	db.QueryRow(`SELECT payload FROM webhook_deliveries WHERE id=$1`, deliveryID).Scan(&payload)
+	d.Payload = json.RawMessage(payload)
+
+	rows, err := db.Query(`
+		SELECT attempt, to_char(attempted_at, 'YYYY-MM-DD HH24:MI:SS'), COALESCE(status_code, 0), success,
+			COALESCE(error, ''), COALESCE(response_body, ''), duration_ms
+		FROM webhook_attempts WHERE delivery_id=$1 ORDER BY attempt`, deliveryID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	d.Log = []WebhookAttemptInfo{}
+	for rows.Next() {
+		var a WebhookAttemptInfo
+		if err := rows.Scan(&a.Attempt, &a.AttemptedAt, &a.StatusCode, &a.Success, &a.Error,
+			&a.Response, &a.DurationMS); err != nil {
+			log.Printf("Error scanning webhook attempt: %v", err)
+			continue
+		}
+		d.Log = append(d.Log, a)
+	}
+	respondJSON(w, d)
+}
+
+// POST /api/webhooks/{id}/deliveries/{deliveryId}/redeliver — отправить
+// то же событие ещё раз (тот же id и тело, новая подпись).
+func webhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	deliveryID, err := pathID(r, "deliveryId")
+	if err != nil {
+		http.Error(w, "Noto'g'ri ID", 400)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE webhook_deliveries SET status=$3, delivered_at=NULL
+		WHERE id=$1 AND subscription_id=$2 AND status<>$3`,
+		deliveryID, id, webhookStatusPending)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Yetkazish topilmadi yoki hali navbatda", 409)
+		return
+	}
+	if err := enqueueWebhookDelivery(tx, deliveryID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	jobs.notify()
+	respondJSON(w, map[string]string{"status": "queued"})
+}